@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"os"
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	appendBase          string
+	appendImage         string
+	appendBaseSecret    string
+	appendBaseNamespace string
+	appendSecret        string
+	appendNamespace     string
+	appendOptions       utility.AppendOptions
+)
+
+// appendCmd represents the append command
+var appendCmd = &cobra.Command{
+	Use:   "append",
+	Short: "Add layers to a base image and push the result without a Docker daemon",
+	Long: `Build a new image from a base image in a registry by adding layers and changing
+its runtime configuration, then push it, all without a Docker daemon.
+
+Each --layer is a tar file (plain or gzip compressed) or a directory whose contents
+are placed at the image root, and becomes one new layer on top of the base image.
+--entrypoint, --cmd, --workdir and --user replace the corresponding settings of
+the base image; as in a Dockerfile, setting the entrypoint clears the base image's
+cmd unless --cmd is given too.
+
+When the base is on the same registry as the destination, base layers are
+mounted (or reused if already present), so only the new layers are uploaded. A
+base on another registry is streamed through without touching the disk.`,
+	Example: `  # Add a built binary to a distroless base image
+  repo-lister append \
+    --base gcr.io/distroless/static:nonroot \
+    --image myregistry.io/app:v1.0.0 \
+    --layer ./rootfs \
+    --entrypoint /app/server \
+    --secret registry-cred
+
+  # Add a tar file as a layer to the arm64 image of a multi-arch base
+  repo-lister append \
+    --base docker.io/library/alpine:3.20 \
+    --image myregistry.io/tools:arm64 \
+    --layer tools.tar.gz \
+    --platform linux/arm64 \
+    --secret registry-cred
+
+  # Only change how the image runs
+  repo-lister append \
+    --base myregistry.io/app:v1.0.0 \
+    --image myregistry.io/app:v1.0.0-debug \
+    --cmd=--log-level=debug \
+    --user 1000:1000 \
+    --base-secret registry-cred \
+    --secret registry-cred`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the AppendImage function from the utility package
+		err := utility.AppendImage(
+			cmd.Context(),
+			appendBase,
+			appendImage,
+			appendBaseSecret,
+			appendBaseNamespace,
+			appendSecret,
+			appendNamespace,
+			appendOptions,
+		)
+		if err != nil {
+			cmd.PrintErrln("Error appending to image:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(appendCmd)
+
+	// Define flags for the append command
+	appendCmd.Flags().StringVarP(&appendBase, "base", "b", "", "Base image reference (e.g., registry.io/base:tag) (required)")
+	appendCmd.Flags().StringVarP(&appendImage, "image", "i", "", "Destination image reference (e.g., registry.io/image:tag) (required)")
+	appendCmd.Flags().StringArrayVarP(&appendOptions.Layers, "layer", "l", nil, "Tar file or directory to add as a new layer; repeatable")
+	appendCmd.Flags().StringVar(&appendOptions.Platform, "platform", "", "Image to extend when the base is multi-arch (e.g., linux/arm64; default linux/amd64)")
+	appendCmd.Flags().StringSliceVar(&appendOptions.Entrypoint, "entrypoint", nil, "Set the entrypoint, as comma-separated arguments (e.g., /app/server,--port=8080)")
+	appendCmd.Flags().StringSliceVar(&appendOptions.Cmd, "cmd", nil, "Set the default arguments, as comma-separated arguments")
+	appendCmd.Flags().StringVar(&appendOptions.Workdir, "workdir", "", "Set the working directory")
+	appendCmd.Flags().StringVar(&appendOptions.User, "user", "", "Set the user the container runs as (e.g., 1000:1000 or nonroot)")
+	appendCmd.Flags().StringVar(&appendBaseSecret, "base-secret", "", "Kubernetes secret name for base registry authentication (optional for public registries)")
+	appendCmd.Flags().StringVar(&appendBaseNamespace, "base-namespace", "default", "Kubernetes namespace for base secret")
+	appendCmd.Flags().StringVarP(&appendSecret, "secret", "s", "", "Kubernetes secret name for destination registry authentication (optional for public registries)")
+	appendCmd.Flags().StringVarP(&appendNamespace, "namespace", "n", "default", "Kubernetes namespace for destination secret")
+
+	// Mark required flags
+	_ = appendCmd.MarkFlagRequired("base")
+	_ = appendCmd.MarkFlagRequired("image")
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	bundleImagesFile   string
+	bundleOut          string
+	bundlePath         string
+	bundleRegistry     string
+	bundleSecret       string
+	bundleNamespace    string
+	bundleShowProgress bool
+)
+
+// bundleCmd represents the bundle command
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Move many images to disconnected sites in a single archive",
+	Long: `Export a list of images into one OCI image layout archive and import it into a
+registry on the other side of an air gap.
+
+The archive is a tar of a standard OCI image layout. Multi-arch indexes are kept
+with every platform, and a bundle.json file records the original reference of each
+image so import can recreate the same repository paths and tags under a new
+registry.`,
+	Example: `  # On a connected machine
+  repo-lister bundle export --images images.txt --out bundle.tar --secret regcred
+
+  # On the disconnected site
+  repo-lister bundle import --bundle bundle.tar --registry registry.local:5000 --secret local-cred`,
+}
+
+// bundleExportCmd represents the bundle export command
+var bundleExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the images in a list to a bundle archive",
+	Long: `Export every image listed in a file (one reference per line, blank lines and
+lines starting with # are ignored) to a single OCI image layout archive.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the ExportBundle function from the utility package
+		err := utility.ExportBundle(cmd.Context(), bundleImagesFile, bundleOut, bundleSecret, bundleNamespace)
+		if err != nil {
+			cmd.PrintErrln("Error exporting bundle:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// bundleImportCmd represents the bundle import command
+var bundleImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Push every image in a bundle archive to a registry",
+	Long: `Push every image in a bundle created by 'bundle export' to a registry. Each image
+keeps its repository path and tag under the target, so docker.io/library/nginx:1.27
+is pushed to <registry>/library/nginx:1.27.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the ImportBundle function from the utility package
+		err := utility.ImportBundle(cmd.Context(), bundlePath, bundleRegistry, bundleSecret, bundleNamespace, bundleShowProgress)
+		if err != nil {
+			cmd.PrintErrln("Error importing bundle:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleExportCmd, bundleImportCmd)
+
+	// Flags shared by export and import
+	bundleCmd.PersistentFlags().StringVarP(&bundleSecret, "secret", "s", "", "Kubernetes secret name for registry authentication (optional for public registries)")
+	bundleCmd.PersistentFlags().StringVarP(&bundleNamespace, "namespace", "n", "default", "Kubernetes namespace where the secret is located")
+
+	// Define flags for the bundle export command
+	bundleExportCmd.Flags().StringVarP(&bundleImagesFile, "images", "f", "", "File listing the image references to export, one per line (required)")
+	bundleExportCmd.Flags().StringVarP(&bundleOut, "out", "o", "", "Output path for the bundle archive (required)")
+	_ = bundleExportCmd.MarkFlagRequired("images")
+	_ = bundleExportCmd.MarkFlagRequired("out")
+
+	// Define flags for the bundle import command
+	bundleImportCmd.Flags().StringVarP(&bundlePath, "bundle", "b", "", "Bundle archive created by 'bundle export' (required)")
+	bundleImportCmd.Flags().StringVarP(&bundleRegistry, "registry", "r", "", "Target registry or prefix to push the images to (required)")
+	bundleImportCmd.Flags().BoolVarP(&bundleShowProgress, "progress", "p", false, "Show progress while pushing each image")
+	_ = bundleImportCmd.MarkFlagRequired("bundle")
+	_ = bundleImportCmd.MarkFlagRequired("registry")
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"repo-lister/utility"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var cachePruneOlderThan time.Duration
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local layer cache",
+	Long: `Manage the layer cache selected with the global --cache-dir flag.
+
+When --cache-dir is set, pull and copy keep every layer they download in the
+directory, keyed by digest, and reuse it the next time any image needs the same
+layer. Copies between repositories of one registry mount blobs server-side instead
+and do not use the cache.`,
+	Example: `  # Show what is cached
+  repo-lister cache ls --cache-dir ~/.cache/repo-lister
+
+  # Drop layers that have not been used for a week
+  repo-lister cache prune --cache-dir ~/.cache/repo-lister --older-than 168h`,
+}
+
+// cacheLsCmd represents the cache ls command
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached layers, most recently used first",
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := utility.ListCache(rootCacheDir)
+		if err != nil {
+			cmd.PrintErrln("Error listing cache:", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "DIGEST\tSIZE\tLAST USED")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", e.Digest, utility.FormatBytes(e.Size), e.LastUsed.Format(time.RFC3339))
+		}
+	},
+}
+
+// cacheSizeCmd represents the cache size command
+var cacheSizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "Show the number and total size of cached layers",
+	Run: func(cmd *cobra.Command, args []string) {
+		count, total, err := utility.CacheSize(rootCacheDir)
+		if err != nil {
+			cmd.PrintErrln("Error reading cache:", err)
+			os.Exit(1)
+		}
+		cmd.Printf("%d layers, %s\n", count, utility.FormatBytes(total))
+	},
+}
+
+// cachePruneCmd represents the cache prune command
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached layers",
+	Long: `Remove cached layers. Without --older-than every layer is removed; with it,
+only layers that have not been used for that long. Temporary files left by
+interrupted downloads are removed once they have not been written for an hour,
+so downloads still running against the cache are not disturbed.
+
+Only files stored by repo-lister (<algorithm>/<digest> and their temporary
+download files) are removed; anything else in the directory is left alone.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		removed, freed, err := utility.PruneCache(rootCacheDir, cachePruneOlderThan)
+		if err != nil {
+			cmd.PrintErrln("Error pruning cache:", err)
+			os.Exit(1)
+		}
+		cmd.Printf("✓ Removed %d files, freed %s\n", removed, utility.FormatBytes(freed))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheLsCmd, cacheSizeCmd, cachePruneCmd)
+
+	// Define flags for the cache prune command
+	cachePruneCmd.Flags().DurationVar(&cachePruneOlderThan, "older-than", 0, "Only remove layers not used for this long (e.g. 72h)")
+}
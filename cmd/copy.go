@@ -8,13 +8,26 @@ import (
 )
 
 var (
-	copySource          string
-	copyDestination     string
-	copySourceSecret    string
-	copyDestSecret      string
-	copySourceNamespace string
-	copyDestNamespace   string
-	copyShowProgress    bool
+	copySource           string
+	copyDestination      string
+	copySourceSecret     string
+	copyDestSecret       string
+	copySourceNamespace  string
+	copyDestNamespace    string
+	copyShowProgress     bool
+	copyWithReferrers    bool
+	copyVerify           utility.VerifyOptions
+	copyPlatforms        []string
+	copyPlatform         string
+	copyDryRun           bool
+	copyCheckPush        bool
+	copyFilter           string
+	copyDestTemplate     string
+	copyNoClobber        bool
+	copyNoClobberPattern string
+	copyLabels           []string
+	copyAnnotations      []string
+	copyEnv              []string
 )
 
 // copyCmd represents the copy command
@@ -27,7 +40,27 @@ This command streams the image directly from source to destination, supporting:
   - Retagging within the same registry
   - Copying between different registries
   - Using different credentials for source and destination
-  - Multi-architecture images (image indexes)
+  - Multi-architecture images (image indexes), optionally filtered to a subset of
+    platforms (--platforms) or flattened to a single platform (--platform)
+  - Signatures, SBOMs and attestations attached to the image (--with-referrers);
+    with platform selection only those of the kept platform manifests follow
+  - Refusing to copy images without a trusted cosign signature (--verify-key,
+    --verify-keyless-identity)
+  - Copying every tag of a repository that matches a regex (--filter) to a
+    destination rendered from a Go template (--dest-template), which can use
+    {{.Registry}}, {{.Repo}}, {{.Name}} and {{.Tag}} of the source
+  - Protecting release tags from being moved to a different image (--no-clobber,
+    --no-clobber-pattern)
+  - Stamping provenance on the copy with labels, manifest annotations and
+    environment variables (--label, --annotation, --env), applied to every image
+    of a multi-arch index; buildx attestations of the changed images are
+    dropped, as they would no longer match them
+  - Previewing a copy (--dry-run): the blobs that would be uploaded are
+    reported and nothing is written. Push access is judged from the scopes of
+    the token the registry issues; --check-push instead opens a blob upload
+    session and cancels it straight away, which shows up in registry audit logs.
+    Blobs to be mounted are counted at their full size as an upper bound, since
+    a registry may refuse a mount.
 
 The copy operation is efficient as it doesn't require local disk storage for the image.`,
 	Example: `  # Copy from public source to private destination
@@ -59,25 +92,131 @@ The copy operation is efficient as it doesn't require local disk storage for the
     --destination linuxarpan/testpush:v2.0.0 \
     --source-secret regcred \
     --dest-secret regcred \
-    --progress`,
+    --progress
+
+  # See what promoting an image would transfer, without writing anything
+  repo-lister copy \
+    --source myregistry.io/app:v1.0.0 \
+    --destination prod-registry.io/app:v1.0.0 \
+    --dest-secret prod-cred \
+    --dry-run
+
+  # Mirror every v1.x tag of a repository under a new name
+  repo-lister copy \
+    --source myregistry.io/team/app \
+    --filter '^v1\.' \
+    --dest-template 'mirror.io/{{.Repo}}:{{.Tag}}-mirrored' \
+    --dest-secret mirror-cred
+
+  # Stamp provenance on a mirrored image
+  repo-lister copy \
+    --source docker.io/library/nginx:1.27 \
+    --destination mirror.io/nginx:1.27 \
+    --label org.example.mirrored-from=docker.io/library/nginx:1.27 \
+    --annotation org.opencontainers.image.source=https://github.com/nginx/docker-nginx \
+    --env MIRRORED=true
+
+  # Never move an existing release tag
+  repo-lister copy \
+    --source myregistry.io/app:v1.0.0 \
+    --destination prod-registry.io/app:v1.0.0 \
+    --no-clobber-pattern '^v\d+\.\d+\.\d+$'
+
+  # Copy an image together with its cosign signatures and SBOMs
+  repo-lister copy \
+    --source myregistry.io/app:v1.0.0 \
+    --destination prod-registry.io/app:v1.0.0 \
+    --dest-secret prod-cred \
+    --with-referrers
+
+  # Copy only the amd64 and arm64 manifests of a multi-arch image
+  repo-lister copy \
+    --source docker.io/library/nginx:latest \
+    --destination edge-registry.io/nginx:latest \
+    --platforms linux/amd64,linux/arm64
+
+  # Copy a single platform as a plain image
+  repo-lister copy \
+    --source docker.io/library/nginx:latest \
+    --destination edge-registry.io/nginx:latest-arm64 \
+    --platform linux/arm64
+
+  # Only promote images signed with the release key
+  repo-lister copy \
+    --source myregistry.io/app:v1.0.0 \
+    --destination prod-registry.io/app:v1.0.0 \
+    --verify-key cosign.pub
+
+  # Only promote images signed keylessly by the release workflow
+  repo-lister copy \
+    --source myregistry.io/app:v1.0.0 \
+    --destination prod-registry.io/app:v1.0.0 \
+    --verify-keyless-identity https://github.com/org/app/.github/workflows/release.yml@refs/heads/main \
+    --verify-keyless-issuer https://token.actions.githubusercontent.com \
+    --verify-trusted-root trusted_root.json`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Call the CopyImage function from the utility package
-		err := utility.CopyImage(
-			copySource,
-			copyDestination,
-			copySourceSecret,
-			copyDestSecret,
-			copySourceNamespace,
-			copyDestNamespace,
-			copyShowProgress,
-		)
+		labels, err := utility.ParseKeyValues(copyLabels)
 		if err != nil {
-			cmd.PrintErrln("Error copying image:", err)
+			cmd.PrintErrln("Error parsing --label:", err)
+			os.Exit(1)
+		}
+		annotations, err := utility.ParseKeyValues(copyAnnotations)
+		if err != nil {
+			cmd.PrintErrln("Error parsing --annotation:", err)
 			os.Exit(1)
 		}
+		env, err := utility.ParseKeyValues(copyEnv)
+		if err != nil {
+			cmd.PrintErrln("Error parsing --env:", err)
+			os.Exit(1)
+		}
+
+		opts := utility.CopyOptions{
+			WithReferrers:    copyWithReferrers,
+			Verify:           copyVerify,
+			Platforms:        copyPlatforms,
+			Platform:         copyPlatform,
+			CacheDir:         rootCacheDir,
+			DryRun:           copyDryRun,
+			CheckPush:        copyCheckPush,
+			NoClobber:        copyNoClobber,
+			NoClobberPattern: copyNoClobberPattern,
+			Labels:           labels,
+			Annotations:      annotations,
+			Env:              env,
+		}
 
-		if !copyShowProgress {
-			cmd.Printf("Successfully copied %s to %s\n", copySource, copyDestination)
+		if copyDestTemplate != "" {
+			// Copy every matching tag of the source repository
+			err = utility.CopyTags(
+				cmd.Context(),
+				copySource,
+				copyFilter,
+				copyDestTemplate,
+				copySourceSecret,
+				copyDestSecret,
+				copySourceNamespace,
+				copyDestNamespace,
+				copyShowProgress,
+				opts,
+			)
+		} else {
+			// Call the CopyImage function from the utility package
+			err = utility.CopyImage(
+				cmd.Context(),
+				copySource,
+				copyDestination,
+				copySourceSecret,
+				copyDestSecret,
+				copySourceNamespace,
+				copyDestNamespace,
+				copyShowProgress,
+				opts,
+			)
+		}
+		if err != nil {
+			cmd.PrintErrln("Error copying image:", err)
+			os.Exit(1)
 		}
 	},
 }
@@ -86,15 +225,30 @@ func init() {
 	rootCmd.AddCommand(copyCmd)
 
 	// Define flags for the copy command
-	copyCmd.Flags().StringVarP(&copySource, "source", "s", "", "Source image reference (e.g., registry.io/image:tag) (required)")
-	copyCmd.Flags().StringVarP(&copyDestination, "destination", "d", "", "Destination image reference (e.g., registry.io/image:newtag) (required)")
+	copyCmd.Flags().StringVarP(&copySource, "source", "s", "", "Source image reference (e.g., registry.io/image:tag), or repository with --dest-template (required)")
+	copyCmd.Flags().StringVarP(&copyDestination, "destination", "d", "", "Destination image reference (e.g., registry.io/image:newtag)")
+	copyCmd.Flags().StringVar(&copyFilter, "filter", "", "Regex selecting which tags of the source repository to copy with --dest-template (default: all tags)")
+	copyCmd.Flags().StringVar(&copyDestTemplate, "dest-template", "", "Go template for each tag's destination (e.g., 'mirror.io/{{.Repo}}:{{.Tag}}'), copying all tags matching --filter")
 	copyCmd.Flags().StringVar(&copySourceSecret, "source-secret", "", "Kubernetes secret name for source registry authentication (optional for public registries)")
 	copyCmd.Flags().StringVar(&copyDestSecret, "dest-secret", "", "Kubernetes secret name for destination registry authentication (optional for public registries)")
 	copyCmd.Flags().StringVar(&copySourceNamespace, "source-namespace", "default", "Kubernetes namespace for source secret")
 	copyCmd.Flags().StringVar(&copyDestNamespace, "dest-namespace", "default", "Kubernetes namespace for destination secret")
-	copyCmd.Flags().BoolVarP(&copyShowProgress, "progress", "p", false, "Show progress during copy operation")
+	copyCmd.Flags().BoolVar(&copyDryRun, "dry-run", false, "Report which blobs would be transferred without writing anything; push access is judged from the registry token unless --check-push is set")
+	copyCmd.Flags().BoolVar(&copyCheckPush, "check-push", false, "With --dry-run, confirm push access by opening and cancelling a blob upload session at the destination; this is visible in registry audit logs and may leave a stale session")
+	copyCmd.Flags().BoolVarP(&copyShowProgress, "progress", "p", false, "Show per-layer progress, throughput and ETA during the copy")
+	copyCmd.Flags().BoolVar(&copyWithReferrers, "with-referrers", false, "Also copy signatures, SBOMs and attestations attached to the image (OCI referrers and cosign tags)")
+	copyCmd.Flags().StringSliceVar(&copyPlatforms, "platforms", nil, "Only copy these platforms of a multi-arch image (e.g., linux/amd64,linux/arm64)")
+	copyCmd.Flags().StringVar(&copyPlatform, "platform", "", "Copy a multi-arch image as a single-platform image for this platform (e.g., linux/arm64)")
+	copyCmd.MarkFlagsMutuallyExclusive("platforms", "platform")
+	copyCmd.Flags().StringArrayVar(&copyLabels, "label", nil, "Set a label (KEY=VALUE) in the image config of every copied image; repeatable")
+	copyCmd.Flags().StringArrayVar(&copyAnnotations, "annotation", nil, "Set an annotation (KEY=VALUE) on every copied manifest; repeatable")
+	copyCmd.Flags().StringArrayVar(&copyEnv, "env", nil, "Set an environment variable (KEY=VALUE) in the image config of every copied image; repeatable")
+	addVerifyFlags(copyCmd, &copyVerify)
+	addNoClobberFlags(copyCmd, &copyNoClobber, &copyNoClobberPattern)
 
 	// Mark required flags
 	_ = copyCmd.MarkFlagRequired("source")
-	_ = copyCmd.MarkFlagRequired("destination")
+	copyCmd.MarkFlagsOneRequired("destination", "dest-template")
+	copyCmd.MarkFlagsMutuallyExclusive("destination", "dest-template")
+	copyCmd.MarkFlagsMutuallyExclusive("destination", "filter")
 }
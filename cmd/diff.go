@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffSecret    string
+	diffNamespace string
+	diffOptions   utility.DiffOptions
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <image-a> <image-b>",
+	Short: "Show what changed between two images or tags",
+	Long: `Compare two images, for example two tags of a repository listed by the list
+command, and show what changed between them:
+  - the manifest digests and media types
+  - the runtime config: platform, entrypoint, cmd, working directory, user,
+    exposed ports, volumes, stop signal and creation time
+  - environment variables and labels that were added, removed or changed
+  - the layers both images share and those only one of them has, with the
+    instruction that created each layer when the history records it
+  - with --files, the files added, removed or changed, which downloads and
+    unpacks every layer of both images
+
+For multi-arch images, the images for --platform (default linux/amd64) are
+compared.`,
+	Example: `  # What changed between two releases
+  repo-lister diff myregistry.io/app:v1.3.0 myregistry.io/app:v1.4.0 --secret registry-cred
+
+  # Include file-level changes
+  repo-lister diff myregistry.io/app:v1.3.0 myregistry.io/app:v1.4.0 --files
+
+  # Compare the arm64 images of two multi-arch tags
+  repo-lister diff docker.io/library/nginx:1.26 docker.io/library/nginx:1.27 --platform linux/arm64`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the DiffImages function from the utility package
+		diff, err := utility.DiffImages(cmd.Context(), args[0], args[1], diffSecret, diffNamespace, diffOptions)
+		if err != nil {
+			cmd.PrintErrln("Error comparing images:", err)
+			os.Exit(1)
+		}
+		diff.Write(cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	// Define flags for the diff command
+	diffCmd.Flags().StringVarP(&diffSecret, "secret", "s", "", "Kubernetes secret name for registry authentication (optional for public registries)")
+	diffCmd.Flags().StringVarP(&diffNamespace, "namespace", "n", "default", "Kubernetes namespace where the secret is located")
+	diffCmd.Flags().StringVar(&diffOptions.Platform, "platform", "", "Image to compare when the references are multi-arch (e.g., linux/arm64; default linux/amd64)")
+	diffCmd.Flags().BoolVar(&diffOptions.Files, "files", false, "Also compare files, downloading every layer of both images")
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+// addVerifyFlags registers the cosign signature verification flags shared by
+// commands that read a source image
+func addVerifyFlags(cmd *cobra.Command, opts *utility.VerifyOptions) {
+	cmd.Flags().StringVar(&opts.KeyPath, "verify-key", "", "Require a cosign signature made with this PEM public key before proceeding")
+	cmd.Flags().StringVar(&opts.KeylessIdentity, "verify-keyless-identity", "", "Require a keyless cosign signature whose certificate is issued to this email or URI")
+	cmd.Flags().StringVar(&opts.KeylessIssuer, "verify-keyless-issuer", "", "OIDC issuer the keyless signing certificate must record (optional)")
+	cmd.Flags().StringVar(&opts.TrustedRootPath, "verify-trusted-root", "", "Sigstore trusted_root.json with the CAs and transparency logs trusted for keyless verification")
+	cmd.MarkFlagsMutuallyExclusive("verify-key", "verify-keyless-identity")
+}
+
+// addNoClobberFlags registers the flags that protect existing destination tags from
+// being moved to a different digest
+func addNoClobberFlags(cmd *cobra.Command, noClobber *bool, pattern *string) {
+	cmd.Flags().BoolVar(noClobber, "no-clobber", false, "Abort if the destination tag already exists with a different digest")
+	cmd.Flags().StringVar(pattern, "no-clobber-pattern", "", "Only protect destination tags matching this regex (e.g., '^v\\d+\\.\\d+\\.\\d+$'); implies --no-clobber")
+}
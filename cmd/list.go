@@ -29,7 +29,7 @@ regex patterns and limited to a specific number of results.`,
   repo-lister list --image myregistry.io/app --secret registry-cred --filter "v[0-9]+.*"`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Call the ListImage function from the utility package
-		tags, err := utility.ListImage(listImageName, listImageFilter, listSecretName, listNamespace, listLimit)
+		tags, err := utility.ListImage(cmd.Context(), listImageName, listImageFilter, listSecretName, listNamespace, listLimit)
 		if err != nil {
 			cmd.PrintErrln("Error listing image tags:", err)
 			os.Exit(1)
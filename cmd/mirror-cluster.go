@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	mirrorNamespace     string
+	mirrorTarget        string
+	mirrorDestSecret    string
+	mirrorDestNamespace string
+	mirrorShowProgress  bool
+	mirrorDryRun        bool
+	mirrorCheckPush     bool
+	mirrorVerify        utility.VerifyOptions
+)
+
+// mirrorClusterCmd represents the mirror-cluster command
+var mirrorClusterCmd = &cobra.Command{
+	Use:   "mirror-cluster",
+	Short: "Mirror all images used in a Kubernetes namespace to another registry",
+	Long: `Mirror every image used by a running Kubernetes namespace to a destination registry.
+
+This command enumerates the images referenced by pods, deployments, statefulsets,
+daemonsets, jobs and cronjobs in the namespace, removes duplicates, and copies each
+image under the destination prefix while keeping its repository path and tag.
+
+Source images are pulled with the imagePullSecrets and service account declared in
+the pod spec that references them, so no extra credentials are needed to read them.
+
+Each image is copied as by the copy command: layers are read from and added to the
+global --cache-dir when mirroring to another registry, --verify-key and the keyless
+flags require a signature on every image, and --dry-run reports what each copy
+would transfer without writing anything.`,
+	Example: `  # Mirror every image in the "shop" namespace
+  repo-lister mirror-cluster \
+    --namespace shop \
+    --to mirror.io/shop \
+    --dest-secret mirror-cred
+
+  # docker.io/library/nginx:1.27 becomes mirror.io/shop/library/nginx:1.27`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the MirrorCluster function from the utility package
+		err := utility.MirrorCluster(
+			cmd.Context(),
+			mirrorNamespace,
+			mirrorTarget,
+			mirrorDestSecret,
+			mirrorDestNamespace,
+			mirrorShowProgress,
+			utility.CopyOptions{
+				Verify:    mirrorVerify,
+				CacheDir:  rootCacheDir,
+				DryRun:    mirrorDryRun,
+				CheckPush: mirrorCheckPush,
+			},
+		)
+		if err != nil {
+			cmd.PrintErrln("Error mirroring cluster images:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mirrorClusterCmd)
+
+	// Define flags for the mirror-cluster command
+	mirrorClusterCmd.Flags().StringVarP(&mirrorNamespace, "namespace", "n", "default", "Kubernetes namespace whose images should be mirrored")
+	mirrorClusterCmd.Flags().StringVarP(&mirrorTarget, "to", "t", "", "Destination registry prefix (e.g., registry.io/mirror) (required)")
+	mirrorClusterCmd.Flags().StringVar(&mirrorDestSecret, "dest-secret", "", "Kubernetes secret name for destination registry authentication (optional for public registries)")
+	mirrorClusterCmd.Flags().StringVar(&mirrorDestNamespace, "dest-namespace", "default", "Kubernetes namespace for destination secret")
+	mirrorClusterCmd.Flags().BoolVarP(&mirrorShowProgress, "progress", "p", false, "Show progress while copying each image")
+	mirrorClusterCmd.Flags().BoolVar(&mirrorDryRun, "dry-run", false, "Report which blobs each copy would transfer without writing anything; push access is judged from the registry token unless --check-push is set")
+	mirrorClusterCmd.Flags().BoolVar(&mirrorCheckPush, "check-push", false, "With --dry-run, confirm push access by opening and cancelling a blob upload session at the destination; this is visible in registry audit logs and may leave a stale session")
+	addVerifyFlags(mirrorClusterCmd, &mirrorVerify)
+
+	// Mark required flags
+	_ = mirrorClusterCmd.MarkFlagRequired("to")
+}
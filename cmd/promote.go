@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"os"
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	promoteSource          string
+	promoteDestination     string
+	promoteSourceSecret    string
+	promoteDestSecret      string
+	promoteSourceNamespace string
+	promoteDestNamespace   string
+	promoteShowProgress    bool
+	promoteAllowOverwrite  bool
+	promoteRequireDigest   bool
+	promoteRecord          bool
+	promoteDryRun          bool
+	promoteCheckPush       bool
+	promoteVerify          utility.VerifyOptions
+)
+
+// promoteCmd represents the promote command
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promote an image from one environment's repository to another",
+	Long: `Promote an image, for example from a staging repository to a production one,
+with guardrails that a plain copy does not apply:
+  - The source is pinned to a digest before copying, so a tag moved during the
+    promotion cannot change what is promoted. With --require-digest the source
+    must already be referenced by digest.
+  - An existing destination tag is never replaced unless --allow-overwrite is set.
+    Promoting the digest the tag already points to succeeds without it.
+  - With --verify-key or --verify-keyless-identity the source digest must carry a
+    trusted cosign signature.
+  - With --record, a promotion record naming the source is attached to the
+    promoted digest as an OCI referrer. The promoted image keeps the source
+    digest either way.
+
+Signatures and other referrers of the source are not copied; use
+copy --with-referrers to bring them along.`,
+	Example: `  # Promote the image currently tagged v1.4.0 in staging
+  repo-lister promote \
+    --source myregistry.io/staging/app:v1.4.0 \
+    --destination myregistry.io/prod/app:v1.4.0 \
+    --dest-secret prod-cred
+
+  # Promote an exact, signed digest
+  repo-lister promote \
+    --source myregistry.io/staging/app@sha256:4f53c3... \
+    --destination myregistry.io/prod/app:v1.4.0 \
+    --require-digest \
+    --verify-key cosign.pub
+
+  # Move the prod "stable" tag to a new release
+  repo-lister promote \
+    --source myregistry.io/staging/app:v1.5.0 \
+    --destination myregistry.io/prod/app:stable \
+    --allow-overwrite`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the PromoteImage function from the utility package
+		err := utility.PromoteImage(
+			cmd.Context(),
+			promoteSource,
+			promoteDestination,
+			promoteSourceSecret,
+			promoteDestSecret,
+			promoteSourceNamespace,
+			promoteDestNamespace,
+			promoteShowProgress,
+			utility.PromoteOptions{
+				AllowOverwrite: promoteAllowOverwrite,
+				RequireDigest:  promoteRequireDigest,
+				Verify:         promoteVerify,
+				Record:         promoteRecord,
+				CacheDir:       rootCacheDir,
+				DryRun:         promoteDryRun,
+				CheckPush:      promoteCheckPush,
+			},
+		)
+		if err != nil {
+			cmd.PrintErrln("Error promoting image:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(promoteCmd)
+
+	// Define flags for the promote command
+	promoteCmd.Flags().StringVarP(&promoteSource, "source", "s", "", "Source image reference, by tag or digest (required)")
+	promoteCmd.Flags().StringVarP(&promoteDestination, "destination", "d", "", "Destination image tag (required)")
+	promoteCmd.Flags().StringVar(&promoteSourceSecret, "source-secret", "", "Kubernetes secret name for source registry authentication (optional for public registries)")
+	promoteCmd.Flags().StringVar(&promoteDestSecret, "dest-secret", "", "Kubernetes secret name for destination registry authentication (optional for public registries)")
+	promoteCmd.Flags().StringVar(&promoteSourceNamespace, "source-namespace", "default", "Kubernetes namespace for source secret")
+	promoteCmd.Flags().StringVar(&promoteDestNamespace, "dest-namespace", "default", "Kubernetes namespace for destination secret")
+	promoteCmd.Flags().BoolVarP(&promoteShowProgress, "progress", "p", false, "Show per-layer progress, throughput and ETA during the copy")
+	promoteCmd.Flags().BoolVar(&promoteAllowOverwrite, "allow-overwrite", false, "Replace the destination tag if it already exists")
+	promoteCmd.Flags().BoolVar(&promoteRequireDigest, "require-digest", false, "Refuse sources referenced by tag instead of resolving them to a digest")
+	promoteCmd.Flags().BoolVar(&promoteRecord, "record", false, "Attach a referrer to the promoted digest recording the source it was promoted from; the promoted digest is unchanged")
+	promoteCmd.Flags().BoolVar(&promoteDryRun, "dry-run", false, "Check the guardrails and report which blobs would be transferred without writing anything; push access is judged from the registry token unless --check-push is set")
+	promoteCmd.Flags().BoolVar(&promoteCheckPush, "check-push", false, "With --dry-run, confirm push access by opening and cancelling a blob upload session at the destination; this is visible in registry audit logs and may leave a stale session")
+	addVerifyFlags(promoteCmd, &promoteVerify)
+
+	// Mark required flags
+	_ = promoteCmd.MarkFlagRequired("source")
+	_ = promoteCmd.MarkFlagRequired("destination")
+}
@@ -12,13 +12,17 @@ var (
 	pullOutput    string
 	pullSecret    string
 	pullNamespace string
+	pullVerify    utility.VerifyOptions
+	pullToDaemon  bool
+	pullCompress  string
 )
 
 // pullCmd represents the pull command
 var pullCmd = &cobra.Command{
 	Use:   "pull",
 	Short: "Pull an image from registry to local storage",
-	Long: `Pull a container image from a registry and save it to a local tar file.
+	Long: `Pull a container image from a registry and save it to a local tar file, or load
+it into the local Docker daemon with --to-daemon.
 
 This command downloads an image from a registry using Kubernetes credentials
 and saves it as a tar archive. The tar file can later be used with the push
@@ -29,7 +33,27 @@ The pull operation is useful for:
   - Backing up images locally
   - Transferring images to air-gapped environments
   - Inspecting image contents offline
-  - Migrating images between registries (using pull + push)`,
+  - Migrating images between registries (using pull + push)
+
+The tar file is written under a temporary name and renamed once complete, so an
+interrupted pull never leaves a truncated archive at the output path. Downloaded
+layers are kept until the pull succeeds; re-running it only fetches the layers
+that are missing. Use the global --cache-dir to keep layers for later pulls as well.
+
+Every layer is checked against its digest before the tar file is saved, and a
+<output>.sha256 checksum file is written next to it (see the verify command).
+
+With --to-daemon the image is loaded into the local Docker engine under its tag
+(images pulled by digest are tagged sha256-<hex>), using the Kubernetes secret for
+the registry, so no docker login is needed. For multi-arch images the linux image
+for this machine's CPU architecture is loaded.
+
+Use --compress gzip or --compress zstd to compress the tar file as it is written.
+The push and verify commands detect compressed tar files automatically.
+
+Use --output - to stream the tar file to stdout, e.g. into "repo-lister push -f -"
+over SSH. Status messages then go to stderr. A streamed tar file cannot be renamed
+into place or checked before it is sent, and no checksum file is written.`,
 	Example: `  # Pull an image to a tar file
   repo-lister pull \
     --image linuxarpan/testpush:v1.0.0 \
@@ -41,10 +65,42 @@ The pull operation is useful for:
   repo-lister pull \
     --image myregistry.io/app:latest \
     --output ./backup/app-latest.tar \
-    --secret registry-cred`,
+    --secret registry-cred
+
+  # Refuse to pull unless the image is signed with the release key
+  repo-lister pull \
+    --image myregistry.io/app:v1.0.0 \
+    --output ./app.tar \
+    --secret registry-cred \
+    --verify-key cosign.pub
+
+  # Run a private image locally without docker login
+  repo-lister pull \
+    --image myregistry.io/app:v1.0.0 \
+    --secret registry-cred \
+    --to-daemon
+  docker run --rm myregistry.io/app:v1.0.0
+
+  # Keep layers in a cache so pulls of related images skip shared base layers
+  repo-lister pull \
+    --image myregistry.io/app:v1.0.1 \
+    --output ./app-v1.0.1.tar \
+    --cache-dir ~/.cache/repo-lister
+
+  # Write a zstd-compressed tar file
+  repo-lister pull \
+    --image myregistry.io/app:v1.0.0 \
+    --output ./app.tar.zst \
+    --secret registry-cred \
+    --compress zstd`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Call the PullImage function from the utility package
-		err := utility.PullImage(pullImage, pullOutput, pullSecret, pullNamespace)
+		err := utility.PullImage(cmd.Context(), pullImage, pullOutput, pullSecret, pullNamespace, utility.PullOptions{
+			Verify:   pullVerify,
+			CacheDir: rootCacheDir,
+			ToDaemon: pullToDaemon,
+			Compress: pullCompress,
+		})
 		if err != nil {
 			cmd.PrintErrln("Error pulling image:", err)
 			os.Exit(1)
@@ -57,11 +113,14 @@ func init() {
 
 	// Define flags for the pull command
 	pullCmd.Flags().StringVarP(&pullImage, "image", "i", "", "Image reference to pull (e.g., registry.io/image:tag) (required)")
-	pullCmd.Flags().StringVarP(&pullOutput, "output", "o", "", "Output path for the tar file (required)")
+	pullCmd.Flags().StringVarP(&pullOutput, "output", "o", "", "Output path for the tar file, or - for stdout (required unless --to-daemon is set)")
 	pullCmd.Flags().StringVarP(&pullSecret, "secret", "s", "", "Kubernetes secret name for registry authentication (required)")
 	pullCmd.Flags().StringVarP(&pullNamespace, "namespace", "n", "default", "Kubernetes namespace where the secret is located")
+	pullCmd.Flags().BoolVar(&pullToDaemon, "to-daemon", false, "Load the image into the local Docker daemon under its tag")
+	pullCmd.Flags().StringVar(&pullCompress, "compress", utility.CompressionNone, "Compress the tar file: none, gzip or zstd")
+	addVerifyFlags(pullCmd, &pullVerify)
 
 	// Mark required flags
 	_ = pullCmd.MarkFlagRequired("image")
-	_ = pullCmd.MarkFlagRequired("output")
+	pullCmd.MarkFlagsOneRequired("output", "to-daemon")
 }
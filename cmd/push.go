@@ -8,21 +8,38 @@ import (
 )
 
 var (
-	pushImage     string
-	pushSource    string
-	pushSecret    string
-	pushNamespace string
+	pushImage            string
+	pushSource           string
+	pushSecret           string
+	pushNamespace        string
+	pushDaemon           string
+	pushDryRun           bool
+	pushCheckPush        bool
+	pushNoClobber        bool
+	pushNoClobberPattern string
 )
 
 // pushCmd represents the push command
 var pushCmd = &cobra.Command{
 	Use:   "push",
 	Short: "Push an image from local storage to registry",
-	Long: `Push a container image from a local tar file to a registry.
+	Long: `Push a container image from a local tar file, or from the local Docker or
+Podman daemon, to a registry.
 
 This command loads an image from a tar archive and uploads it to a registry
 using Kubernetes credentials for authentication. The tar file is typically
-created using the pull command or docker save.
+created using the pull command or docker save, and may be gzip or zstd
+compressed; compressed archives are detected and decompressed as they are
+streamed. With --from-daemon the image is
+read directly from the local daemon, so no intermediate tar file is needed.
+Podman is supported through its Docker-compatible socket (set DOCKER_HOST).
+
+Use --source - to read the tar file from stdin, e.g. from "repo-lister pull -o -"
+on another host. Status messages then go to stderr. The image is read more than
+once while it is pushed, so the whole stream is first stored in a temporary file,
+which needs as much free disk space as the (possibly compressed) tar file. The file
+goes in the global --cache-dir when set and in $TMPDIR (or /tmp) otherwise, and is
+removed when the push finishes or is interrupted.
 
 The push operation is useful for:
   - Uploading locally modified images
@@ -40,10 +57,28 @@ The push operation is useful for:
   repo-lister push \
     --image myregistry.io/app:latest \
     --source ./backup/app-latest.tar \
-    --secret registry-cred`,
+    --secret registry-cred
+
+  # Push a locally built image without docker save
+  docker build -t app:dev .
+  repo-lister push \
+    --image myregistry.io/app:dev \
+    --from-daemon app:dev \
+    --secret registry-cred
+
+  # Copy an image across an SSH hop without a tar file on either side
+  repo-lister pull -i myregistry.io/app:v1.0.0 -s registry-cred -o - --compress zstd |
+    ssh airgap-host repo-lister push -i internal.io/app:v1.0.0 -s internal-cred -f -`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Call the PushImage function from the utility package
-		err := utility.PushImage(pushImage, pushSource, pushSecret, pushNamespace)
+		err := utility.PushImage(cmd.Context(), pushImage, pushSource, pushSecret, pushNamespace, utility.PushOptions{
+			FromDaemon:       pushDaemon,
+			SpoolDir:         rootCacheDir,
+			DryRun:           pushDryRun,
+			CheckPush:        pushCheckPush,
+			NoClobber:        pushNoClobber,
+			NoClobberPattern: pushNoClobberPattern,
+		})
 		if err != nil {
 			cmd.PrintErrln("Error pushing image:", err)
 			os.Exit(1)
@@ -56,12 +91,17 @@ func init() {
 
 	// Define flags for the push command
 	pushCmd.Flags().StringVarP(&pushImage, "image", "i", "", "Destination image reference (e.g., registry.io/image:tag) (required)")
-	pushCmd.Flags().StringVarP(&pushSource, "source", "f", "", "Source tar file path (required)")
+	pushCmd.Flags().StringVarP(&pushSource, "source", "f", "", "Source tar file path, or - for stdin (required unless --from-daemon is set)")
+	pushCmd.Flags().BoolVar(&pushDryRun, "dry-run", false, "Report which blobs would be uploaded without writing anything; push access is judged from the registry token unless --check-push is set")
+	pushCmd.Flags().BoolVar(&pushCheckPush, "check-push", false, "With --dry-run, confirm push access by opening and cancelling a blob upload session at the destination; this is visible in registry audit logs and may leave a stale session")
+	pushCmd.Flags().StringVar(&pushDaemon, "from-daemon", "", "Local Docker/Podman image to push instead of a tar file (e.g., app:dev)")
 	pushCmd.Flags().StringVarP(&pushSecret, "secret", "s", "", "Kubernetes secret name for registry authentication (required)")
 	pushCmd.Flags().StringVarP(&pushNamespace, "namespace", "n", "default", "Kubernetes namespace where the secret is located")
+	addNoClobberFlags(pushCmd, &pushNoClobber, &pushNoClobberPattern)
 
 	// Mark required flags
 	_ = pushCmd.MarkFlagRequired("image")
-	_ = pushCmd.MarkFlagRequired("source")
+	pushCmd.MarkFlagsOneRequired("source", "from-daemon")
+	pushCmd.MarkFlagsMutuallyExclusive("source", "from-daemon")
 	_ = pushCmd.MarkFlagRequired("secret")
 }
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"os"
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	rebaseImage         string
+	rebaseOldBase       string
+	rebaseNewBase       string
+	rebaseSecret        string
+	rebaseNamespace     string
+	rebaseBaseSecret    string
+	rebaseBaseNamespace string
+	rebaseOptions       utility.RebaseOptions
+)
+
+// rebaseCmd represents the rebase command
+var rebaseCmd = &cobra.Command{
+	Use:   "rebase",
+	Short: "Rebase an image onto an updated base image without rebuilding it",
+	Long: `Replace the base image layers of an image with those of an updated base image,
+for example after the base image received a security fix, and push the result.
+
+The image must start with exactly the layers of --old-base; otherwise it was not
+built on that base and the rebase is refused. The layers above the base, the
+image's runtime configuration (entrypoint, env, labels, ...) and its history are
+kept, and the new base is recorded in the standard
+org.opencontainers.image.base.name and .base.digest annotations.
+
+Multi-arch images are rebased platform by platform onto the matching platform of
+the bases. When the new base is on the same registry as the destination, its
+layers are mounted (or reused if already present) instead of uploaded; the
+image's own layers are reused. Both bases are read with --base-secret.
+
+Rebasing is only safe when the layers above the base do not depend on files the
+new base changes, which holds for patch updates of the same base image.`,
+	Example: `  # Rebase an app image onto a patched base and push it in place
+  repo-lister rebase \
+    --image myregistry.io/app:v1.4.0 \
+    --old-base docker.io/library/alpine:3.20.2 \
+    --new-base docker.io/library/alpine:3.20.3 \
+    --secret registry-cred
+
+  # Push the rebased image under a new tag
+  repo-lister rebase \
+    --image myregistry.io/app:v1.4.0 \
+    --old-base myregistry.io/base:2024-09 \
+    --new-base myregistry.io/base:2024-10 \
+    --destination myregistry.io/app:v1.4.0-rebased \
+    --secret registry-cred \
+    --base-secret registry-cred
+
+  # Check whether an image is built on a base without pushing anything
+  repo-lister rebase \
+    --image myregistry.io/app:v1.4.0 \
+    --old-base myregistry.io/base:2024-09 \
+    --new-base myregistry.io/base:2024-10 \
+    --dry-run`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the RebaseImage function from the utility package
+		err := utility.RebaseImage(
+			cmd.Context(),
+			rebaseImage,
+			rebaseOldBase,
+			rebaseNewBase,
+			rebaseSecret,
+			rebaseNamespace,
+			rebaseBaseSecret,
+			rebaseBaseNamespace,
+			rebaseOptions,
+		)
+		if err != nil {
+			cmd.PrintErrln("Error rebasing image:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rebaseCmd)
+
+	// Define flags for the rebase command
+	rebaseCmd.Flags().StringVarP(&rebaseImage, "image", "i", "", "Image to rebase (e.g., registry.io/app:tag) (required)")
+	rebaseCmd.Flags().StringVar(&rebaseOldBase, "old-base", "", "Base image the image was built on (required)")
+	rebaseCmd.Flags().StringVar(&rebaseNewBase, "new-base", "", "Base image to rebase onto (required)")
+	rebaseCmd.Flags().StringVarP(&rebaseOptions.Destination, "destination", "d", "", "Where to push the rebased image (default: replace --image)")
+	rebaseCmd.Flags().BoolVar(&rebaseOptions.DryRun, "dry-run", false, "Check that the image is based on --old-base without pushing anything")
+	rebaseCmd.Flags().StringVarP(&rebaseSecret, "secret", "s", "", "Kubernetes secret name for the image's registry (optional for public registries)")
+	rebaseCmd.Flags().StringVarP(&rebaseNamespace, "namespace", "n", "default", "Kubernetes namespace for the image secret")
+	rebaseCmd.Flags().StringVar(&rebaseBaseSecret, "base-secret", "", "Kubernetes secret name for the base images' registry (optional for public registries)")
+	rebaseCmd.Flags().StringVar(&rebaseBaseNamespace, "base-namespace", "default", "Kubernetes namespace for the base secret")
+
+	// Mark required flags
+	_ = rebaseCmd.MarkFlagRequired("image")
+	_ = rebaseCmd.MarkFlagRequired("old-base")
+	_ = rebaseCmd.MarkFlagRequired("new-base")
+}
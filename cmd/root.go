@@ -4,14 +4,37 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"repo-lister/utility"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var appVersion, appCommit, appDate string
 
+// rootTimeout bounds the whole command; zero means no limit
+var rootTimeout time.Duration
+
+// rootCacheDir is the layer cache shared by commands that download layers
+var rootCacheDir string
+
+// rootQuiet, rootVerbose and rootLogFormat configure the status output of every command
+var (
+	rootQuiet          bool
+	rootVerbose        bool
+	rootLogFormat      string
+	rootProgressFormat string
+)
+
+// cancelTimeout releases the --timeout context once the command has finished
+var cancelTimeout context.CancelFunc = func() {}
+
 // SetVersionInfo sets the version info from main (populated by ldflags)
 func SetVersionInfo(version, commit, date string) {
 	appVersion = version
@@ -32,20 +55,80 @@ Features:
   - copy:  Copy/retag images between registries
   - pull:  Pull images from registry to local storage
   - push:  Push images from local storage to registry
+  - mirror-cluster: Mirror all images used in a namespace to another registry
+  - cache: Inspect and prune the local layer cache (--cache-dir)
+  - bundle: Export many images to one archive and import them across an air gap
+  - verify: Check that an image tar file is intact
+  - promote: Promote an image between environments with overwrite and signature guardrails
+  - append: Add layers to a base image and push the result without a Docker daemon
+  - rebase: Move an image onto an updated base image without rebuilding it
+  - diff: Show what changed between two images or tags
 
 All commands use Kubernetes secrets for registry authentication, making it easy
-to work with private registries in your cluster.`,
+to work with private registries in your cluster.
+
+Every command can be interrupted with Ctrl-C (or SIGTERM), which cancels in-flight
+registry requests, and bounded with the global --timeout flag.
+
+Status messages are written to stderr. Use --quiet to only show warnings and
+errors, --verbose to also trace every registry request, and --log-format json
+for one JSON object per message. Transfers show per-layer progress, redrawn in
+place on a terminal and logged periodically otherwise; --progress-format json
+emits them as newline-delimited JSON events for other programs instead.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		level := slog.LevelInfo
+		switch {
+		case rootQuiet:
+			level = slog.LevelWarn
+		case rootVerbose:
+			level = slog.LevelDebug
+		}
+		logger, err := utility.NewLogger(os.Stderr, rootLogFormat, level)
+		if err != nil {
+			cmd.PrintErrln("Error:", err)
+			os.Exit(1)
+		}
+		utility.SetLogger(logger)
+		if err := utility.SetProgressFormat(rootProgressFormat); err != nil {
+			cmd.PrintErrln("Error:", err)
+			os.Exit(1)
+		}
+
+		if rootTimeout > 0 {
+			var ctx context.Context
+			ctx, cancelTimeout = context.WithTimeout(cmd.Context(), rootTimeout)
+			cmd.SetContext(ctx)
+		}
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	rootCmd.Version = fmt.Sprintf("%s (commit: %s, built: %s)", appVersion, appCommit, appDate)
-	err := rootCmd.Execute()
+
+	// Cancel the command on SIGINT/SIGTERM. Once cancelled, default signal handling is
+	// restored so a second Ctrl-C terminates immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
+	cancelTimeout()
+	stop()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&rootCacheDir, "cache-dir", "", "Directory to keep downloaded layers in, by digest, for reuse across commands")
+	rootCmd.PersistentFlags().DurationVar(&rootTimeout, "timeout", 0, "Maximum time for the whole operation, e.g. 30s or 10m (0 means no limit)")
+	rootCmd.PersistentFlags().BoolVarP(&rootQuiet, "quiet", "q", false, "Only print warnings and errors")
+	rootCmd.PersistentFlags().BoolVar(&rootVerbose, "verbose", false, "Print detailed status messages and trace registry requests")
+	rootCmd.PersistentFlags().StringVar(&rootLogFormat, "log-format", utility.LogFormatText, "Format of status messages: text or json")
+	rootCmd.PersistentFlags().StringVar(&rootProgressFormat, "progress-format", utility.ProgressFormatText, "Format of transfer progress: text, or json for newline-delimited events on stderr")
+	rootCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
 }
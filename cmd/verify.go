@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"os"
+	"repo-lister/utility"
+
+	"github.com/spf13/cobra"
+)
+
+var verifySource string
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that an image tar file is intact",
+	Long: `Check that an image tar file created by pull (or docker save) is intact before
+shipping it offline.
+
+Every layer is decompressed and its digest compared with the diff ID recorded in
+the image config, and config and layer files named after their digest are checked
+against that digest. The checksum of the whole file is compared with the sidecar
+<file>.sha256 written by pull, or the sidecar is created if it does not exist yet.
+
+pull runs the same check automatically before saving its output.`,
+	Example: `  # Verify a pulled image before copying it to removable media
+  repo-lister verify --source ./backup/app-latest.tar
+
+  # The sidecar can also be checked with standard tools
+  sha256sum -c ./backup/app-latest.tar.sha256`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Call the VerifyImageTarball function from the utility package
+		if err := utility.VerifyImageTarball(verifySource); err != nil {
+			cmd.PrintErrln("Error verifying tar file:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	// Define flags for the verify command
+	verifyCmd.Flags().StringVarP(&verifySource, "source", "f", "", "Image tar file to verify (required)")
+
+	// Mark required flags
+	_ = verifyCmd.MarkFlagRequired("source")
+}
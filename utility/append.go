@@ -0,0 +1,288 @@
+package utility
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// AppendOptions holds the layers and config changes applied by AppendImage.
+type AppendOptions struct {
+	// Layers are tar files (plain or gzip compressed) or directories, each added as
+	// one new layer in order. A directory's contents are placed at the image root.
+	Layers []string
+	// Platform selects the image to extend when the base is a multi-arch index
+	Platform string
+	// Entrypoint replaces the entrypoint; as in a Dockerfile, this also clears the
+	// base image's cmd unless Cmd is set
+	Entrypoint []string
+	// Cmd replaces the default arguments
+	Cmd []string
+	// Workdir replaces the working directory
+	Workdir string
+	// User replaces the user the container runs as
+	User string
+}
+
+// changesConfig reports whether opts change the image config beyond adding layers
+func (o AppendOptions) changesConfig() bool {
+	return len(o.Entrypoint) > 0 || len(o.Cmd) > 0 || o.Workdir != "" || o.User != ""
+}
+
+// AppendImage builds a new image from baseImage by adding layers and changing its
+// entrypoint, cmd, working directory or user, and pushes it to imageRef. Nothing
+// is built locally and no daemon is needed: base layers are reused when the
+// destination already has them and mounted when the base is in another repository
+// of the same registry, so only the new layers are uploaded. A base on another
+// registry is streamed through.
+func AppendImage(ctx context.Context, baseImage string, imageRef string, baseSecret string, baseNamespace string, secretName string, namespace string, opts AppendOptions) error {
+	if len(opts.Layers) == 0 && !opts.changesConfig() {
+		return fmt.Errorf("nothing to append: give at least one layer or a config change")
+	}
+	for _, path := range opts.Layers {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("failed to read layer source: %w", err)
+		}
+	}
+
+	baseRef, err := name.ParseReference(baseImage)
+	if err != nil {
+		return fmt.Errorf("failed to parse base image reference '%s': %w", baseImage, err)
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference '%s': %w", imageRef, err)
+	}
+
+	// Create base keychain
+	baseKC, err := CreateKeychain(ctx, baseNamespace, baseSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create base keychain: %w", err)
+	}
+
+	// Create destination keychain
+	kc, err := CreateKeychain(ctx, namespace, secretName)
+	if err != nil {
+		return fmt.Errorf("failed to create keychain: %w", err)
+	}
+
+	var extra []remote.Option
+	if opts.Platform != "" {
+		platform, err := v1.ParsePlatform(opts.Platform)
+		if err != nil {
+			return fmt.Errorf("invalid platform '%s': %w", opts.Platform, err)
+		}
+		extra = append(extra, remote.WithPlatform(*platform))
+	}
+
+	logInfof("Fetching base image %s...", baseImage)
+	img, err := remote.Image(baseRef, remoteOptions(ctx, baseKC, extra...)...)
+	if err != nil {
+		return HandleRegistryError(err, "fetching base image", baseImage)
+	}
+
+	img, err = appendLayers(img, opts.Layers)
+	if err != nil {
+		return err
+	}
+
+	if opts.changesConfig() {
+		img, err = updateRuntimeConfig(img, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	logInfof("Pushing image to %s...", imageRef)
+
+	ctx, tracked, stopProgress := trackProgress(ctx, progressUpload, img, true)
+	err = remote.Write(ref, tracked.(v1.Image), remoteOptions(ctx, kc)...)
+	if err == nil {
+		progressManifestWritten(ctx, imageRef, img)
+	}
+	stopProgress()
+	if err != nil {
+		return HandleRegistryError(err, "pushing image to", imageRef)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to compute image digest: %w", err)
+	}
+	logInfof("✓ Successfully pushed image to %s (%s)", imageRef, digest)
+	return nil
+}
+
+// appendLayers adds one layer per path to img, with a history entry naming its
+// source. Layers use the OCI media type when the base is an OCI image.
+func appendLayers(img v1.Image, paths []string) (v1.Image, error) {
+	if len(paths) == 0 {
+		return img, nil
+	}
+
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base image media type: %w", err)
+	}
+	layerType := types.DockerLayer
+	if mediaType == types.OCIManifestSchema1 {
+		layerType = types.OCILayer
+	}
+
+	addenda := make([]mutate.Addendum, 0, len(paths))
+	for _, path := range paths {
+		layer, err := layerFromPath(path, tarball.WithMediaType(layerType))
+		if err != nil {
+			return nil, err
+		}
+		addenda = append(addenda, mutate.Addendum{
+			Layer: layer,
+			History: v1.History{
+				Author:    "repo-lister",
+				Created:   v1.Time{Time: time.Now().UTC()},
+				CreatedBy: "repo-lister append " + filepath.Base(path),
+			},
+		})
+	}
+
+	img, err = mutate.Append(img, addenda...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to append layers: %w", err)
+	}
+	return img, nil
+}
+
+// layerFromPath returns a layer for a tar file or a directory
+func layerFromPath(path string, opts ...tarball.LayerOption) (v1.Layer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer source: %w", err)
+	}
+
+	if !info.IsDir() {
+		layer, err := tarball.LayerFromFile(path, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer from '%s': %w", path, err)
+		}
+		return layer, nil
+	}
+
+	// The directory is archived again each time the layer is read, rather than
+	// buffered in memory or a temporary file
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeDirectoryTar(pw, path))
+		}()
+		return pr, nil
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create layer from directory '%s': %w", path, err)
+	}
+	return layer, nil
+}
+
+// writeDirectoryTar writes the contents of dir to w as a tar archive. Entries are
+// owned by root and have a fixed modification time, so the same directory always
+// produces the same layer digest.
+func writeDirectoryTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	epoch := time.Unix(0, 0)
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		header.ModTime, header.AccessTime, header.ChangeTime = epoch, time.Time{}, time.Time{}
+		header.Format = tar.FormatPAX
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive directory '%s': %w", dir, err)
+	}
+	return tw.Close()
+}
+
+// updateRuntimeConfig applies the entrypoint, cmd, working directory and user in
+// opts to the config of img
+func updateRuntimeConfig(img v1.Image, opts AppendOptions) (v1.Image, error) {
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+	cfg := *config.Config.DeepCopy()
+
+	if len(opts.Entrypoint) > 0 {
+		cfg.Entrypoint = opts.Entrypoint
+		cfg.Cmd = nil
+	}
+	if len(opts.Cmd) > 0 {
+		cfg.Cmd = opts.Cmd
+	}
+	if opts.Workdir != "" {
+		cfg.WorkingDir = opts.Workdir
+	}
+	if opts.User != "" {
+		cfg.User = opts.User
+	}
+
+	img, err = mutate.Config(img, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update image config: %w", err)
+	}
+	return img, nil
+}
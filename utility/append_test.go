@@ -0,0 +1,212 @@
+package utility
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+// writeLayerDir creates a small directory tree to add as a layer
+func writeLayerDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app", "bin"), 0o755); err != nil {
+		t.Fatalf("Failed to create layer directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app", "bin", "server"), []byte("#!/bin/sh\necho hello\n"), 0o755); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+	if err := os.Symlink("bin/server", filepath.Join(dir, "app", "run")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	return dir
+}
+
+// layerEntries returns the names and symlink targets of the files in a layer
+func layerEntries(t *testing.T, layer v1.Layer) map[string]string {
+	t.Helper()
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatalf("Failed to read layer: %v", err)
+	}
+	defer rc.Close()
+
+	entries := map[string]string{}
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read layer tar: %v", err)
+		}
+		if header.Uid != 0 || header.Gid != 0 {
+			t.Errorf("Entry %s is owned by %d:%d, want 0:0", header.Name, header.Uid, header.Gid)
+		}
+		entries[header.Name] = header.Linkname
+	}
+	return entries
+}
+
+// TestLayerFromDirectory tests archiving a directory as a reproducible layer
+func TestLayerFromDirectory(t *testing.T) {
+	dir := writeLayerDir(t)
+
+	layer, err := layerFromPath(dir)
+	if err != nil {
+		t.Fatalf("layerFromPath() failed: %v", err)
+	}
+	entries := layerEntries(t, layer)
+	for _, want := range []string{"app/", "app/bin/", "app/bin/server"} {
+		if _, ok := entries[want]; !ok {
+			t.Errorf("Layer is missing %s, has %v", want, entries)
+		}
+	}
+	if entries["app/run"] != "bin/server" {
+		t.Errorf("Symlink app/run points to %q, want bin/server", entries["app/run"])
+	}
+
+	again, err := layerFromPath(dir)
+	if err != nil {
+		t.Fatalf("layerFromPath() failed: %v", err)
+	}
+	first, _ := layer.Digest()
+	second, _ := again.Digest()
+	if first != second {
+		t.Errorf("Archiving the same directory gave digests %s and %s", first, second)
+	}
+}
+
+// TestAppendImage tests adding layers and changing the runtime config of a base image
+func TestAppendImage(t *testing.T) {
+	reg := newTestRegistry(t)
+	base, err := random.Image(512, 2)
+	if err != nil {
+		t.Fatalf("Failed to create base image: %v", err)
+	}
+	// The base cmd must be cleared when the entrypoint is replaced
+	base, err = mutate.Config(base, v1.Config{Cmd: []string{"/bin/sh"}})
+	if err != nil {
+		t.Fatalf("Failed to set base image cmd: %v", err)
+	}
+	pushTestImage(t, reg+"/base:v1", base)
+
+	// A tar file layer, taken from a random image
+	tarLayer, _ := random.Layer(256, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	tarPath := filepath.Join(t.TempDir(), "layer.tar.gz")
+	rc, _ := tarLayer.Compressed()
+	f, _ := os.Create(tarPath)
+	io.Copy(f, rc)
+	f.Close()
+	rc.Close()
+
+	opts := AppendOptions{
+		Layers:     []string{writeLayerDir(t), tarPath},
+		Entrypoint: []string{"/app/run"},
+		Workdir:    "/app",
+		User:       "1000:1000",
+	}
+	if err := AppendImage(context.Background(), reg+"/base:v1", reg+"/app:v1", "", "default", "", "default", opts); err != nil {
+		t.Fatalf("AppendImage() failed: %v", err)
+	}
+
+	img := remoteImage(t, reg+"/app:v1")
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Failed to read layers: %v", err)
+	}
+	if len(layers) != 4 {
+		t.Fatalf("Image has %d layers, want 4", len(layers))
+	}
+	if _, ok := layerEntries(t, layers[2])["app/bin/server"]; !ok {
+		t.Errorf("Third layer does not contain the directory contents")
+	}
+	wantDiffID, _ := tarLayer.DiffID()
+	if got, _ := layers[3].DiffID(); got != wantDiffID {
+		t.Errorf("Fourth layer diff ID = %s, want %s", got, wantDiffID)
+	}
+
+	got, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if strings.Join(got.Config.Entrypoint, " ") != "/app/run" || len(got.Config.Cmd) != 0 {
+		t.Errorf("Entrypoint = %v, Cmd = %v; want [/app/run] and no cmd", got.Config.Entrypoint, got.Config.Cmd)
+	}
+	if got.Config.WorkingDir != "/app" || got.Config.User != "1000:1000" {
+		t.Errorf("WorkingDir = %q, User = %q; want /app and 1000:1000", got.Config.WorkingDir, got.Config.User)
+	}
+	if n := len(got.History); n < 2 || !strings.HasPrefix(got.History[n-1].CreatedBy, "repo-lister append") {
+		t.Errorf("History does not record the appended layers: %v", got.History)
+	}
+}
+
+// TestAppendImageMountsBaseLayers tests that base layers in the same registry are
+// mounted at the destination rather than downloaded and uploaded again
+func TestAppendImageMountsBaseLayers(t *testing.T) {
+	reg := &mountRegistry{
+		handler:    registry.New(registry.Logger(log.New(io.Discard, "", 0))),
+		allowMount: true,
+		mounted:    map[string]bool{},
+		uploaded:   map[string]bool{},
+	}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	base := pushRandomImage(t, host+"/staging/app:v1")
+	// Progress wraps the layers being pushed, which must not hide their source
+	useProgressLog(t, slog.LevelInfo)
+
+	opts := AppendOptions{Layers: []string{writeLayerDir(t)}}
+	if err := AppendImage(context.Background(), host+"/staging/app:v1", host+"/prod/app:v1", "", "default", "", "default", opts); err != nil {
+		t.Fatalf("AppendImage() failed: %v", err)
+	}
+
+	for _, digest := range layerDigests(t, base) {
+		if !reg.mounted[digest] || reg.uploaded[digest] {
+			t.Errorf("Base layer %s: mounted = %v, uploaded = %v; want mounted only", digest, reg.mounted[digest], reg.uploaded[digest])
+		}
+	}
+	appended := layerDigests(t, remoteImage(t, host+"/prod/app:v1"))
+	if last := appended[len(appended)-1]; !reg.uploaded[last] {
+		t.Errorf("Appended layer %s was not uploaded", last)
+	}
+}
+
+// TestAppendImageValidation tests rejecting appends before contacting a registry
+func TestAppendImageValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        AppendOptions
+		errContains string
+	}{
+		{name: "nothing to do", opts: AppendOptions{}, errContains: "nothing to append"},
+		{name: "missing layer", opts: AppendOptions{Layers: []string{filepath.Join(t.TempDir(), "missing.tar")}}, errContains: "failed to read layer source"},
+		{name: "invalid platform", opts: AppendOptions{Cmd: []string{"run"}, Platform: "not/a/valid/platform"}, errContains: "invalid platform"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AppendImage(context.Background(), "registry.io/base:v1", "registry.io/app:v1", "", "default", "", "default", tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("AppendImage() error = %v, want error containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
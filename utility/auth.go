@@ -39,7 +39,7 @@ func CreateK8sClient() (*kubernetes.Clientset, error) {
 // CreateKeychain creates a keychain for registry authentication.
 // If secretName is empty, it returns the default anonymous keychain for public registries.
 // Otherwise, it uses k8schain with the specified Kubernetes secret.
-func CreateKeychain(namespace, secretName string) (authn.Keychain, error) {
+func CreateKeychain(ctx context.Context, namespace, secretName string) (authn.Keychain, error) {
 	// If no secret is provided, use anonymous/default keychain (public registry)
 	if secretName == "" {
 		return authn.DefaultKeychain, nil
@@ -50,7 +50,6 @@ func CreateKeychain(namespace, secretName string) (authn.Keychain, error) {
 		return nil, err
 	}
 
-	ctx := context.Background()
 	kc, err := k8schain.New(ctx, clientset, k8schain.Options{
 		Namespace:        namespace,
 		ImagePullSecrets: []string{secretName},
@@ -61,3 +60,19 @@ func CreateKeychain(namespace, secretName string) (authn.Keychain, error) {
 
 	return kc, nil
 }
+
+// createPodKeychain creates a keychain that resolves credentials the same way the
+// kubelet does for a pod: the pod's imagePullSecrets plus any pull secrets attached
+// to its service account.
+func createPodKeychain(ctx context.Context, client kubernetes.Interface, namespace, serviceAccount string, pullSecrets []string) (authn.Keychain, error) {
+	kc, err := k8schain.New(ctx, client, k8schain.Options{
+		Namespace:          namespace,
+		ServiceAccountName: serviceAccount,
+		ImagePullSecrets:   pullSecrets,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes keychain for service account '%s': %w", serviceAccount, err)
+	}
+
+	return kc, nil
+}
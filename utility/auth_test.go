@@ -1,6 +1,7 @@
 package utility
 
 import (
+	"context"
 	"testing"
 )
 
@@ -36,7 +37,7 @@ func TestCreateKeychainValidation(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Note: This will fail in CI/test environments without k8s cluster
 			// Real validation would need mocking or integration test environment
-			_, err := CreateKeychain(tt.namespace, tt.secret)
+			_, err := CreateKeychain(context.Background(), tt.namespace, tt.secret)
 
 			// In a real k8s environment, this should work
 			// In test environment, we expect an error (no k8s config)
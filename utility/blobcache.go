@@ -0,0 +1,179 @@
+package utility
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// blobCache keeps layer blobs on disk exactly as the registry serves them, keyed by
+// digest. Unlike cache.NewFilesystemCache, a blob is streamed to a temporary file
+// and only moved into place once it has been read completely and its digest
+// verified, so an interrupted download never leaves a truncated entry behind. It
+// does not implement cache.Cache, whose Get cannot tell which media type a blob
+// had; cachedArtifact wraps images with it instead.
+type blobCache struct {
+	dir string
+}
+
+// newBlobCache returns a blob cache rooted at dir. The directory is created on first write.
+func newBlobCache(dir string) *blobCache {
+	return &blobCache{dir: dir}
+}
+
+// path returns where the blob with digest h is stored
+func (c *blobCache) path(h v1.Hash) string {
+	return filepath.Join(c.dir, h.Algorithm, h.Hex)
+}
+
+// Has reports whether the blob with digest h is cached
+func (c *blobCache) Has(h v1.Hash) bool {
+	_, err := os.Stat(c.path(h))
+	return err == nil
+}
+
+// Put returns a layer that populates the cache as its compressed contents are read
+func (c *blobCache) Put(l v1.Layer) (v1.Layer, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	return &cachingLayer{Layer: l, cache: c, digest: digest}, nil
+}
+
+// Get returns the cached blob with digest h as a layer of the given media type, or
+// cache.ErrNotFound. The blob is served as stored, compressed or not, so the layer
+// keeps the digest it was cached under.
+func (c *blobCache) Get(h v1.Hash, mediaType types.MediaType) (v1.Layer, error) {
+	info, err := os.Stat(c.path(h))
+	if err != nil {
+		return nil, cache.ErrNotFound
+	}
+	// Record the use so "cache prune --older-than" keeps blobs that are still needed
+	now := time.Now()
+	_ = os.Chtimes(c.path(h), now, now)
+
+	l, err := partial.CompressedToLayer(&cachedBlob{path: c.path(h), digest: h, size: info.Size(), mediaType: mediaType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached blob %s: %w", h, err)
+	}
+	return l, nil
+}
+
+// cachedBlob is a blob in the cache, read back as it was stored
+type cachedBlob struct {
+	path      string
+	digest    v1.Hash
+	size      int64
+	mediaType types.MediaType
+}
+
+func (b *cachedBlob) Digest() (v1.Hash, error)            { return b.digest, nil }
+func (b *cachedBlob) Size() (int64, error)                { return b.size, nil }
+func (b *cachedBlob) MediaType() (types.MediaType, error) { return b.mediaType, nil }
+func (b *cachedBlob) Compressed() (io.ReadCloser, error)  { return os.Open(b.path) }
+
+// Delete removes the blob with digest h from the cache
+func (c *blobCache) Delete(h v1.Hash) error {
+	err := os.Remove(c.path(h))
+	if errors.Is(err, os.ErrNotExist) {
+		return cache.ErrNotFound
+	}
+	return err
+}
+
+// cachingLayer tees the compressed stream of a layer into the blob cache
+type cachingLayer struct {
+	v1.Layer
+	cache  *blobCache
+	digest v1.Hash
+}
+
+func (l *cachingLayer) Compressed() (io.ReadCloser, error) {
+	dest := l.cache.path(l.digest)
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), l.digest.Hex+".*.partial")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache file: %w", err)
+	}
+
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	h := sha256.New()
+	return &cacheWriter{
+		src:    rc,
+		tee:    io.TeeReader(rc, io.MultiWriter(tmp, h)),
+		tmp:    tmp,
+		hasher: h,
+		digest: l.digest,
+		dest:   dest,
+	}, nil
+}
+
+// cacheWriter commits the temporary cache file as soon as the blob has been read to
+// the end and matched its digest (tarball.Write never closes layer readers), and
+// discards it on Close otherwise
+type cacheWriter struct {
+	src       io.ReadCloser
+	tee       io.Reader
+	tmp       *os.File
+	hasher    hash.Hash
+	digest    v1.Hash
+	dest      string
+	committed bool
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.tee.Read(p)
+	if err == io.EOF && !w.committed {
+		if cerr := w.commit(); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
+}
+
+// commit moves the completed blob into the cache
+func (w *cacheWriter) commit() error {
+	w.committed = true
+	if err := w.tmp.Close(); err != nil {
+		os.Remove(w.tmp.Name())
+		return fmt.Errorf("failed to write blob %s to cache: %w", w.digest, err)
+	}
+	if w.digest.Algorithm != "sha256" || hex.EncodeToString(w.hasher.Sum(nil)) != w.digest.Hex {
+		os.Remove(w.tmp.Name())
+		return nil
+	}
+	if err := os.Rename(w.tmp.Name(), w.dest); err != nil {
+		os.Remove(w.tmp.Name())
+		return fmt.Errorf("failed to store blob %s in cache: %w", w.digest, err)
+	}
+	return nil
+}
+
+func (w *cacheWriter) Close() error {
+	if !w.committed {
+		w.committed = true
+		w.tmp.Close()
+		os.Remove(w.tmp.Name())
+	}
+	return w.src.Close()
+}
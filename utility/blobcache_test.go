@@ -0,0 +1,92 @@
+package utility
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// TestBlobCache tests that only completely read blobs are committed to the cache
+func TestBlobCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		readBytes  int64 // -1 reads the whole blob
+		wantCached bool
+	}{
+		{name: "complete read is cached", readBytes: -1, wantCached: true},
+		{name: "interrupted read is discarded", readBytes: 10, wantCached: false},
+		{name: "unread blob is discarded", readBytes: 0, wantCached: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			blobs := newBlobCache(dir)
+
+			layer, err := random.Layer(2048, "application/vnd.oci.image.layer.v1.tar+gzip")
+			if err != nil {
+				t.Fatalf("Failed to create random layer: %v", err)
+			}
+			digest, _ := layer.Digest()
+
+			cl, err := blobs.Put(layer)
+			if err != nil {
+				t.Fatalf("Put() failed: %v", err)
+			}
+			rc, err := cl.Compressed()
+			if err != nil {
+				t.Fatalf("Compressed() failed: %v", err)
+			}
+			if tt.readBytes < 0 {
+				_, err = io.Copy(io.Discard, rc)
+			} else {
+				_, err = io.CopyN(io.Discard, rc, tt.readBytes)
+			}
+			if err != nil {
+				t.Fatalf("Failed to read layer: %v", err)
+			}
+			if err := rc.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+
+			if got := blobs.Has(digest); got != tt.wantCached {
+				t.Errorf("Has() = %v, want %v", got, tt.wantCached)
+			}
+
+			cached, err := blobs.Get(digest, types.OCILayer)
+			if !tt.wantCached {
+				if !errors.Is(err, cache.ErrNotFound) {
+					t.Errorf("Get() error = %v, want cache.ErrNotFound", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Get() failed: %v", err)
+				}
+				if got, _ := cached.Digest(); got != digest {
+					t.Errorf("Cached layer digest = %s, want %s", got, digest)
+				}
+			}
+
+			// No temporary files may be left behind either way
+			partials, _ := filepath.Glob(filepath.Join(dir, "*", "*.partial"))
+			if len(partials) != 0 {
+				t.Errorf("Temporary cache files left behind: %v", partials)
+			}
+
+			if tt.wantCached {
+				if err := blobs.Delete(digest); err != nil {
+					t.Errorf("Delete() failed: %v", err)
+				}
+				if _, err := os.Stat(blobs.path(digest)); !os.IsNotExist(err) {
+					t.Errorf("Blob still present after Delete()")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package utility
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// bundleManifestFile is the file at the root of a bundle that records the original
+// reference of every image stored in it
+const bundleManifestFile = "bundle.json"
+
+// refNameAnnotation is the standard OCI annotation naming an image in a layout
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// bundleManifest lists the images stored in a bundle
+type bundleManifest struct {
+	Images []bundleImage `json:"images"`
+}
+
+// bundleImage is one image or index stored in a bundle
+type bundleImage struct {
+	Reference string          `json:"reference"`
+	Digest    string          `json:"digest"`
+	MediaType types.MediaType `json:"mediaType"`
+}
+
+// ExportBundle pulls every image listed in imagesFile, one reference per line, into a
+// single OCI image layout archive at outPath. Multi-arch indexes are stored with all
+// their platforms. The archive records the original reference of each image so
+// ImportBundle can push them to another registry.
+func ExportBundle(ctx context.Context, imagesFile string, outPath string, secretName string, namespace string) error {
+	images, err := readImageList(imagesFile)
+	if err != nil {
+		return err
+	}
+
+	kc, err := CreateKeychain(ctx, namespace, secretName)
+	if err != nil {
+		return fmt.Errorf("failed to create keychain: %w", err)
+	}
+
+	workDir, err := os.MkdirTemp(filepath.Dir(outPath), ".bundle-*")
+	if err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	lp, err := layout.Write(workDir, empty.Index)
+	if err != nil {
+		return fmt.Errorf("failed to create OCI layout: %w", err)
+	}
+
+	logInfof("Exporting %d images to %s...", len(images), outPath)
+
+	var manifest bundleManifest
+	for i, image := range images {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("export stopped after %d of %d images: %w", i, len(images), err)
+		}
+		logInfof("[%d/%d] %s", i+1, len(images), image)
+
+		entry, err := exportBundleImage(ctx, lp, image, kc)
+		if err != nil {
+			return err
+		}
+		manifest.Images = append(manifest.Images, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, bundleManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+
+	if err := writeDirArchive(workDir, outPath); err != nil {
+		return fmt.Errorf("failed to write bundle '%s': %w", outPath, err)
+	}
+
+	logInfof("✓ Successfully exported %d images to %s", len(images), outPath)
+	return nil
+}
+
+// exportBundleImage appends one image or index to the layout
+func exportBundleImage(ctx context.Context, lp layout.Path, image string, kc authn.Keychain) (bundleImage, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return bundleImage{}, fmt.Errorf("failed to parse image reference '%s': %w", image, err)
+	}
+
+	desc, err := remote.Get(ref, remoteOptions(ctx, kc)...)
+	if err != nil {
+		return bundleImage{}, HandleRegistryError(err, "exporting image", image)
+	}
+
+	annotations := layout.WithAnnotations(map[string]string{refNameAnnotation: ref.String()})
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return bundleImage{}, HandleRegistryError(err, "exporting image index", image)
+		}
+		err = lp.AppendIndex(idx, annotations)
+		if err != nil {
+			return bundleImage{}, HandleRegistryError(err, "exporting image index", image)
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return bundleImage{}, HandleRegistryError(err, "exporting image", image)
+		}
+		if err := lp.AppendImage(img, annotations); err != nil {
+			return bundleImage{}, HandleRegistryError(err, "exporting image", image)
+		}
+	}
+
+	return bundleImage{Reference: ref.String(), Digest: desc.Digest.String(), MediaType: desc.MediaType}, nil
+}
+
+// ImportBundle pushes every image in a bundle written by ExportBundle to
+// targetRegistry, keeping each image's repository path and tag under the new
+// registry prefix (docker.io/library/nginx:1.27 -> target/library/nginx:1.27).
+func ImportBundle(ctx context.Context, bundlePath string, targetRegistry string, secretName string, namespace string, showProgress bool) error {
+	if strings.Trim(targetRegistry, "/") == "" {
+		return fmt.Errorf("target registry must not be empty")
+	}
+
+	kc, err := CreateKeychain(ctx, namespace, secretName)
+	if err != nil {
+		return fmt.Errorf("failed to create keychain: %w", err)
+	}
+
+	workDir, err := os.MkdirTemp("", "repo-lister-bundle-*")
+	if err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := extractArchive(bundlePath, workDir); err != nil {
+		return fmt.Errorf("failed to read bundle '%s': %w", bundlePath, err)
+	}
+
+	manifest, err := readBundleManifest(workDir)
+	if err != nil {
+		return err
+	}
+	lp, err := layout.FromPath(workDir)
+	if err != nil {
+		return fmt.Errorf("bundle '%s' is not an OCI image layout: %w", bundlePath, err)
+	}
+	root, err := lp.ImageIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read bundle index: %w", err)
+	}
+
+	logInfof("Importing %d images from %s to %s...", len(manifest.Images), bundlePath, targetRegistry)
+
+	var failures []error
+	for i, image := range manifest.Images {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("import stopped after %d of %d images: %w", i, len(manifest.Images), err)
+		}
+
+		destImage, err := mirrorDestination(image.Reference, targetRegistry)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		logInfof("[%d/%d] %s -> %s", i+1, len(manifest.Images), image.Reference, destImage)
+
+		if err := importBundleImage(ctx, root, image, destImage, kc, showProgress); err != nil {
+			logWarnf("✗ %v", err)
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to import %d of %d images: %w", len(failures), len(manifest.Images), errors.Join(failures...))
+	}
+
+	logInfof("✓ Successfully imported %d images to %s", len(manifest.Images), targetRegistry)
+	return nil
+}
+
+// importBundleImage pushes one image or index from the bundle layout to destImage
+func importBundleImage(ctx context.Context, root v1.ImageIndex, image bundleImage, destImage string, kc authn.Keychain, showProgress bool) error {
+	dstRef, err := name.ParseReference(destImage)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination image reference '%s': %w", destImage, err)
+	}
+	digest, err := v1.NewHash(image.Digest)
+	if err != nil {
+		return fmt.Errorf("invalid digest %q for %s in bundle manifest: %w", image.Digest, image.Reference, err)
+	}
+
+	var artifact remote.Taggable
+	if image.MediaType.IsIndex() {
+		artifact, err = root.ImageIndex(digest)
+	} else {
+		artifact, err = root.Image(digest)
+	}
+	if err != nil {
+		return fmt.Errorf("image %s missing from bundle: %w", digest, err)
+	}
+
+	writeCtx, artifact, stopProgress := trackProgress(ctx, progressUpload, artifact, showProgress)
+
+	if idx, ok := artifact.(v1.ImageIndex); ok {
+		err = remote.WriteIndex(dstRef, idx, remoteOptions(writeCtx, kc)...)
+		if err == nil {
+			progressManifestWritten(writeCtx, destImage, idx)
+		}
+		stopProgress()
+		return HandleRegistryError(err, "pushing image index", destImage)
+	}
+	img := artifact.(v1.Image)
+	err = remote.Write(dstRef, img, remoteOptions(writeCtx, kc)...)
+	if err == nil {
+		progressManifestWritten(writeCtx, destImage, img)
+	}
+	stopProgress()
+	return HandleRegistryError(err, "pushing image", destImage)
+}
+
+// readImageList reads image references from path, one per line. Blank lines and
+// lines starting with # are ignored.
+func readImageList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image list: %w", err)
+	}
+	defer f.Close()
+
+	var images []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		images = append(images, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read image list: %w", err)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("image list '%s' contains no images", path)
+	}
+	return images, nil
+}
+
+// readBundleManifest loads the bundle manifest from an extracted bundle
+func readBundleManifest(dir string) (bundleManifest, error) {
+	var manifest bundleManifest
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifestFile))
+	if err != nil {
+		return manifest, fmt.Errorf("bundle has no %s, was it created with 'bundle export'?: %w", bundleManifestFile, err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse %s: %w", bundleManifestFile, err)
+	}
+	if len(manifest.Images) == 0 {
+		return manifest, fmt.Errorf("bundle contains no images")
+	}
+	return manifest, nil
+}
+
+// writeDirArchive writes the regular files under dir to a tar archive at outPath.
+// The archive is written under a temporary name and renamed once complete.
+func writeDirArchive(dir string, outPath string) error {
+	tmp, err := createPartialFile(outPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	tw := tar.NewWriter(tmp)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     filepath.ToSlash(rel),
+			Mode:     0644,
+			Size:     info.Size(),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), outPath)
+}
+
+// extractArchive unpacks the regular files of a tar archive into dir, rejecting
+// entries that would land outside it
+func extractArchive(archivePath string, dir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("archive entry %q escapes the bundle directory", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package utility
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// TestBundleRoundTrip tests exporting images and indexes and importing them elsewhere
+func TestBundleRoundTrip(t *testing.T) {
+	src := newTestRegistry(t)
+	dst := newTestRegistry(t)
+
+	img := pushRandomImage(t, src+"/team/app:v1")
+	idx, _ := newPlatformIndex(t, "linux/amd64", "linux/arm64")
+	idxRef, _ := name.ParseReference(src + "/library/base:1.0")
+	if err := remote.WriteIndex(idxRef, idx); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+	imgDigest, _ := img.Digest()
+	idxDigest, _ := idx.Digest()
+
+	dir := t.TempDir()
+	list := filepath.Join(dir, "images.txt")
+	content := "# release images\n" +
+		src + "/team/app:v1\n\n" +
+		src + "/library/base:1.0\n" +
+		src + "/team/app@" + imgDigest.String() + "\n" +
+		src + "/team/app:v1\n"
+	if err := os.WriteFile(list, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write image list: %v", err)
+	}
+
+	bundle := filepath.Join(dir, "bundle.tar")
+	if err := ExportBundle(context.Background(), list, bundle, "", "default"); err != nil {
+		t.Fatalf("ExportBundle() failed: %v", err)
+	}
+	// The bundle is as readable as a directly created file, not owner-only
+	probe, _ := os.Create(filepath.Join(dir, "probe"))
+	probe.Close()
+	probeInfo, _ := os.Stat(probe.Name())
+	info, err := os.Stat(bundle)
+	if err != nil {
+		t.Fatalf("Failed to stat bundle: %v", err)
+	}
+	if info.Mode().Perm() != probeInfo.Mode().Perm() {
+		t.Errorf("Bundle mode = %v, want %v", info.Mode().Perm(), probeInfo.Mode().Perm())
+	}
+	if err := ImportBundle(context.Background(), bundle, dst+"/mirror", "", "default", false); err != nil {
+		t.Fatalf("ImportBundle() failed: %v", err)
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: dst + "/mirror/team/app:v1", want: imgDigest.String()},
+		{ref: dst + "/mirror/library/base:1.0", want: idxDigest.String()},
+		{ref: dst + "/mirror/team/app@" + imgDigest.String(), want: imgDigest.String()},
+	}
+	for _, tt := range tests {
+		ref, _ := name.ParseReference(tt.ref)
+		desc, err := remote.Head(ref)
+		if err != nil {
+			t.Errorf("Imported image %s not found: %v", tt.ref, err)
+			continue
+		}
+		if desc.Digest.String() != tt.want {
+			t.Errorf("%s digest = %s, want %s", tt.ref, desc.Digest, tt.want)
+		}
+	}
+
+	// Every platform of the index must have been carried over
+	importedIdx, err := remote.Index(mustParseRef(t, dst+"/mirror/library/base:1.0"))
+	if err != nil {
+		t.Fatalf("Failed to read imported index: %v", err)
+	}
+	manifest, _ := importedIdx.IndexManifest()
+	for _, child := range manifest.Manifests {
+		if _, err := importedIdx.Image(child.Digest); err != nil {
+			t.Errorf("Platform image %s missing after import: %v", child.Digest, err)
+		}
+	}
+}
+
+// TestReadImageList tests parsing image list files
+func TestReadImageList(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "comments, blanks and duplicates", content: "# base\nnginx:1.27\n\n  redis:7  \nnginx:1.27\n", want: []string{"nginx:1.27", "redis:7"}},
+		{name: "empty list", content: "# nothing\n\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "images.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write image list: %v", err)
+			}
+			got, err := readImageList(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readImageList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("readImageList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExtractArchiveRejectsTraversal tests that bundle entries cannot escape the target directory
+func TestExtractArchiveRejectsTraversal(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "evil.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	tw := tar.NewWriter(f)
+	_ = tw.WriteHeader(&tar.Header{Name: "../escaped", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	_, _ = tw.Write([]byte("x"))
+	tw.Close()
+	f.Close()
+
+	target := filepath.Join(dir, "out")
+	if err := extractArchive(archive, target); err == nil {
+		t.Errorf("extractArchive() accepted an entry outside the target directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("Archive entry was written outside the target directory")
+	}
+}
+
+// mustParseRef parses ref or fails the test
+func mustParseRef(t *testing.T, ref string) name.Reference {
+	t.Helper()
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference '%s': %v", ref, err)
+	}
+	return r
+}
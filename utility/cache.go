@@ -0,0 +1,297 @@
+package utility
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// CacheEntry describes one blob stored in a layer cache directory
+type CacheEntry struct {
+	Digest   v1.Hash
+	Size     int64
+	LastUsed time.Time
+}
+
+// cachedArtifact wraps an image or index so its layers are served from the cache at
+// dir when present and stored there as they are downloaded
+func cachedArtifact(artifact remote.Taggable, dir string) remote.Taggable {
+	blobs := newBlobCache(dir)
+	switch a := artifact.(type) {
+	case v1.ImageIndex:
+		return &cachedIndex{idx: a, c: blobs}
+	case v1.Image:
+		return &cachedImage{Image: a, c: blobs}
+	default:
+		return artifact
+	}
+}
+
+// cachedIndex is an index whose images serve their layers from a blob cache. It
+// cannot embed v1.ImageIndex, whose ImageIndex method would clash with the field name.
+type cachedIndex struct {
+	idx v1.ImageIndex
+	c   *blobCache
+}
+
+func (i *cachedIndex) MediaType() (types.MediaType, error) { return i.idx.MediaType() }
+func (i *cachedIndex) Digest() (v1.Hash, error)            { return i.idx.Digest() }
+func (i *cachedIndex) Size() (int64, error)                { return i.idx.Size() }
+func (i *cachedIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return i.idx.IndexManifest()
+}
+func (i *cachedIndex) RawManifest() ([]byte, error) { return i.idx.RawManifest() }
+
+func (i *cachedIndex) Image(h v1.Hash) (v1.Image, error) {
+	img, err := i.idx.Image(h)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedImage{Image: img, c: i.c}, nil
+}
+
+func (i *cachedIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	idx, err := i.idx.ImageIndex(h)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedIndex{idx: idx, c: i.c}, nil
+}
+
+// Layer returns a non-manifest blob the index lists, see progressIndex.Layer
+func (i *cachedIndex) Layer(h v1.Hash) (v1.Layer, error) {
+	wl, ok := i.idx.(indexWithLayers)
+	if !ok {
+		return nil, fmt.Errorf("index does not provide blob %s", h)
+	}
+	return wl.Layer(h)
+}
+
+// cachedImage is an image whose layers are served from a blob cache when present
+// and stored there as they are downloaded
+type cachedImage struct {
+	v1.Image
+	c *blobCache
+}
+
+func (i *cachedImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]v1.Layer, len(layers))
+	for j, l := range layers {
+		wrapped[j] = &cachedLayer{Layer: l, c: i.c}
+	}
+	return wrapped, nil
+}
+
+func (i *cachedImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	l, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedLayer{Layer: l, c: i.c}, nil
+}
+
+// cachedLayer reads a layer from the blob cache, with the media type the image
+// gives it, and otherwise downloads it into the cache
+type cachedLayer struct {
+	v1.Layer
+	c *blobCache
+}
+
+// cached returns the layer from the cache, or cache.ErrNotFound
+func (l *cachedLayer) cached() (v1.Layer, error) {
+	digest, err := l.Layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	mediaType, err := l.Layer.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	return l.c.Get(digest, mediaType)
+}
+
+func (l *cachedLayer) Compressed() (io.ReadCloser, error) {
+	cl, err := l.cached()
+	if errors.Is(err, cache.ErrNotFound) {
+		cl, err = l.c.Put(l.Layer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cl.Compressed()
+}
+
+func (l *cachedLayer) Uncompressed() (io.ReadCloser, error) {
+	cl, err := l.cached()
+	if errors.Is(err, cache.ErrNotFound) {
+		return l.Layer.Uncompressed()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cl.Uncompressed()
+}
+
+// partialGracePeriod is how long a temporary download file must have gone unwritten
+// before prune treats it as left behind by an interrupted download. Files written
+// more recently may belong to a pull or copy still running against the cache.
+const partialGracePeriod = time.Hour
+
+// cacheFile is a file in a cache directory that was written by repo-lister
+type cacheFile struct {
+	path    string
+	info    fs.FileInfo
+	digest  v1.Hash
+	partial bool
+}
+
+// ListCache returns the blobs in cacheDir, most recently used first. Temporary files
+// of downloads still in progress (or interrupted) are not listed.
+func ListCache(cacheDir string) ([]CacheEntry, error) {
+	var entries []CacheEntry
+	err := walkCache(cacheDir, func(f cacheFile) error {
+		if !f.partial {
+			entries = append(entries, CacheEntry{Digest: f.digest, Size: f.info.Size(), LastUsed: f.info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// CacheSize returns the number of blobs in cacheDir and their total size in bytes
+func CacheSize(cacheDir string) (int, int64, error) {
+	entries, err := ListCache(cacheDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	return len(entries), total, nil
+}
+
+// PruneCache removes blobs from cacheDir that have not been used for olderThan, or
+// every blob when olderThan is zero. Temporary files from interrupted downloads are
+// removed once they have not been written for partialGracePeriod. Files that are
+// not blobs or temporary files written by repo-lister are never touched. It returns
+// the number of files removed and the bytes freed.
+func PruneCache(cacheDir string, olderThan time.Duration) (int, int64, error) {
+	now := time.Now()
+	removed := 0
+	var freed int64
+
+	err := walkCache(cacheDir, func(f cacheFile) error {
+		age := now.Sub(f.info.ModTime())
+		if f.partial && age < partialGracePeriod {
+			return nil
+		}
+		if !f.partial && olderThan > 0 && age < olderThan {
+			return nil
+		}
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", f.path, err)
+		}
+		removed++
+		freed += f.info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+// walkCache calls fn for every blob (<algorithm>/<hex>) and temporary download file
+// (<algorithm>/<hex>.*.partial) in the cache directory. Anything else, including
+// files in deeper directories, is skipped. A cache directory that does not exist
+// yet is treated as empty.
+func walkCache(cacheDir string, fn func(f cacheFile) error) error {
+	if cacheDir == "" {
+		return fmt.Errorf("no cache directory given (use --cache-dir)")
+	}
+	algorithms, err := os.ReadDir(cacheDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory '%s': %w", cacheDir, err)
+	}
+
+	for _, alg := range algorithms {
+		if !alg.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cacheDir, alg.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read cache directory '%s': %w", dir, err)
+		}
+		for _, file := range files {
+			if !file.Type().IsRegular() {
+				continue
+			}
+			f, ok := parseCacheFile(alg.Name(), file.Name())
+			if !ok {
+				// Not written by repo-lister
+				continue
+			}
+			f.path = filepath.Join(dir, file.Name())
+			if f.info, err = file.Info(); err != nil {
+				return fmt.Errorf("failed to read cache directory '%s': %w", dir, err)
+			}
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseCacheFile reports whether name, in the directory for algorithm, is a blob or
+// a temporary download file of one
+func parseCacheFile(algorithm, name string) (cacheFile, bool) {
+	hex, partial := name, false
+	if strings.HasSuffix(name, ".partial") {
+		hex, _, _ = strings.Cut(name, ".")
+		partial = true
+	}
+	digest, err := v1.NewHash(algorithm + ":" + hex)
+	if err != nil {
+		return cacheFile{}, false
+	}
+	return cacheFile{digest: digest, partial: partial}, true
+}
+
+// FormatBytes renders a byte count using binary units, e.g. "12.3 MiB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,246 @@
+package utility
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// TestListAndPruneCache tests listing, sizing and pruning a cache directory
+func TestListAndPruneCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		olderThan   time.Duration
+		wantRemoved int
+		wantLeft    int
+	}{
+		{name: "prune everything", olderThan: 0, wantRemoved: 3, wantLeft: 0},
+		{name: "prune unused layers", olderThan: time.Hour, wantRemoved: 2, wantLeft: 1},
+		{name: "nothing old enough", olderThan: 48 * time.Hour, wantRemoved: 1, wantLeft: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			blobs := newBlobCache(dir)
+
+			// Two cached layers, one last used a day ago, plus an interrupted download
+			for i := 0; i < 2; i++ {
+				layer, _ := random.Layer(1024, "application/vnd.oci.image.layer.v1.tar+gzip")
+				cl, _ := blobs.Put(layer)
+				rc, _ := cl.Compressed()
+				_, _ = io.Copy(io.Discard, rc)
+				rc.Close()
+				if i == 0 {
+					digest, _ := layer.Digest()
+					old := time.Now().Add(-24 * time.Hour)
+					_ = os.Chtimes(blobs.path(digest), old, old)
+				}
+			}
+			// An interrupted download and one still in progress
+			for hex, age := range map[string]time.Duration{"a": 2 * partialGracePeriod, "b": 0} {
+				path := filepath.Join(dir, "sha256", strings.Repeat(hex, 64)+".123.partial")
+				if err := os.WriteFile(path, []byte("x"), 0600); err != nil {
+					t.Fatalf("Failed to write partial file: %v", err)
+				}
+				mtime := time.Now().Add(-age)
+				_ = os.Chtimes(path, mtime, mtime)
+			}
+
+			entries, err := ListCache(dir)
+			if err != nil {
+				t.Fatalf("ListCache() failed: %v", err)
+			}
+			if len(entries) != 2 {
+				t.Fatalf("ListCache() returned %d entries, want 2", len(entries))
+			}
+			if !entries[0].LastUsed.After(entries[1].LastUsed) {
+				t.Errorf("ListCache() not sorted by most recent use")
+			}
+
+			count, size, err := CacheSize(dir)
+			if err != nil {
+				t.Fatalf("CacheSize() failed: %v", err)
+			}
+			if count != 2 || size != entries[0].Size+entries[1].Size {
+				t.Errorf("CacheSize() = %d, %d; want 2, %d", count, size, entries[0].Size+entries[1].Size)
+			}
+
+			removed, _, err := PruneCache(dir, tt.olderThan)
+			if err != nil {
+				t.Fatalf("PruneCache() failed: %v", err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("PruneCache() removed %d files, want %d", removed, tt.wantRemoved)
+			}
+			if left, _, _ := CacheSize(dir); left != tt.wantLeft {
+				t.Errorf("%d layers left after prune, want %d", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+// TestPruneCacheKeepsOtherFiles tests that prune only removes files written by
+// repo-lister, so a mistyped --cache-dir cannot delete unrelated data
+func TestPruneCacheKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	blobs := newBlobCache(dir)
+	layer, _ := random.Layer(1024, "application/vnd.oci.image.layer.v1.tar+gzip")
+	cl, _ := blobs.Put(layer)
+	rc, _ := cl.Compressed()
+	_, _ = io.Copy(io.Discard, rc)
+	rc.Close()
+
+	others := []string{
+		"notes.txt",
+		filepath.Join("sha256", "README"),
+		filepath.Join("sha256", "abc.partial"),
+		filepath.Join("documents", "report.pdf"),
+		filepath.Join("project", "sha256", strings.Repeat("a", 64)),
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range others {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("keep"), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		_ = os.Chtimes(path, old, old)
+	}
+
+	removed, _, err := PruneCache(dir, 0)
+	if err != nil {
+		t.Fatalf("PruneCache() failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("PruneCache() removed %d files, want only the cached layer", removed)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed by prune: %v", name, err)
+		}
+	}
+}
+
+// TestListCacheMissingDir tests that a cache that was never written is empty
+func TestListCacheMissingDir(t *testing.T) {
+	entries, err := ListCache(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("ListCache() = %v, %v; want empty", entries, err)
+	}
+	if _, err := ListCache(""); err == nil {
+		t.Errorf("ListCache(\"\") succeeded, want error")
+	}
+}
+
+// TestFormatBytes tests human-readable sizes
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{in: 512, want: "512 B"},
+		{in: 2048, want: "2.0 KiB"},
+		{in: 5 * 1024 * 1024, want: "5.0 MiB"},
+		{in: 3 * 1024 * 1024 * 1024, want: "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.in); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestCopyImageCacheDir tests that cross-registry copies reuse cached layers
+func TestCopyImageCacheDir(t *testing.T) {
+	src := &failingBlobRegistry{
+		handler:   registry.New(registry.Logger(log.New(io.Discard, "", 0))),
+		blobFetch: map[string]int{},
+	}
+	server := httptest.NewServer(src)
+	defer server.Close()
+	srcHost := strings.TrimPrefix(server.URL, "http://")
+
+	img := pushRandomImage(t, srcHost+"/app:v1")
+	cacheDir := t.TempDir()
+	opts := CopyOptions{CacheDir: cacheDir}
+
+	for _, dst := range []string{newTestRegistry(t), newTestRegistry(t)} {
+		if err := CopyImage(context.Background(), srcHost+"/app:v1", dst+"/app:v1", "", "", "default", "default", false, opts); err != nil {
+			t.Fatalf("CopyImage() to %s failed: %v", dst, err)
+		}
+	}
+
+	layers, _ := img.Layers()
+	for _, l := range layers {
+		digest, _ := l.Digest()
+		if got := src.blobFetch[digest.String()]; got != 1 {
+			t.Errorf("Layer %s downloaded %d times from source, want 1", digest, got)
+		}
+	}
+}
+
+// TestCopyImageCacheDirUncompressedLayer tests that uncompressed layers come out of
+// the cache as stored, so the copied image keeps its digest
+func TestCopyImageCacheDirUncompressedLayer(t *testing.T) {
+	src := newTestRegistry(t)
+	contents := []byte("uncompressed layer contents")
+	img, err := mutate.AppendLayers(empty.Image, static.NewLayer(contents, types.OCIUncompressedLayer))
+	if err != nil {
+		t.Fatalf("Failed to build image: %v", err)
+	}
+	pushTestImage(t, src+"/app:v1", img)
+	want, _ := img.Digest()
+
+	cacheDir := t.TempDir()
+	opts := CopyOptions{CacheDir: cacheDir}
+
+	// The first copy fills the cache, the second is served from it
+	for _, dst := range []string{newTestRegistry(t), newTestRegistry(t)} {
+		if err := CopyImage(context.Background(), src+"/app:v1", dst+"/app:v1", "", "", "default", "default", false, opts); err != nil {
+			t.Fatalf("CopyImage() to %s failed: %v", dst, err)
+		}
+		ref, _ := name.ParseReference(dst + "/app:v1")
+		got, err := remote.Image(ref)
+		if err != nil {
+			t.Fatalf("Failed to read copied image: %v", err)
+		}
+		if digest, _ := got.Digest(); digest != want {
+			t.Errorf("Copied image digest in %s = %s, want %s", dst, digest, want)
+		}
+		layers, err := got.Layers()
+		if err != nil || len(layers) != 1 {
+			t.Fatalf("Failed to read copied layers: %v", err)
+		}
+		rc, err := layers[0].Compressed()
+		if err != nil {
+			t.Fatalf("Failed to read copied layer: %v", err)
+		}
+		blob, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read copied layer: %v", err)
+		}
+		if string(blob) != string(contents) {
+			t.Errorf("Copied layer in %s = %q, want %q", dst, blob, contents)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// clobberPolicy decides which destination tags may not be moved to a different digest
+type clobberPolicy struct {
+	// pattern limits protection to matching tags; nil protects every tag
+	pattern *regexp.Regexp
+}
+
+// newClobberPolicy returns the policy for --no-clobber and --no-clobber-pattern, or
+// nil when tags may be overwritten. A pattern on its own turns protection on for
+// the tags it matches.
+func newClobberPolicy(noClobber bool, pattern string) (*clobberPolicy, error) {
+	if pattern == "" {
+		if !noClobber {
+			return nil, nil
+		}
+		return &clobberPolicy{}, nil
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling no-clobber pattern '%s': %w", pattern, err)
+	}
+	return &clobberPolicy{pattern: regex}, nil
+}
+
+// protects reports whether ref is a tag this policy keeps from being overwritten.
+// Digest references can never be overwritten with different content.
+func (p *clobberPolicy) protects(ref name.Reference) bool {
+	if p == nil {
+		return false
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return false
+	}
+	return p.pattern == nil || p.pattern.MatchString(tag.TagStr())
+}
+
+// check fails if ref is protected and already exists with a digest other than
+// digest. Writing the same digest again is allowed. The check is not atomic with
+// the write that follows it, so it guards against mistakes, not concurrent writers.
+func (p *clobberPolicy) check(ctx context.Context, ref name.Reference, digest v1.Hash, kc authn.Keychain) error {
+	if !p.protects(ref) {
+		return nil
+	}
+
+	desc, err := remote.Head(ref, remoteOptions(ctx, kc)...)
+	var terr *transport.Error
+	switch {
+	case err == nil && desc.Digest != digest:
+		return fmt.Errorf("tag '%s' already exists with digest %s; refusing to replace it with %s (no-clobber)", ref, desc.Digest, digest)
+	case err == nil:
+		logDebugf("Tag %s already points to %s", ref, digest)
+		return nil
+	case errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound:
+		return nil
+	default:
+		return HandleRegistryError(err, "checking destination", ref.String())
+	}
+}
@@ -0,0 +1,105 @@
+package utility
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// TestClobberPolicy tests which destination references a policy protects
+func TestClobberPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		noClobber bool
+		pattern   string
+		ref       string
+		want      bool
+		wantErr   bool
+	}{
+		{name: "disabled", ref: "registry.io/app:v1.0.0", want: false},
+		{name: "every tag", noClobber: true, ref: "registry.io/app:latest", want: true},
+		{name: "matching pattern", pattern: `^v\d+\.\d+\.\d+$`, ref: "registry.io/app:v1.0.0", want: true},
+		{name: "pattern implies no-clobber", noClobber: false, pattern: `^v\d+\.\d+\.\d+$`, ref: "registry.io/app:v1.0.0", want: true},
+		{name: "non-matching tag", noClobber: true, pattern: `^v\d+\.\d+\.\d+$`, ref: "registry.io/app:latest", want: false},
+		{name: "digest reference", noClobber: true, ref: "registry.io/app@sha256:" + strings.Repeat("a", 64), want: false},
+		{name: "invalid pattern", pattern: "[invalid((", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newClobberPolicy(tt.noClobber, tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newClobberPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := policy.protects(mustParseRef(t, tt.ref)); got != tt.want {
+				t.Errorf("protects(%s) = %v, want %v", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCopyImageNoClobber tests that copy refuses to move a protected tag
+func TestCopyImageNoClobber(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/staging/app:v1.0.0")
+	pushRandomImage(t, reg+"/prod/app:v1.0.0")
+	pushRandomImage(t, reg+"/prod/app:latest")
+
+	tests := []struct {
+		name        string
+		dest        string
+		opts        CopyOptions
+		errContains string
+	}{
+		{name: "existing tag with different digest", dest: reg + "/prod/app:v1.0.0", opts: CopyOptions{NoClobber: true}, errContains: "already exists"},
+		{name: "dry run still checks", dest: reg + "/prod/app:v1.0.0", opts: CopyOptions{NoClobber: true, DryRun: true}, errContains: "already exists"},
+		{name: "new tag", dest: reg + "/prod/app:v1.0.1", opts: CopyOptions{NoClobber: true}},
+		{name: "same digest again", dest: reg + "/prod/app:v1.0.1", opts: CopyOptions{NoClobber: true}},
+		{name: "unprotected tag", dest: reg + "/prod/app:latest", opts: CopyOptions{NoClobberPattern: `^v\d+\.\d+\.\d+$`}},
+		{name: "invalid pattern", dest: reg + "/prod/app:v2", opts: CopyOptions{NoClobberPattern: "[invalid(("}, errContains: "no-clobber pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CopyImage(context.Background(), reg+"/staging/app:v1.0.0", tt.dest, "", "", "default", "default", false, tt.opts)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("CopyImage() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CopyImage() failed: %v", err)
+			}
+		})
+	}
+}
+
+// TestPushImageNoClobber tests that push refuses to move a protected tag
+func TestPushImageNoClobber(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/app:v1.0.0")
+
+	img := pushRandomImage(t, reg+"/app:v1.0.1")
+	tarPath := filepath.Join(t.TempDir(), "app.tar")
+	ref, _ := name.ParseReference(reg + "/app:v1.0.1")
+	if err := tarball.WriteToFile(tarPath, ref, img); err != nil {
+		t.Fatalf("Failed to write test tarball: %v", err)
+	}
+
+	err := PushImage(context.Background(), reg+"/app:v1.0.0", tarPath, "", "default", PushOptions{NoClobber: true})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("PushImage() error = %v, want error about the existing tag", err)
+	}
+
+	if err := PushImage(context.Background(), reg+"/app:v1.0.1", tarPath, "", "default", PushOptions{NoClobber: true}); err != nil {
+		t.Errorf("PushImage() of the same digest failed: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package utility
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats for image tar files written by pull
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// validateCompression checks a compression format name. An empty name means none.
+func validateCompression(format string) error {
+	switch format {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression %q (use %s, %s or %s)", format, CompressionGzip, CompressionZstd, CompressionNone)
+	}
+}
+
+// compressWriter wraps w so that everything written to it is compressed with
+// format. Closing the returned writer flushes the compressor but does not close w.
+func compressWriter(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case "", CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, validateCompression(format)
+	}
+}
+
+// decompressStream returns a reader for the uncompressed contents of br, which may
+// be gzip, zstd or uncompressed; the format is detected from its first bytes
+func decompressStream(br *bufio.Reader) (io.ReadCloser, error) {
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// openArchive opens a tar file that may be gzip or zstd compressed and returns a
+// stream of its uncompressed contents. Nothing is decompressed to disk.
+func openArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := decompressStream(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to decompress '%s': %w", path, err)
+	}
+	return &archiveReader{ReadCloser: rc, file: f}, nil
+}
+
+// archiveReader closes both the decompressor and the underlying file
+type archiveReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (a *archiveReader) Close() error {
+	err := a.ReadCloser.Close()
+	if ferr := a.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,71 @@
+package utility
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPullPushCompressed tests that compressed tar files written by pull verify and
+// push back unchanged
+func TestPullPushCompressed(t *testing.T) {
+	tests := []struct {
+		compress string
+		magic    []byte
+	}{
+		{compress: CompressionNone},
+		{compress: CompressionGzip, magic: gzipMagic},
+		{compress: CompressionZstd, magic: zstdMagic},
+	}
+
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	want, _ := img.Digest()
+
+	for _, tt := range tests {
+		t.Run(tt.compress, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "image.tar")
+			if err := PullImage(context.Background(), reg+"/app:v1", outputPath, "", "default", PullOptions{Compress: tt.compress}); err != nil {
+				t.Fatalf("PullImage() failed: %v", err)
+			}
+
+			f, err := os.Open(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to open output: %v", err)
+			}
+			head, _ := bufio.NewReader(f).Peek(4)
+			f.Close()
+			if tt.magic != nil && !bytes.HasPrefix(head, tt.magic) {
+				t.Errorf("Output starts with %x, want %s magic %x", head, tt.compress, tt.magic)
+			}
+
+			if err := VerifyImageTarball(outputPath); err != nil {
+				t.Errorf("VerifyImageTarball() failed: %v", err)
+			}
+
+			dst := reg + "/pushed-" + tt.compress + ":v1"
+			if err := PushImage(context.Background(), dst, outputPath, "", "default", PushOptions{}); err != nil {
+				t.Fatalf("PushImage() failed: %v", err)
+			}
+			if got, _ := remoteImage(t, dst).Digest(); got != want {
+				t.Errorf("Pushed digest = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestPullImageInvalidCompression tests that unknown compression formats are rejected
+func TestPullImageInvalidCompression(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "image.tar")
+	err := PullImage(context.Background(), "nginx:latest", outputPath, "", "default", PullOptions{Compress: "bzip2"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported compression") {
+		t.Fatalf("PullImage() error = %v, want unsupported compression", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Output file %s exists after rejected pull", outputPath)
+	}
+}
@@ -0,0 +1,165 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// tagTemplateData is what a destination template can refer to for each source tag
+type tagTemplateData struct {
+	// Registry is the source registry host, e.g. "myregistry.io"
+	Registry string
+	// Repo is the source repository path without the registry, e.g. "team/app"
+	Repo string
+	// Name is the last element of Repo, e.g. "app"
+	Name string
+	// Tag is the source tag, e.g. "v1.2.0"
+	Tag string
+}
+
+// tagCopy is one source tag and the destination it is copied to
+type tagCopy struct {
+	Source      name.Tag
+	Destination name.Reference
+}
+
+// CopyTags copies every tag of sourceRepo matching the regex filter to a destination
+// rendered from destTemplate, a Go template such as
+// "mirror.io/{{.Repo}}:{{.Tag}}-mirrored". Tags are selected and ordered as by
+// ListImage. All destinations are rendered and checked before anything is copied;
+// a tag that fails to copy does not stop the others.
+func CopyTags(
+	ctx context.Context,
+	sourceRepo string,
+	filter string,
+	destTemplate string,
+	sourceSecret string,
+	destSecret string,
+	sourceNamespace string,
+	destNamespace string,
+	showProgress bool,
+	opts CopyOptions,
+) error {
+	tmpl, err := parseDestinationTemplate(destTemplate)
+	if err != nil {
+		return err
+	}
+	if _, err := newClobberPolicy(opts.NoClobber, opts.NoClobberPattern); err != nil {
+		return err
+	}
+
+	// Create source keychain
+	sourceKC, err := CreateKeychain(ctx, sourceNamespace, sourceSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create source keychain: %w", err)
+	}
+
+	// Create destination keychain
+	destKC, err := CreateKeychain(ctx, destNamespace, destSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create destination keychain: %w", err)
+	}
+
+	repoName := normalizeImageName(sourceRepo)
+	repo, err := name.NewRepository(repoName)
+	if err != nil {
+		return fmt.Errorf("failed to parse source repository '%s': %w", sourceRepo, err)
+	}
+
+	tags, err := listTags(ctx, repo, sourceKC, filter)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return fmt.Errorf("no tags in '%s' match filter '%s'", repoName, filter)
+	}
+
+	copies, err := planTagCopies(repo, sortTags(tags), tmpl)
+	if err != nil {
+		return err
+	}
+
+	logInfof("Copying %d tags from %s...", len(copies), repoName)
+
+	var failures []error
+	for i, c := range copies {
+		// Stop between tags once the command is cancelled
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("copying stopped after %d of %d tags: %w", i, len(copies), err)
+		}
+
+		logInfof("[%d/%d] %s -> %s", i+1, len(copies), c.Source, c.Destination)
+
+		if err := copyReference(ctx, c.Source, c.Destination, sourceKC, destKC, showProgress, opts); err != nil {
+			logWarnf("✗ %v", err)
+			failures = append(failures, err)
+			continue
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to copy %d of %d tags: %w", len(failures), len(copies), errors.Join(failures...))
+	}
+
+	if !opts.DryRun {
+		logInfof("✓ Successfully copied %d tags", len(copies))
+	}
+	return nil
+}
+
+// parseDestinationTemplate parses a destination template, rejecting references to
+// fields tagTemplateData does not have
+func parseDestinationTemplate(destTemplate string) (*template.Template, error) {
+	if strings.TrimSpace(destTemplate) == "" {
+		return nil, fmt.Errorf("destination template must not be empty")
+	}
+	tmpl, err := template.New("destination").Option("missingkey=error").Parse(destTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination template '%s': %w", destTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// planTagCopies renders the destination of every tag. It fails if a destination is
+// not a valid reference, is the source itself, or is shared by two tags, since one
+// copy would then silently overwrite the other.
+func planTagCopies(repo name.Repository, tags []string, tmpl *template.Template) ([]tagCopy, error) {
+	copies := make([]tagCopy, 0, len(tags))
+	seen := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		src := repo.Tag(tag)
+
+		data := tagTemplateData{
+			Registry: repo.RegistryStr(),
+			Repo:     repo.RepositoryStr(),
+			Name:     path.Base(repo.RepositoryStr()),
+			Tag:      tag,
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("failed to render destination for tag '%s': %w", tag, err)
+		}
+		destImage := strings.TrimSpace(b.String())
+
+		dst, err := name.ParseReference(destImage)
+		if err != nil {
+			return nil, fmt.Errorf("destination '%s' rendered for tag '%s' is not a valid image reference: %w", destImage, tag, err)
+		}
+		if dst.Name() == src.Name() {
+			return nil, fmt.Errorf("destination for tag '%s' is the source image itself: %s", tag, destImage)
+		}
+		if other, ok := seen[dst.Name()]; ok {
+			return nil, fmt.Errorf("tags '%s' and '%s' both render to destination %s", other, tag, destImage)
+		}
+		seen[dst.Name()] = tag
+
+		copies = append(copies, tagCopy{Source: src, Destination: dst})
+	}
+	return copies, nil
+}
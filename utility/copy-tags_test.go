@@ -0,0 +1,155 @@
+package utility
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// TestPlanTagCopies tests rendering and checking destinations from a template
+func TestPlanTagCopies(t *testing.T) {
+	repo, err := name.NewRepository("myregistry.io/team/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		template    string
+		tags        []string
+		want        []string
+		errContains string
+	}{
+		{
+			name:     "repo and tag",
+			template: "mirror.io/{{.Repo}}:{{.Tag}}-mirrored",
+			tags:     []string{"v1.1.0", "v1.0.0"},
+			want:     []string{"mirror.io/team/app:v1.1.0-mirrored", "mirror.io/team/app:v1.0.0-mirrored"},
+		},
+		{
+			name:     "registry and name",
+			template: "mirror.io/{{.Registry}}/{{.Name}}:{{.Tag}}",
+			tags:     []string{"latest"},
+			want:     []string{"mirror.io/myregistry.io/app:latest"},
+		},
+		{
+			name:        "same destination for two tags",
+			template:    "mirror.io/{{.Repo}}:latest",
+			tags:        []string{"v1.1.0", "v1.0.0"},
+			errContains: "both render to",
+		},
+		{
+			name:        "destination is the source",
+			template:    "{{.Registry}}/{{.Repo}}:{{.Tag}}",
+			tags:        []string{"v1.0.0"},
+			errContains: "source image itself",
+		},
+		{
+			name:        "invalid reference",
+			template:    "mirror.io/{{.Repo}}:{{.Tag}}:{{.Tag}}",
+			tags:        []string{"v1.0.0"},
+			errContains: "not a valid image reference",
+		},
+		{
+			name:        "unknown field",
+			template:    "mirror.io/{{.Repository}}:{{.Tag}}",
+			tags:        []string{"v1.0.0"},
+			errContains: "failed to render destination",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseDestinationTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("parseDestinationTemplate() failed: %v", err)
+			}
+
+			copies, err := planTagCopies(repo, tt.tags, tmpl)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("planTagCopies() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planTagCopies() failed: %v", err)
+			}
+
+			if len(copies) != len(tt.want) {
+				t.Fatalf("planTagCopies() returned %d copies, want %d", len(copies), len(tt.want))
+			}
+			for i, c := range copies {
+				if c.Destination.String() != tt.want[i] {
+					t.Errorf("Destination %d = %s, want %s", i, c.Destination, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestParseDestinationTemplate tests rejecting unusable templates
+func TestParseDestinationTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{name: "valid", template: "mirror.io/{{.Repo}}:{{.Tag}}", wantErr: false},
+		{name: "empty", template: " ", wantErr: true},
+		{name: "unclosed action", template: "mirror.io/{{.Repo:{{.Tag}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDestinationTemplate(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseDestinationTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCopyTags tests copying the tags matching a filter to templated destinations
+func TestCopyTags(t *testing.T) {
+	reg := newTestRegistry(t)
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0", "latest"} {
+		pushRandomImage(t, reg+"/team/app:"+tag)
+	}
+
+	err := CopyTags(context.Background(), reg+"/team/app", `^v1\.`, "{{.Registry}}/mirror/{{.Name}}:{{.Tag}}-mirrored", "", "", "default", "default", false, CopyOptions{})
+	if err != nil {
+		t.Fatalf("CopyTags() failed: %v", err)
+	}
+
+	for _, tag := range []string{"v1.0.0", "v1.1.0"} {
+		src, _ := remoteImage(t, reg+"/team/app:"+tag).Digest()
+		dst, _ := remoteImage(t, reg+"/mirror/app:"+tag+"-mirrored").Digest()
+		if src != dst {
+			t.Errorf("Copy of %s has digest %s, want %s", tag, dst, src)
+		}
+	}
+
+	repo, _ := name.NewRepository(reg + "/mirror/app")
+	tags, err := remote.List(repo)
+	if err != nil {
+		t.Fatalf("Failed to list destination tags: %v", err)
+	}
+	if len(tags) != 2 {
+		t.Errorf("Destination has tags %v, want only the two v1 tags", tags)
+	}
+}
+
+// TestCopyTagsNoMatch tests that a filter matching nothing is an error
+func TestCopyTagsNoMatch(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/app:v1.0.0")
+
+	err := CopyTags(context.Background(), reg+"/app", `^v9\.`, "{{.Registry}}/mirror:{{.Tag}}", "", "", "default", "default", false, CopyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "no tags") {
+		t.Errorf("CopyTags() error = %v, want error about no matching tags", err)
+	}
+}
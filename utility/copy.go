@@ -1,16 +1,51 @@
 package utility
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
+// CopyOptions holds optional behaviour for CopyImage.
+type CopyOptions struct {
+	// WithReferrers also copies signatures, SBOMs and attestations attached to the image
+	WithReferrers bool
+	// Verify requires a valid cosign signature on the source digest before copying
+	Verify VerifyOptions
+	// Platforms limits a multi-arch index to these platforms (e.g. linux/amd64)
+	Platforms []string
+	// Platform flattens a multi-arch index to the image for this single platform
+	Platform string
+	// CacheDir serves layers from, and stores downloaded layers in, this directory
+	CacheDir string
+	// DryRun reports which blobs would be transferred without writing anything
+	DryRun bool
+	// CheckPush makes a dry run confirm push access by starting and cancelling a
+	// blob upload at the destination
+	CheckPush bool
+	// Labels are set in the config of the image, or of every image in an index
+	Labels map[string]string
+	// Annotations are set on the manifest of the image or index, and of every image
+	// in an index
+	Annotations map[string]string
+	// Env sets environment variables in the config of the image, or of every image
+	// in an index. Labels, annotations and env give the copy a new digest.
+	Env map[string]string
+	// NoClobber refuses to move an existing destination tag to a different digest
+	NoClobber bool
+	// NoClobberPattern limits NoClobber to tags matching this regex, and turns it on
+	// when set on its own
+	NoClobberPattern string
+}
+
 // CopyImage copies an image from source to destination registry without local storage
 // It supports different source and destination secrets for cross-registry copying
 func CopyImage(
+	ctx context.Context,
 	sourceImage string,
 	destImage string,
 	sourceSecret string,
@@ -18,20 +53,25 @@ func CopyImage(
 	sourceNamespace string,
 	destNamespace string,
 	showProgress bool,
+	opts CopyOptions,
 ) error {
 	// Validate that source and destination are different
 	if sourceImage == destImage {
 		return fmt.Errorf("source and destination images are identical: %s", sourceImage)
 	}
 
+	if _, err := newClobberPolicy(opts.NoClobber, opts.NoClobberPattern); err != nil {
+		return err
+	}
+
 	// Create source keychain
-	sourceKC, err := CreateKeychain(sourceNamespace, sourceSecret)
+	sourceKC, err := CreateKeychain(ctx, sourceNamespace, sourceSecret)
 	if err != nil {
 		return fmt.Errorf("failed to create source keychain: %w", err)
 	}
 
 	// Create destination keychain
-	destKC, err := CreateKeychain(destNamespace, destSecret)
+	destKC, err := CreateKeychain(ctx, destNamespace, destSecret)
 	if err != nil {
 		return fmt.Errorf("failed to create destination keychain: %w", err)
 	}
@@ -48,86 +88,269 @@ func CopyImage(
 		return fmt.Errorf("failed to parse destination image reference '%s': %w", destImage, err)
 	}
 
-	if showProgress {
-		fmt.Printf("Copying image...\n")
-		fmt.Printf("  Source:      %s\n", sourceImage)
-		fmt.Printf("  Destination: %s\n", destImage)
-		fmt.Println()
+	logStatusf(showProgress, "Copying image...")
+	logStatusf(showProgress, "  Source:      %s", sourceImage)
+	logStatusf(showProgress, "  Destination: %s", destImage)
+
+	if err := copyReference(ctx, srcRef, dstRef, sourceKC, destKC, showProgress, opts); err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		logInfof("✓ Successfully copied image to %s", destImage)
 	}
 
-	// Fetch image descriptor from source
-	if showProgress {
-		fmt.Println("Fetching image from source registry...")
+	return nil
+}
+
+// copyReference streams the image or image index at srcRef to dstRef using the
+// given keychains. It is shared by every command that copies between registries.
+func copyReference(ctx context.Context, srcRef, dstRef name.Reference, sourceKC, destKC authn.Keychain, showProgress bool, opts CopyOptions) error {
+	sourceImage := srcRef.String()
+	destImage := dstRef.String()
+
+	// Referrers point at the source digest, which a changed image no longer has
+	if opts.WithReferrers && opts.mutatesImage() {
+		return fmt.Errorf("referrers cannot be copied when labels, annotations or env change the image digest")
 	}
 
-	desc, err := remote.Get(srcRef, remote.WithAuthFromKeychain(sourceKC))
+	// Fetch image descriptor from source
+	logStatusf(showProgress, "Fetching image from source registry...")
+
+	desc, err := remote.Get(srcRef, remoteOptions(ctx, sourceKC)...)
 	if err != nil {
 		return HandleRegistryError(err, "fetching image from source", sourceImage)
 	}
 
-	// Check if it's an image index (multi-arch) or regular image
-	if showProgress {
-		fmt.Println("Analyzing image type...")
+	if opts.Verify.Enabled() {
+		logStatusf(showProgress, "Verifying source image signature...")
+		if err := VerifyImageSignature(ctx, srcRef.Context().Digest(desc.Digest.String()), sourceKC, opts.Verify); err != nil {
+			return err
+		}
 	}
 
-	// Create progress channel if needed
-	var updates chan v1.Update
-	if showProgress {
-		updates = make(chan v1.Update, 100)
-		go printProgress(updates)
-		// Note: remote.Write/WriteIndex will close the channel
+	// Resolve what will be written, applying any platform selection
+	artifact, err := resolveArtifact(desc, opts)
+	if err != nil {
+		return err
+	}
+	if opts.mutatesImage() {
+		artifact, err = mutateArtifact(artifact, opts)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Try to get as image
-	img, err := desc.Image()
-	if err == nil {
-		// It's a regular image
-		if showProgress {
-			fmt.Println("Copying image layers to destination registry...")
-			err = remote.Write(dstRef, img,
-				remote.WithAuthFromKeychain(destKC),
-				remote.WithProgress(updates))
-		} else {
-			err = remote.Write(dstRef, img, remote.WithAuthFromKeychain(destKC))
+	clobber, err := newClobberPolicy(opts.NoClobber, opts.NoClobberPattern)
+	if err != nil {
+		return err
+	}
+	if clobber.protects(dstRef) {
+		digest, err := artifact.(interface{ Digest() (v1.Hash, error) }).Digest()
+		if err != nil {
+			return fmt.Errorf("failed to compute manifest digest: %w", err)
+		}
+		if err := clobber.check(ctx, dstRef, digest, destKC); err != nil {
+			return err
+		}
+	}
+
+	// Mounting beats any local cache, so the cache only serves cross-registry copies
+	mount := sameRegistry(srcRef.Context(), dstRef.Context())
+
+	if opts.DryRun {
+		var mountFrom *name.Repository
+		if mount {
+			src := srcRef.Context()
+			mountFrom = &src
+		}
+		plan, err := planTransfer(ctx, artifact, dstRef, destKC, mountFrom, opts.CheckPush)
+		if err != nil {
+			return err
 		}
+		logPlan(plan)
+		if opts.WithReferrers {
+			logInfof("Referrers are not checked in a dry run")
+		}
+		return nil
+	}
+
+	if !mount && opts.CacheDir != "" {
+		artifact = cachedArtifact(artifact, opts.CacheDir)
+	}
+
+	writeCtx, artifact, stopProgress := trackProgress(ctx, progressUpload, artifact, showProgress)
 
+	switch a := artifact.(type) {
+	case v1.ImageIndex:
+		logStatusf(showProgress, "Copying image index (multi-arch) to destination registry...")
+		err = remote.WriteIndex(dstRef, a, remoteOptions(writeCtx, destKC)...)
+		if err == nil {
+			progressManifestWritten(writeCtx, destImage, a)
+		}
+		stopProgress()
+		if err != nil {
+			return HandleRegistryError(err, "writing image index to destination", destImage)
+		}
+	case v1.Image:
+		logStatusf(showProgress, "Copying image layers to destination registry...")
+		err = remote.Write(dstRef, a, remoteOptions(writeCtx, destKC)...)
+		if err == nil {
+			progressManifestWritten(writeCtx, destImage, a)
+		}
+		stopProgress()
 		if err != nil {
 			return HandleRegistryError(err, "writing image to destination", destImage)
 		}
-	} else {
-		// Try as image index (multi-arch)
+	}
+
+	if opts.WithReferrers {
+		logStatusf(showProgress, "Copying referrers (signatures, SBOMs, attestations)...")
+
+		count, err := copyReferrers(ctx, srcRef.Context(), dstRef.Context(), artifact, sourceKC, destKC)
+		if err != nil {
+			return err
+		}
+		if err := warnSourceIndexReferrers(ctx, srcRef.Context(), desc.Digest, artifact, sourceKC); err != nil {
+			return err
+		}
+
+		logStatusf(showProgress, "Copied %d referrers", count)
+	}
+
+	return nil
+}
+
+// resolveArtifact returns the image or image index that should be written for desc.
+// Without platform options this is the source as-is; --platforms filters an index
+// down to the requested manifests and --platform flattens it to a single image.
+func resolveArtifact(desc *remote.Descriptor, opts CopyOptions) (remote.Taggable, error) {
+	if opts.Platform != "" && len(opts.Platforms) > 0 {
+		return nil, fmt.Errorf("--platform and --platforms cannot be used together")
+	}
+
+	// Image indexes must be checked first: desc.Image() would silently resolve an
+	// index to a single platform image
+	if desc.MediaType.IsIndex() {
 		idx, err := desc.ImageIndex()
 		if err != nil {
-			return fmt.Errorf("failed to process image (not a valid image or image index): %w", err)
+			return nil, fmt.Errorf("failed to process image index: %w", err)
 		}
 
-		if showProgress {
-			fmt.Println("Copying image index (multi-arch) to destination registry...")
-			err = remote.WriteIndex(dstRef, idx,
-				remote.WithAuthFromKeychain(destKC),
-				remote.WithProgress(updates))
-		} else {
-			err = remote.WriteIndex(dstRef, idx, remote.WithAuthFromKeychain(destKC))
+		switch {
+		case opts.Platform != "":
+			platform, err := v1.ParsePlatform(opts.Platform)
+			if err != nil {
+				return nil, fmt.Errorf("invalid platform '%s': %w", opts.Platform, err)
+			}
+			return selectPlatformImage(idx, *platform)
+		case len(opts.Platforms) > 0:
+			platforms, err := parsePlatforms(opts.Platforms)
+			if err != nil {
+				return nil, err
+			}
+			return filterIndex(idx, platforms)
 		}
+		return idx, nil
+	}
+
+	img, err := desc.Image()
+	if err != nil {
+		return nil, fmt.Errorf("failed to process image (not a valid image or image index): %w", err)
+	}
 
+	// A single-platform source must already be one of the requested platforms
+	var wanted []string
+	if opts.Platform != "" {
+		wanted = []string{opts.Platform}
+	} else {
+		wanted = opts.Platforms
+	}
+	if len(wanted) > 0 {
+		platforms, err := parsePlatforms(wanted)
 		if err != nil {
-			return HandleRegistryError(err, "writing image index to destination", destImage)
+			return nil, err
+		}
+		if err := checkImagePlatform(img, platforms); err != nil {
+			return nil, err
 		}
 	}
 
-	if showProgress {
-		fmt.Printf("\n✓ Successfully copied image to %s\n", destImage)
+	return img, nil
+}
+
+// sameRegistry reports whether src and dst are on one registry. Blobs read from
+// the source are then mounted into the destination by remote.Write, which tries a
+// cross-repository mount for every *remote.MountableLayer and uploads the blob
+// when the registry refuses.
+func sameRegistry(src, dst name.Repository) bool {
+	return src.RegistryStr() == dst.RegistryStr()
+}
+
+// artifactDescriptors returns the unique distributable layers, and the configs when
+// withConfig is set, of an image or of every image in an index
+func artifactDescriptors(artifact remote.Taggable, withConfig bool) ([]v1.Descriptor, error) {
+	seen := map[v1.Hash]bool{}
+	var blobs []v1.Descriptor
+	add := func(img v1.Image) error {
+		manifest, err := img.Manifest()
+		if err != nil {
+			return fmt.Errorf("failed to read image manifest: %w", err)
+		}
+		candidates := manifest.Layers
+		if withConfig {
+			candidates = append([]v1.Descriptor{manifest.Config}, candidates...)
+		}
+		for _, d := range candidates {
+			if d.MediaType.IsDistributable() && !seen[d.Digest] {
+				seen[d.Digest] = true
+				blobs = append(blobs, d)
+			}
+		}
+		return nil
 	}
 
-	return nil
+	var err error
+	switch a := artifact.(type) {
+	case v1.ImageIndex:
+		err = walkIndexImages(a, add)
+	case v1.Image:
+		err = add(a)
+	default:
+		err = fmt.Errorf("unsupported artifact type %T", artifact)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blobs, nil
 }
 
-// printProgress reads progress updates from a channel and prints them
-func printProgress(updates <-chan v1.Update) {
-	for update := range updates {
-		if update.Total > 0 {
-			percent := float64(update.Complete) / float64(update.Total) * 100
-			fmt.Printf("\rProgress: %d/%d bytes (%.1f%%)", update.Complete, update.Total, percent)
+// walkIndexImages calls fn for every image in idx, descending into nested indexes
+func walkIndexImages(idx v1.ImageIndex, fn func(v1.Image) error) error {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("failed to read image index manifest: %w", err)
+	}
+
+	for _, child := range manifest.Manifests {
+		switch {
+		case child.MediaType.IsIndex():
+			nested, err := idx.ImageIndex(child.Digest)
+			if err != nil {
+				return fmt.Errorf("failed to read nested index %s: %w", child.Digest, err)
+			}
+			if err := walkIndexImages(nested, fn); err != nil {
+				return err
+			}
+		case child.MediaType.IsImage():
+			img, err := idx.Image(child.Digest)
+			if err != nil {
+				return fmt.Errorf("failed to read image %s: %w", child.Digest, err)
+			}
+			if err := fn(img); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
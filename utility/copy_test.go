@@ -1,7 +1,18 @@
 package utility
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
 )
 
 // TestCopyImageValidation tests basic validation of CopyImage parameters
@@ -79,6 +90,7 @@ func TestCopyImageValidation(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			err := CopyImage(
+				context.Background(),
 				tt.sourceImage,
 				tt.destImage,
 				tt.sourceSecret,
@@ -86,6 +98,7 @@ func TestCopyImageValidation(t *testing.T) {
 				tt.sourceNamespace,
 				tt.destNamespace,
 				tt.showProgress,
+				CopyOptions{},
 			)
 
 			if tt.wantErr {
@@ -138,6 +151,7 @@ func TestCopyImageReferenceValidation(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// We'll test by trying to copy with this reference
 			err := CopyImage(
+				context.Background(),
 				tt.imageRef,
 				"destination:latest",
 				"test-secret",
@@ -145,6 +159,7 @@ func TestCopyImageReferenceValidation(t *testing.T) {
 				"default",
 				"default",
 				false,
+				CopyOptions{},
 			)
 
 			if tt.expectErr && err == nil {
@@ -157,3 +172,110 @@ func TestCopyImageReferenceValidation(t *testing.T) {
 		})
 	}
 }
+
+// TestCopyImageContext tests that cancelled and expired contexts stop the copy
+func TestCopyImageContext(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/staging/app:v1")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		errContains string
+	}{
+		{name: "cancelled", ctx: cancelled, errContains: "operation cancelled"},
+		{name: "deadline exceeded", ctx: expired, errContains: "timed out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CopyImage(tt.ctx, reg+"/staging/app:v1", reg+"/prod/app:v1", "", "", "default", "default", false, CopyOptions{})
+			if err == nil {
+				t.Fatal("CopyImage() succeeded with a done context")
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("CopyImage() error = %v, want error containing %q", err, tt.errContains)
+			}
+			if !errors.Is(err, tt.ctx.Err()) {
+				t.Errorf("CopyImage() error = %v, want it to wrap %v", err, tt.ctx.Err())
+			}
+		})
+	}
+}
+
+// TestSameRegistry tests which copies remote.Write can mount blobs for
+func TestSameRegistry(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		dst  string
+		want bool
+	}{
+		{name: "different repositories on one registry", src: "myregistry.io/staging/app", dst: "myregistry.io/prod/app", want: true},
+		{name: "same repository", src: "myregistry.io/app", dst: "myregistry.io/app", want: true},
+		{name: "different registries", src: "gcr.io/project/app", dst: "myregistry.io/project/app", want: false},
+		{name: "docker hub short names", src: "nginx", dst: "linuxarpan/nginx", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, _ := name.NewRepository(tt.src)
+			dst, _ := name.NewRepository(tt.dst)
+			if got := sameRegistry(src, dst); got != tt.want {
+				t.Errorf("sameRegistry(%s, %s) = %v, want %v", tt.src, tt.dst, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCopyImageMountsBlobs tests that a copy between repositories of one registry
+// mounts the config and layers, and uploads them when the registry refuses
+func TestCopyImageMountsBlobs(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowMount bool
+		progress   bool
+	}{
+		{name: "registry supports mounting", allowMount: true},
+		{name: "registry supports mounting with progress", allowMount: true, progress: true},
+		{name: "registry refuses mounting", allowMount: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useProgressLog(t, slog.LevelInfo)
+			reg := &mountRegistry{
+				handler:    registry.New(registry.Logger(log.New(io.Discard, "", 0))),
+				allowMount: tt.allowMount,
+				mounted:    map[string]bool{},
+				uploaded:   map[string]bool{},
+			}
+			server := httptest.NewServer(reg)
+			defer server.Close()
+			host := strings.TrimPrefix(server.URL, "http://")
+
+			img := pushRandomImage(t, host+"/staging/app:v1")
+			if err := CopyImage(context.Background(), host+"/staging/app:v1", host+"/prod/app:v1", "", "", "default", "default", tt.progress, CopyOptions{}); err != nil {
+				t.Fatalf("CopyImage() failed: %v", err)
+			}
+
+			manifest, _ := img.Manifest()
+			for _, d := range append(manifest.Layers, manifest.Config) {
+				if reg.mounted[d.Digest.String()] != tt.allowMount {
+					t.Errorf("Blob %s mounted = %v, want %v", d.Digest, reg.mounted[d.Digest.String()], tt.allowMount)
+				}
+				if reg.uploaded[d.Digest.String()] == tt.allowMount {
+					t.Errorf("Blob %s uploaded = %v, want %v", d.Digest, reg.uploaded[d.Digest.String()], !tt.allowMount)
+				}
+			}
+			if reg.openUploads != 0 {
+				t.Errorf("%d upload sessions were left open", reg.openUploads)
+			}
+		})
+	}
+}
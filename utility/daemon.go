@@ -0,0 +1,96 @@
+package utility
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/daemon"
+)
+
+// daemonClient overrides the Docker API client used to reach the local daemon.
+// When nil, the client is configured from the environment (DOCKER_HOST and
+// friends), which also covers Podman's Docker-compatible socket.
+var daemonClient daemon.Client
+
+// daemonOptions returns the options shared by every daemon operation
+func daemonOptions(ctx context.Context, extra ...daemon.Option) []daemon.Option {
+	opts := []daemon.Option{daemon.WithContext(ctx)}
+	if daemonClient != nil {
+		opts = append(opts, daemon.WithClient(daemonClient))
+	}
+	return append(opts, extra...)
+}
+
+// loadDaemonImage returns an image stored in the local Docker or Podman daemon. The
+// image is streamed from the daemon each time its contents are read rather than
+// buffered in memory, so large images do not need to fit in RAM.
+func loadDaemonImage(ctx context.Context, imageRef string) (v1.Image, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse daemon image reference '%s': %w", imageRef, err)
+	}
+
+	img, err := daemon.Image(ref, daemonOptions(ctx, daemon.WithUnbufferedOpener())...)
+	if err != nil {
+		return nil, daemonError(err, "reading image", imageRef)
+	}
+	return img, nil
+}
+
+// writeDaemonImage loads img into the local daemon and tags it. If the daemon already
+// has an image with the same ID, only the tag is added.
+func writeDaemonImage(ctx context.Context, tag name.Tag, img v1.Image) error {
+	resp, err := daemon.Write(tag, img, daemonOptions(ctx)...)
+	if err == nil {
+		err = daemonLoadError(resp)
+	}
+	if err != nil {
+		return daemonError(err, "loading image", tag.String())
+	}
+	return nil
+}
+
+// daemonLoadError returns the error reported in a "docker load" JSON message stream.
+// The daemon answers 200 and reports load failures in the body, which
+// daemon.Write returns without inspecting.
+func daemonLoadError(resp string) error {
+	dec := json.NewDecoder(strings.NewReader(resp))
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			// End of stream, or a plain-text response
+			return nil
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// daemonTag returns the tag an image pulled as ref is loaded under. Images pulled by
+// digest have no tag, so they are tagged "sha256-<hex>" after the digest.
+func daemonTag(ref name.Reference, digest v1.Hash) name.Tag {
+	if tag, ok := ref.(name.Tag); ok {
+		return tag
+	}
+	return ref.Context().Tag(digest.Algorithm + "-" + digest.Hex)
+}
+
+// daemonPlatform returns the platform of images the local daemon runs. Containers run
+// Linux images even on macOS and Windows hosts, on the host's CPU architecture.
+func daemonPlatform() v1.Platform {
+	return v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// daemonError adds a hint about reaching the daemon to errors from daemon operations
+func daemonError(err error, operation string, target string) error {
+	return fmt.Errorf("failed %s '%s' with the local daemon (is Docker running? for Podman, set DOCKER_HOST to its socket): %w", operation, target, err)
+}
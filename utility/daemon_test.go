@@ -0,0 +1,253 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	api "github.com/docker/docker/api/types/image"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// fakeDaemon is an in-memory stand-in for the Docker API, storing images as
+// "docker save" tarballs keyed by image ID
+type fakeDaemon struct {
+	mu     sync.Mutex
+	images map[string][]byte // image ID -> tarball
+	tags   map[string]string // tag -> image ID
+	loads  int
+	// loadError is reported in the load response body, as dockerd does
+	loadError string
+}
+
+func newFakeDaemon(t *testing.T) *fakeDaemon {
+	t.Helper()
+
+	d := &fakeDaemon{images: map[string][]byte{}, tags: map[string]string{}}
+	daemonClient = d
+	t.Cleanup(func() { daemonClient = nil })
+	return d
+}
+
+// add stores img in the daemon under tag
+func (d *fakeDaemon) add(t *testing.T, tag string, img v1.Image) {
+	t.Helper()
+
+	ref, err := name.NewTag(tag)
+	if err != nil {
+		t.Fatalf("Failed to parse tag: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tarball.Write(ref, img, &buf); err != nil {
+		t.Fatalf("Failed to save image: %v", err)
+	}
+	id, _ := img.ConfigName()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.images[id.String()] = buf.Bytes()
+	d.tags[ref.Name()] = id.String()
+}
+
+// resolve returns the image ID for a tag or ID
+func (d *fakeDaemon) resolve(ref string) (string, bool) {
+	if _, ok := d.images[ref]; ok {
+		return ref, true
+	}
+	if t, err := name.NewTag(ref); err == nil {
+		ref = t.Name()
+	}
+	id, ok := d.tags[ref]
+	return id, ok
+}
+
+func (d *fakeDaemon) NegotiateAPIVersion(context.Context) {}
+
+func (d *fakeDaemon) ImageSave(_ context.Context, refs []string) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id, ok := d.resolve(refs[0])
+	if !ok {
+		return nil, fmt.Errorf("No such image: %s", refs[0])
+	}
+	return io.NopCloser(bytes.NewReader(d.images[id])), nil
+}
+
+func (d *fakeDaemon) ImageLoad(_ context.Context, r io.Reader, _ bool) (api.LoadResponse, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return api.LoadResponse{}, err
+	}
+	if d.loadError != "" {
+		body := fmt.Sprintf(`{"errorDetail":{"message":%q},"error":%q}`, d.loadError, d.loadError)
+		return api.LoadResponse{Body: io.NopCloser(strings.NewReader(body)), JSON: true}, nil
+	}
+	opener := func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	manifest, err := tarball.LoadManifest(opener)
+	if err != nil {
+		return api.LoadResponse{}, err
+	}
+	img, err := tarball.Image(opener, nil)
+	if err != nil {
+		return api.LoadResponse{}, err
+	}
+	id, _ := img.ConfigName()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loads++
+	d.images[id.String()] = data
+	for _, tag := range manifest[0].RepoTags {
+		if t, err := name.NewTag(tag); err == nil {
+			tag = t.Name()
+		}
+		d.tags[tag] = id.String()
+	}
+	return api.LoadResponse{Body: io.NopCloser(strings.NewReader("Loaded image " + id.String()))}, nil
+}
+
+func (d *fakeDaemon) ImageTag(_ context.Context, src, dst string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id, ok := d.resolve(src)
+	if !ok {
+		return fmt.Errorf("No such image: %s", src)
+	}
+	if t, err := name.NewTag(dst); err == nil {
+		dst = t.Name()
+	}
+	d.tags[dst] = id
+	return nil
+}
+
+func (d *fakeDaemon) ImageInspectWithRaw(_ context.Context, ref string) (types.ImageInspect, []byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id, ok := d.resolve(ref)
+	if !ok {
+		return types.ImageInspect{}, nil, fmt.Errorf("No such image: %s", ref)
+	}
+	var tags []string
+	for tag, tagID := range d.tags {
+		if tagID == id {
+			tags = append(tags, tag)
+		}
+	}
+	return types.ImageInspect{ID: id, RepoTags: tags}, nil, nil
+}
+
+func (d *fakeDaemon) ImageHistory(context.Context, string) ([]api.HistoryResponseItem, error) {
+	return nil, nil
+}
+
+// TestPushImageFromDaemon tests pushing an image straight from the local daemon
+func TestPushImageFromDaemon(t *testing.T) {
+	reg := newTestRegistry(t)
+	d := newFakeDaemon(t)
+	img := pushRandomImage(t, reg+"/unused:v1")
+	d.add(t, "local/app:dev", img)
+
+	tests := []struct {
+		name       string
+		fromDaemon string
+		sourcePath string
+		wantErr    string
+	}{
+		{name: "image in daemon", fromDaemon: "local/app:dev"},
+		{name: "image missing from daemon", fromDaemon: "local/app:missing", wantErr: "local daemon"},
+		{name: "both sources", fromDaemon: "local/app:dev", sourcePath: "image.tar", wantErr: "exactly one"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := PushImage(context.Background(), reg+"/app:v1", tt.sourcePath, "", "default", PushOptions{FromDaemon: tt.fromDaemon})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("PushImage() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PushImage() failed: %v", err)
+			}
+
+			pushed, err := remoteImage(t, reg+"/app:v1").ConfigName()
+			if err != nil {
+				t.Fatalf("Failed to read pushed image: %v", err)
+			}
+			if want, _ := img.ConfigName(); pushed != want {
+				t.Errorf("Pushed config = %s, want %s", pushed, want)
+			}
+		})
+	}
+}
+
+// TestPullImageToDaemon tests loading pulled images into the local daemon
+func TestPullImageToDaemon(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	digest, _ := img.Digest()
+	configName, _ := img.ConfigName()
+
+	tests := []struct {
+		name      string
+		imageRef  string
+		wantTag   string
+		loadError string
+		wantLoads int
+		wantErr   string
+	}{
+		{name: "tagged image", imageRef: reg + "/app:v1", wantTag: reg + "/app:v1", wantLoads: 1},
+		{name: "image pulled by digest", imageRef: reg + "/app@" + digest.String(), wantTag: reg + "/app:sha256-" + digest.Hex, wantLoads: 1},
+		{name: "daemon rejects the image", imageRef: reg + "/app:v1", loadError: "no space left on device", wantErr: "no space left on device"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newFakeDaemon(t)
+			d.loadError = tt.loadError
+
+			err := PullImage(context.Background(), tt.imageRef, "", "", "default", PullOptions{ToDaemon: true})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("PullImage() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PullImage() failed: %v", err)
+			}
+
+			if d.loads != tt.wantLoads {
+				t.Errorf("Daemon loaded %d images, want %d", d.loads, tt.wantLoads)
+			}
+			tag, _ := name.NewTag(tt.wantTag)
+			if id, ok := d.resolve(tag.Name()); !ok || id != configName.String() {
+				t.Errorf("Daemon tag %s = %q, want image %s", tt.wantTag, id, configName)
+			}
+
+			// Pulling again only re-tags the image the daemon already has
+			if err := PullImage(context.Background(), tt.imageRef, "", "", "default", PullOptions{ToDaemon: true}); err != nil {
+				t.Fatalf("Second PullImage() failed: %v", err)
+			}
+			if d.loads != tt.wantLoads {
+				t.Errorf("Daemon loaded %d images after second pull, want %d", d.loads, tt.wantLoads)
+			}
+		})
+	}
+}
+
+// TestPullImageRequiresDestination tests that a pull needs somewhere to put the image
+func TestPullImageRequiresDestination(t *testing.T) {
+	err := PullImage(context.Background(), "nginx:latest", "", "", "default", PullOptions{})
+	if err == nil || !strings.Contains(err.Error(), "output path") {
+		t.Errorf("PullImage() error = %v, want missing destination error", err)
+	}
+}
@@ -0,0 +1,497 @@
+package utility
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Kinds of difference between two images
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+	DiffShared  = "shared"
+)
+
+// DiffOptions holds optional behaviour for DiffImages.
+type DiffOptions struct {
+	// Platform selects the image to compare when a reference is a multi-arch index
+	Platform string
+	// Files also compares the file systems of the two images, which downloads and
+	// unpacks every layer of both
+	Files bool
+}
+
+// Change is a difference in one named value, such as a config field, environment
+// variable, label or file. A and B are empty when the value is absent on that side.
+type Change struct {
+	Kind string
+	Name string
+	A    string
+	B    string
+}
+
+// LayerChange is a layer present in one or both images
+type LayerChange struct {
+	Kind      string
+	Digest    v1.Hash
+	Size      int64
+	CreatedBy string
+}
+
+// ImageDiff describes what differs between two images
+type ImageDiff struct {
+	RefA, RefB string
+	// DigestA and DigestB are the digests the references resolve to, which for a
+	// multi-arch index is the digest of the index
+	DigestA, DigestB v1.Hash
+	// ImageA and ImageB are the digests of the compared platform images
+	ImageA, ImageB         v1.Hash
+	MediaTypeA, MediaTypeB types.MediaType
+	Config                 []Change
+	Env                    []Change
+	Labels                 []Change
+	Layers                 []LayerChange
+	// Files is only set when DiffOptions.Files is
+	Files []Change
+}
+
+// Identical reports whether both references point to the same image
+func (d *ImageDiff) Identical() bool {
+	return d.ImageA == d.ImageB
+}
+
+// DiffImages compares the manifests, configs, layers and optionally the files of
+// two images, for example two tags of the same repository listed by ListImage.
+func DiffImages(ctx context.Context, refA string, refB string, secretName string, namespace string, opts DiffOptions) (*ImageDiff, error) {
+	// Create keychain
+	kc, err := CreateKeychain(ctx, namespace, secretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keychain: %w", err)
+	}
+
+	var extra []remote.Option
+	if opts.Platform != "" {
+		platform, err := v1.ParsePlatform(opts.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform '%s': %w", opts.Platform, err)
+		}
+		extra = append(extra, remote.WithPlatform(*platform))
+	}
+
+	d := &ImageDiff{RefA: refA, RefB: refB}
+	imgA, err := fetchDiffImage(ctx, refA, kc, extra, &d.DigestA, &d.ImageA)
+	if err != nil {
+		return nil, err
+	}
+	imgB, err := fetchDiffImage(ctx, refB, kc, extra, &d.DigestB, &d.ImageB)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.MediaTypeA, err = imgA.MediaType(); err != nil {
+		return nil, fmt.Errorf("failed to read media type of %s: %w", refA, err)
+	}
+	if d.MediaTypeB, err = imgB.MediaType(); err != nil {
+		return nil, fmt.Errorf("failed to read media type of %s: %w", refB, err)
+	}
+
+	configA, err := imgA.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config of %s: %w", refA, err)
+	}
+	configB, err := imgB.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config of %s: %w", refB, err)
+	}
+	d.Config = diffConfig(configA, configB)
+	d.Env = diffMaps(envMap(configA.Config.Env), envMap(configB.Config.Env))
+	d.Labels = diffMaps(configA.Config.Labels, configB.Config.Labels)
+
+	if d.Layers, err = diffLayers(imgA, imgB); err != nil {
+		return nil, err
+	}
+
+	if opts.Files && !d.Identical() {
+		logInfof("Comparing files (downloading both images)...")
+		filesA, err := imageFiles(imgA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read files of %s: %w", refA, err)
+		}
+		filesB, err := imageFiles(imgB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read files of %s: %w", refB, err)
+		}
+		d.Files = diffFiles(filesA, filesB)
+	}
+	return d, nil
+}
+
+// fetchDiffImage resolves ref to an image, recording the digest of the reference
+// and of the selected platform image
+func fetchDiffImage(ctx context.Context, imageRef string, kc authn.Keychain, extra []remote.Option, refDigest, imgDigest *v1.Hash) (v1.Image, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference '%s': %w", imageRef, err)
+	}
+	desc, err := remote.Get(ref, remoteOptions(ctx, kc, extra...)...)
+	if err != nil {
+		return nil, HandleRegistryError(err, "fetching image", imageRef)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, fmt.Errorf("failed to process image '%s': %w", imageRef, err)
+	}
+	*refDigest = desc.Digest
+	if *imgDigest, err = img.Digest(); err != nil {
+		return nil, fmt.Errorf("failed to compute digest of '%s': %w", imageRef, err)
+	}
+	return img, nil
+}
+
+// diffConfig compares the runtime settings and platform of two configs
+func diffConfig(a, b *v1.ConfigFile) []Change {
+	fields := []struct {
+		name string
+		a, b string
+	}{
+		{"Platform", formatPlatform(a), formatPlatform(b)},
+		{"Entrypoint", formatArgs(a.Config.Entrypoint), formatArgs(b.Config.Entrypoint)},
+		{"Cmd", formatArgs(a.Config.Cmd), formatArgs(b.Config.Cmd)},
+		{"WorkingDir", a.Config.WorkingDir, b.Config.WorkingDir},
+		{"User", a.Config.User, b.Config.User},
+		{"ExposedPorts", formatSet(a.Config.ExposedPorts), formatSet(b.Config.ExposedPorts)},
+		{"Volumes", formatSet(a.Config.Volumes), formatSet(b.Config.Volumes)},
+		{"StopSignal", a.Config.StopSignal, b.Config.StopSignal},
+		{"Created", formatCreated(a), formatCreated(b)},
+	}
+
+	var changes []Change
+	for _, f := range fields {
+		if f.a != f.b {
+			changes = append(changes, Change{Kind: changeKind(f.a, f.b), Name: f.name, A: f.a, B: f.b})
+		}
+	}
+	return changes
+}
+
+// changeKind classifies a change between two values, where "" means unset
+func changeKind(a, b string) string {
+	switch {
+	case a == "":
+		return DiffAdded
+	case b == "":
+		return DiffRemoved
+	default:
+		return DiffChanged
+	}
+}
+
+// formatArgs formats an entrypoint or cmd the way a Dockerfile's exec form does
+func formatArgs(args []string) string {
+	if args == nil {
+		return ""
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = fmt.Sprintf("%q", arg)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// formatSet formats the keys of a set, such as exposed ports, in sorted order
+func formatSet(set map[string]struct{}) string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
+
+// formatPlatform formats the platform of a config, if it records one
+func formatPlatform(config *v1.ConfigFile) string {
+	if platform := config.Platform(); platform != nil {
+		return platform.String()
+	}
+	return ""
+}
+
+// formatCreated formats the creation time of a config, if it has one
+func formatCreated(config *v1.ConfigFile) string {
+	if config.Created.IsZero() {
+		return ""
+	}
+	return config.Created.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// envMap turns KEY=VALUE environment entries into a map
+func envMap(env []string) map[string]string {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		vars[key] = value
+	}
+	return vars
+}
+
+// diffMaps returns the keys added, removed or changed between a and b, sorted by key
+func diffMaps(a, b map[string]string) []Change {
+	var changes []Change
+	for key, va := range a {
+		vb, ok := b[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: DiffRemoved, Name: key, A: va})
+		case va != vb:
+			changes = append(changes, Change{Kind: DiffChanged, Name: key, A: va, B: vb})
+		}
+	}
+	for key, vb := range b {
+		if _, ok := a[key]; !ok {
+			changes = append(changes, Change{Kind: DiffAdded, Name: key, B: vb})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// diffLayers lists the layers of both images: shared layers in the order of a,
+// followed by those only in a and those only in b
+func diffLayers(a, b v1.Image) ([]LayerChange, error) {
+	layersA, err := describeLayers(a)
+	if err != nil {
+		return nil, err
+	}
+	layersB, err := describeLayers(b)
+	if err != nil {
+		return nil, err
+	}
+
+	inA := make(map[v1.Hash]bool, len(layersA))
+	for _, l := range layersA {
+		inA[l.Digest] = true
+	}
+	inB := make(map[v1.Hash]bool, len(layersB))
+	for _, l := range layersB {
+		inB[l.Digest] = true
+	}
+
+	var shared, removed, added []LayerChange
+	for _, l := range layersA {
+		if inB[l.Digest] {
+			l.Kind = DiffShared
+			shared = append(shared, l)
+		} else {
+			l.Kind = DiffRemoved
+			removed = append(removed, l)
+		}
+	}
+	for _, l := range layersB {
+		if !inA[l.Digest] {
+			l.Kind = DiffAdded
+			added = append(added, l)
+		}
+	}
+	return append(append(shared, removed...), added...), nil
+}
+
+// describeLayers returns the digest, size and creating instruction of each layer
+func describeLayers(img v1.Image) ([]LayerChange, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	// History entries that created a layer line up with the layers
+	var createdBy []string
+	for _, h := range config.History {
+		if !h.EmptyLayer {
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+	}
+	if len(createdBy) != len(manifest.Layers) {
+		createdBy = nil
+	}
+
+	layers := make([]LayerChange, 0, len(manifest.Layers))
+	for i, desc := range manifest.Layers {
+		l := LayerChange{Digest: desc.Digest, Size: desc.Size}
+		if createdBy != nil {
+			l.CreatedBy = createdBy[i]
+		}
+		layers = append(layers, l)
+	}
+	return layers, nil
+}
+
+// fileInfo is what a file comparison looks at; modification times are ignored
+type fileInfo struct {
+	Type   byte
+	Mode   int64
+	Size   int64
+	Link   string
+	Digest string
+}
+
+// imageFiles returns the files of the flattened file system of img by path.
+// Directories are left out, since their contents are compared file by file.
+func imageFiles(img v1.Image) (map[string]fileInfo, error) {
+	rc := mutate.Extract(img)
+	defer rc.Close()
+
+	files := map[string]fileInfo{}
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		info := fileInfo{Type: header.Typeflag, Mode: header.Mode, Size: header.Size, Link: header.Linkname}
+		if header.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, err
+			}
+			info.Digest = hex.EncodeToString(h.Sum(nil))
+		}
+		files[path.Clean("/"+header.Name)] = info
+	}
+}
+
+// diffFiles returns the files added, removed or changed between a and b
+func diffFiles(a, b map[string]fileInfo) []Change {
+	var changes []Change
+	for p, fa := range a {
+		fb, ok := b[p]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: DiffRemoved, Name: p, A: describeFile(fa)})
+		case fa != fb:
+			changes = append(changes, Change{Kind: DiffChanged, Name: p, A: describeFile(fa), B: describeFile(fb)})
+		}
+	}
+	for p, fb := range b {
+		if _, ok := a[p]; !ok {
+			changes = append(changes, Change{Kind: DiffAdded, Name: p, B: describeFile(fb)})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// describeFile summarises a file for diff output
+func describeFile(f fileInfo) string {
+	switch f.Type {
+	case tar.TypeSymlink:
+		return "-> " + f.Link
+	case tar.TypeLink:
+		return "hard link to " + f.Link
+	case tar.TypeReg:
+		return fmt.Sprintf("%s, mode %04o", FormatBytes(f.Size), f.Mode&0o7777)
+	default:
+		return fmt.Sprintf("type %q, mode %04o", f.Type, f.Mode&0o7777)
+	}
+}
+
+// layerMarkers prefix each layer in diff output, like lines in a unified diff
+var layerMarkers = map[string]string{DiffShared: " ", DiffRemoved: "-", DiffAdded: "+"}
+
+// Write prints the diff in a human-readable form
+func (d *ImageDiff) Write(w io.Writer) {
+	fmt.Fprintf(w, "--- %s (%s)\n", d.RefA, d.DigestA)
+	fmt.Fprintf(w, "+++ %s (%s)\n", d.RefB, d.DigestB)
+	if d.ImageA != d.DigestA || d.ImageB != d.DigestB {
+		fmt.Fprintf(w, "Compared images: %s and %s\n", d.ImageA, d.ImageB)
+	}
+	if d.Identical() {
+		fmt.Fprintln(w, "Images are identical")
+		return
+	}
+	if d.MediaTypeA != d.MediaTypeB {
+		fmt.Fprintf(w, "\nManifest media type: %s -> %s\n", d.MediaTypeA, d.MediaTypeB)
+	}
+
+	writeChanges(w, "Config", d.Config)
+	writeChanges(w, "Env", d.Env)
+	writeChanges(w, "Labels", d.Labels)
+
+	var shared, removed, added int
+	var removedSize, addedSize int64
+	for _, l := range d.Layers {
+		switch l.Kind {
+		case DiffShared:
+			shared++
+		case DiffRemoved:
+			removed++
+			removedSize += l.Size
+		case DiffAdded:
+			added++
+			addedSize += l.Size
+		}
+	}
+	fmt.Fprintf(w, "\nLayers: %d shared, %d removed (%s), %d added (%s)\n", shared, removed, FormatBytes(removedSize), added, FormatBytes(addedSize))
+	for _, l := range d.Layers {
+		line := fmt.Sprintf("  %s %s  %9s", layerMarkers[l.Kind], l.Digest, FormatBytes(l.Size))
+		if l.CreatedBy != "" {
+			line += "  " + truncate(l.CreatedBy, 60)
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	if d.Files != nil {
+		writeChanges(w, "Files", d.Files)
+	}
+}
+
+// writeChanges prints a section of changes, or nothing when there are none
+func writeChanges(w io.Writer, title string, changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, c := range changes {
+		switch c.Kind {
+		case DiffAdded:
+			fmt.Fprintf(w, "  + %s: %s\n", c.Name, c.B)
+		case DiffRemoved:
+			fmt.Fprintf(w, "  - %s: %s\n", c.Name, c.A)
+		default:
+			fmt.Fprintf(w, "  ~ %s: %s -> %s\n", c.Name, c.A, c.B)
+		}
+	}
+}
+
+// truncate shortens s to at most n characters, marking the cut with "..."
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -0,0 +1,186 @@
+package utility
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// fileLayer returns a layer containing the given files
+func fileLayer(t *testing.T, files map[string]string) v1.Layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	return layer
+}
+
+// configuredImage returns base with layer added and the given runtime config
+func configuredImage(t *testing.T, base v1.Image, layer v1.Layer, cfg v1.Config) v1.Image {
+	t.Helper()
+
+	img, err := mutate.Append(base, mutate.Addendum{Layer: layer, History: v1.History{CreatedBy: "COPY app /app"}})
+	if err != nil {
+		t.Fatalf("Failed to append layer: %v", err)
+	}
+	img, err = mutate.Config(img, cfg)
+	if err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	return img
+}
+
+// TestDiffImages tests comparing two tags of an image
+func TestDiffImages(t *testing.T) {
+	reg := newTestRegistry(t)
+	base, err := random.Image(128, 1)
+	if err != nil {
+		t.Fatalf("Failed to create base image: %v", err)
+	}
+
+	pushTestImage(t, reg+"/app:v1", configuredImage(t, base,
+		fileLayer(t, map[string]string{"app/a.txt": "one", "app/gone.txt": "bye"}),
+		v1.Config{Env: []string{"PATH=/bin", "VERSION=1"}, Labels: map[string]string{"team": "core"}, Entrypoint: []string{"/app/run"}},
+	))
+	pushTestImage(t, reg+"/app:v2", configuredImage(t, base,
+		fileLayer(t, map[string]string{"app/a.txt": "two", "app/new.txt": "hi"}),
+		v1.Config{Env: []string{"PATH=/bin", "VERSION=2", "DEBUG=0"}, Labels: map[string]string{"team": "core", "tier": "web"}, Entrypoint: []string{"/app/run", "--v2"}, User: "1000"},
+	))
+
+	diff, err := DiffImages(context.Background(), reg+"/app:v1", reg+"/app:v2", "", "default", DiffOptions{Files: true})
+	if err != nil {
+		t.Fatalf("DiffImages() failed: %v", err)
+	}
+	if diff.Identical() {
+		t.Fatal("Identical() = true for different images")
+	}
+
+	checkChanges := func(section string, got []Change, want []Change) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s changes = %+v, want %+v", section, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s change %d = %+v, want %+v", section, i, got[i], want[i])
+			}
+		}
+	}
+	checkChanges("Env", diff.Env, []Change{
+		{Kind: DiffAdded, Name: "DEBUG", B: "0"},
+		{Kind: DiffChanged, Name: "VERSION", A: "1", B: "2"},
+	})
+	checkChanges("Labels", diff.Labels, []Change{{Kind: DiffAdded, Name: "tier", B: "web"}})
+	checkChanges("Config", diff.Config, []Change{
+		{Kind: DiffChanged, Name: "Entrypoint", A: `["/app/run"]`, B: `["/app/run", "--v2"]`},
+		{Kind: DiffAdded, Name: "User", B: "1000"},
+	})
+
+	var kinds []string
+	for _, l := range diff.Layers {
+		kinds = append(kinds, l.Kind)
+	}
+	if got := strings.Join(kinds, ","); got != "shared,removed,added" {
+		t.Errorf("Layer kinds = %s, want shared,removed,added", got)
+	}
+	if diff.Layers[2].CreatedBy != "COPY app /app" {
+		t.Errorf("Added layer CreatedBy = %q, want the history entry", diff.Layers[2].CreatedBy)
+	}
+
+	var files []string
+	for _, f := range diff.Files {
+		if strings.HasPrefix(f.Name, "/app/") {
+			files = append(files, f.Kind+" "+f.Name)
+		}
+	}
+	if got, want := strings.Join(files, ","), "changed /app/a.txt,removed /app/gone.txt,added /app/new.txt"; got != want {
+		t.Errorf("File changes = %s, want %s", got, want)
+	}
+
+	var out bytes.Buffer
+	diff.Write(&out)
+	for _, want := range []string{"--- " + reg + "/app:v1", "~ VERSION: 1 -> 2", "+ tier: web", "Layers: 1 shared, 1 removed", "+ /app/new.txt"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestDiffImagesIdentical tests comparing two tags of the same image
+func TestDiffImagesIdentical(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	pushTestImage(t, reg+"/app:latest", img)
+
+	diff, err := DiffImages(context.Background(), reg+"/app:v1", reg+"/app:latest", "", "default", DiffOptions{Files: true})
+	if err != nil {
+		t.Fatalf("DiffImages() failed: %v", err)
+	}
+	if !diff.Identical() || diff.Files != nil {
+		t.Errorf("Identical() = %v, Files = %v; want identical without a file comparison", diff.Identical(), diff.Files)
+	}
+
+	var out bytes.Buffer
+	diff.Write(&out)
+	if !strings.Contains(out.String(), "Images are identical") {
+		t.Errorf("Output = %q, want it to report identical images", out.String())
+	}
+}
+
+// TestDiffMaps tests comparing environment variables and labels
+func TestDiffMaps(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string]string
+		want []Change
+	}{
+		{name: "equal", a: map[string]string{"a": "1"}, b: map[string]string{"a": "1"}, want: nil},
+		{name: "both empty", a: nil, b: nil, want: nil},
+		{
+			name: "sorted by key",
+			a:    map[string]string{"c": "1", "a": "1"},
+			b:    map[string]string{"b": "2", "c": "2"},
+			want: []Change{
+				{Kind: DiffRemoved, Name: "a", A: "1"},
+				{Kind: DiffAdded, Name: "b", B: "2"},
+				{Kind: DiffChanged, Name: "c", A: "1", B: "2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffMaps(tt.a, tt.b)
+			if len(got) != len(tt.want) {
+				t.Fatalf("diffMaps() = %+v, want %+v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("diffMaps()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
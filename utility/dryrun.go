@@ -0,0 +1,277 @@
+package utility
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// What a dry run expects to happen to each blob
+const (
+	blobExisting = "exists"
+	blobMount    = "mount"
+	blobUpload   = "upload"
+)
+
+// plannedBlob is one blob of a transfer plan
+type plannedBlob struct {
+	Digest v1.Hash
+	Size   int64
+	Action string
+}
+
+// transferPlan describes what writing an image or index to a destination would do
+type transferPlan struct {
+	Destination name.Reference
+	// Digest is the manifest digest that would be written
+	Digest v1.Hash
+	// Current is the digest the destination points to now, if it exists
+	Current *v1.Hash
+	// PushAccess says how push access to the destination was established
+	PushAccess string
+	Blobs      []plannedBlob
+}
+
+// bytes returns the number and total size of the blobs planned for action
+func (p transferPlan) bytes(action string) (count int, size int64) {
+	for _, b := range p.Blobs {
+		if b.Action == action {
+			count++
+			size += b.Size
+		}
+	}
+	return count, size
+}
+
+// planTransfer works out what writing artifact to dstRef would do without writing
+// anything: it looks up the manifest the destination points to now, checks which
+// blobs are already there, and judges from the push-scoped token the registry
+// issues whether destKC may push. With checkPush, push access is instead confirmed
+// by starting and cancelling a blob upload, the only request that changes registry
+// state. When mountFrom is set, missing blobs are expected to be mounted from that
+// repository instead of uploaded.
+func planTransfer(ctx context.Context, artifact remote.Taggable, dstRef name.Reference, destKC authn.Keychain, mountFrom *name.Repository, checkPush bool) (transferPlan, error) {
+	plan := transferPlan{Destination: dstRef}
+
+	digest, err := artifact.(interface{ Digest() (v1.Hash, error) }).Digest()
+	if err != nil {
+		return plan, fmt.Errorf("failed to compute manifest digest: %w", err)
+	}
+	plan.Digest = digest
+
+	blobs, err := artifactDescriptors(artifact, true)
+	if err != nil {
+		return plan, err
+	}
+
+	dstRepo := dstRef.Context()
+	auth, err := destKC.Resolve(dstRepo)
+	if err != nil {
+		return plan, fmt.Errorf("failed to resolve destination credentials: %w", err)
+	}
+	scopes := []string{dstRepo.Scope(transport.PushScope)}
+	if mountFrom != nil {
+		scopes = append(scopes, mountFrom.Scope(transport.PullScope))
+	}
+	tokens := &tokenRecorder{inner: registryTransport, host: dstRepo.RegistryStr()}
+	rt, err := transport.NewWithContext(ctx, dstRepo.Registry, auth, tokens, scopes)
+	if err != nil {
+		return plan, HandleRegistryError(err, "authenticating to", dstRepo.String())
+	}
+	client := &http.Client{Transport: rt}
+
+	if checkPush {
+		if err := checkPushAccess(ctx, client, dstRepo); err != nil {
+			return plan, HandleRegistryError(err, "checking push access to", dstRepo.String())
+		}
+		plan.PushAccess = "ok (upload session opened and cancelled)"
+	}
+
+	desc, err := remote.Head(dstRef, remoteOptions(ctx, destKC)...)
+	var terr *transport.Error
+	switch {
+	case err == nil:
+		plan.Current = &desc.Digest
+	case errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound:
+		// The destination does not exist yet
+	default:
+		return plan, HandleRegistryError(err, "checking destination", dstRef.String())
+	}
+
+	for _, b := range blobs {
+		exists, err := blobExists(ctx, client, dstRepo, b.Digest)
+		if err != nil {
+			return plan, HandleRegistryError(err, "checking blobs at", dstRepo.String())
+		}
+		action := blobUpload
+		switch {
+		case exists:
+			action = blobExisting
+		case mountFrom != nil:
+			action = blobMount
+		}
+		plan.Blobs = append(plan.Blobs, plannedBlob{Digest: b.Digest, Size: b.Size, Action: action})
+	}
+
+	if !checkPush {
+		granted, known := tokenGrantsPush(tokens.token, dstRepo)
+		switch {
+		case !known:
+			plan.PushAccess = "not checked (the registry issued no scoped token; use --check-push)"
+		case !granted:
+			return plan, fmt.Errorf("no push access to %s: the registry token does not grant push", dstRepo)
+		default:
+			plan.PushAccess = "granted by the registry token"
+		}
+	}
+	return plan, nil
+}
+
+// tokenRecorder remembers the last bearer token sent to host
+type tokenRecorder struct {
+	inner http.RoundTripper
+	host  string
+	token string
+}
+
+func (r *tokenRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok && req.URL.Host == r.host {
+		r.token = token
+	}
+	return r.inner.RoundTrip(req)
+}
+
+// tokenGrantsPush reports whether a registry bearer token grants push to repo, from
+// the "access" claim of the Docker token specification. known is false when the
+// token is not a JWT with that claim, such as basic auth or an opaque token.
+func tokenGrantsPush(token string, repo name.Repository) (granted bool, known bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false, false
+	}
+	var claims struct {
+		Access *[]struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Access == nil {
+		return false, false
+	}
+	for _, a := range *claims.Access {
+		if a.Type == "repository" && a.Name == repo.RepositoryStr() && (slices.Contains(a.Actions, "push") || slices.Contains(a.Actions, "*")) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// checkPushAccess starts a blob upload in repo and cancels it again, for registries
+// whose tokens do not show whether a push would be allowed. It changes registry
+// state: the upload session is cancelled right away, but registries that ignore the
+// cancellation keep it until they expire stale uploads, and the attempt shows up in
+// audit logs.
+func checkPushAccess(ctx context.Context, client *http.Client, repo name.Repository) error {
+	u := url.URL{
+		Scheme: repo.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/uploads/", repo.RepositoryStr()),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := transport.CheckError(resp, http.StatusAccepted); err != nil {
+		return err
+	}
+	if loc, err := resp.Location(); err == nil {
+		if del, err := http.NewRequestWithContext(ctx, http.MethodDelete, loc.String(), nil); err == nil {
+			if dresp, err := client.Do(del); err == nil {
+				dresp.Body.Close()
+			}
+		}
+	}
+	return nil
+}
+
+// logPlan reports a transfer plan
+func logPlan(plan transferPlan) {
+	logInfof("Dry run for %s (nothing is written):", plan.Destination)
+	switch {
+	case plan.Current == nil:
+		logInfof("  Destination does not exist yet and would be created as %s", plan.Digest)
+	case *plan.Current == plan.Digest:
+		logInfof("  Destination is already up to date (%s)", plan.Digest)
+	default:
+		logInfof("  Destination would change from %s to %s", *plan.Current, plan.Digest)
+	}
+	logInfof("  Push access: %s", plan.PushAccess)
+
+	// A registry may refuse a mount, and the blob is then uploaded in full, so a
+	// mount is reported with the size it may cost
+	for _, b := range plan.Blobs {
+		size := FormatBytes(b.Size)
+		if b.Action == blobMount {
+			size = "up to " + size
+		}
+		logInfof("  %-6s  %s  %s", b.Action, b.Digest, size)
+	}
+
+	existing, existingSize := plan.bytes(blobExisting)
+	mounts, mountSize := plan.bytes(blobMount)
+	uploads, uploadSize := plan.bytes(blobUpload)
+	logInfof("%d blobs: %d already present (%s), %d to mount (up to %s), %d to upload (%s)",
+		len(plan.Blobs), existing, FormatBytes(existingSize), mounts, FormatBytes(mountSize), uploads, FormatBytes(uploadSize))
+	if mounts > 0 {
+		logInfof("✓ Would transfer %s to %s, or up to %s if the registry refuses the mounts",
+			FormatBytes(uploadSize), plan.Destination, FormatBytes(uploadSize+mountSize))
+		return
+	}
+	logInfof("✓ Would transfer %s to %s", FormatBytes(uploadSize), plan.Destination)
+}
+
+// blobExists checks whether a blob is already present in repo
+func blobExists(ctx context.Context, client *http.Client, repo name.Repository, digest v1.Hash) (bool, error) {
+	u := url.URL{
+		Scheme: repo.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/%s", repo.RepositoryStr(), digest),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if err := transport.CheckError(resp, http.StatusOK, http.StatusNotFound); err != nil {
+		return false, err
+	}
+	return resp.StatusCode == http.StatusOK, nil
+}
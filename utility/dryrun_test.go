@@ -0,0 +1,245 @@
+package utility
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// readOnlyRegistry denies every upload, like a registry the credentials may only
+// pull from, and counts the upload attempts
+type readOnlyRegistry struct {
+	handler  http.Handler
+	mu       sync.Mutex
+	attempts int
+}
+
+func (r *readOnlyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost || req.Method == http.MethodPut || req.Method == http.MethodPatch {
+		r.mu.Lock()
+		r.attempts++
+		r.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"errors":[{"code":"DENIED","message":"requested access to the resource is denied"}]}`)
+		return
+	}
+	r.handler.ServeHTTP(w, req)
+}
+
+// tokenRegistry requires a bearer token issued by its own token endpoint, which
+// grants the given actions on every requested repository
+type tokenRegistry struct {
+	handler http.Handler
+	actions []string
+}
+
+func (r *tokenRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		// scope is repository:<name>:<actions>
+		parts := strings.Split(req.URL.Query().Get("scope"), ":")
+		claims, _ := json.Marshal(map[string]any{"access": []map[string]any{
+			{"type": "repository", "name": parts[1], "actions": r.actions},
+		}})
+		token := "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".c2ln"
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.handler.ServeHTTP(w, req)
+}
+
+// TestPlanTransfer tests which blobs a dry run expects to upload
+func TestPlanTransfer(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	digest, _ := img.Digest()
+
+	// A second image sharing the first one's layers but with one new layer
+	extra, _ := random.Layer(512, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	newer, err := mutate.AppendLayers(img, extra)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	newerDigest, _ := newer.Digest()
+
+	tests := []struct {
+		name        string
+		dst         string
+		img         remote.Taggable
+		wantCurrent bool
+		wantUpload  int
+		wantExists  int
+	}{
+		{name: "empty registry", dst: newTestRegistry(t) + "/app:v1", img: img, wantUpload: 3},
+		{name: "up to date", dst: reg + "/app:v1", img: img, wantCurrent: true, wantExists: 3},
+		{name: "new layer", dst: reg + "/app:v1", img: newer, wantCurrent: true, wantUpload: 2, wantExists: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planTransfer(context.Background(), tt.img, mustParseRef(t, tt.dst), authn.DefaultKeychain, nil, false)
+			if err != nil {
+				t.Fatalf("planTransfer() failed: %v", err)
+			}
+			if (plan.Current != nil) != tt.wantCurrent {
+				t.Errorf("Current = %v, want set: %v", plan.Current, tt.wantCurrent)
+			}
+			if plan.Current != nil && *plan.Current != digest {
+				t.Errorf("Current = %s, want %s", *plan.Current, digest)
+			}
+			if uploads, _ := plan.bytes(blobUpload); uploads != tt.wantUpload {
+				t.Errorf("Blobs to upload = %d, want %d", uploads, tt.wantUpload)
+			}
+			if existing, _ := plan.bytes(blobExisting); existing != tt.wantExists {
+				t.Errorf("Existing blobs = %d, want %d", existing, tt.wantExists)
+			}
+		})
+	}
+
+	// Planning never writes: the newer image is still not in the registry
+	if _, err := remote.Head(mustParseRef(t, reg+"/app@"+newerDigest.String())); err == nil {
+		t.Error("Dry run wrote the manifest")
+	}
+}
+
+// TestDryRunWritesNothing tests that dry runs of copy and push leave the destination untouched
+func TestDryRunWritesNothing(t *testing.T) {
+	src := newTestRegistry(t)
+	img := pushRandomImage(t, src+"/app:v1")
+	sourcePath := filepath.Join(t.TempDir(), "image.tar")
+	if err := tarball.WriteToFile(sourcePath, mustParseRef(t, src+"/app:v1"), img); err != nil {
+		t.Fatalf("Failed to write test tarball: %v", err)
+	}
+
+	dst := newTestRegistry(t)
+	if err := CopyImage(context.Background(), src+"/app:v1", dst+"/copied:v1", "", "", "default", "default", false, CopyOptions{DryRun: true}); err != nil {
+		t.Fatalf("CopyImage() dry run failed: %v", err)
+	}
+	if err := PushImage(context.Background(), dst+"/pushed:v1", sourcePath, "", "default", PushOptions{DryRun: true}); err != nil {
+		t.Fatalf("PushImage() dry run failed: %v", err)
+	}
+
+	for _, ref := range []string{dst + "/copied:v1", dst + "/pushed:v1"} {
+		if _, err := remote.Head(mustParseRef(t, ref)); err == nil {
+			t.Errorf("Dry run wrote %s", ref)
+		}
+	}
+	layers, _ := img.Layers()
+	for _, l := range layers {
+		d, _ := l.Digest()
+		resp, err := http.Head("http://" + dst + "/v2/copied/blobs/" + d.String())
+		if err != nil {
+			t.Fatalf("Failed to check blob %s: %v", d, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Dry run uploaded blob %s (HEAD status %d)", d, resp.StatusCode)
+		}
+	}
+}
+
+// TestDryRunPushDenied tests how a dry run judges push access: only --check-push
+// starts an upload, and a token without push fails the dry run
+func TestDryRunPushDenied(t *testing.T) {
+	src := newTestRegistry(t)
+	pushRandomImage(t, src+"/app:v1")
+
+	tests := []struct {
+		name         string
+		handler      func(http.Handler) http.Handler
+		checkPush    bool
+		errContains  string
+		wantAttempts int
+	}{
+		{
+			name:    "no token without probe",
+			handler: func(h http.Handler) http.Handler { return &readOnlyRegistry{handler: h} },
+		},
+		{
+			name:         "probe denied",
+			handler:      func(h http.Handler) http.Handler { return &readOnlyRegistry{handler: h} },
+			checkPush:    true,
+			errContains:  "push access",
+			wantAttempts: 1,
+		},
+		{
+			name:        "token without push",
+			handler:     func(h http.Handler) http.Handler { return &tokenRegistry{handler: h, actions: []string{"pull"}} },
+			errContains: "does not grant push",
+		},
+		{
+			name: "token with push",
+			handler: func(h http.Handler) http.Handler {
+				return &tokenRegistry{handler: h, actions: []string{"pull", "push"}}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.handler(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+			server := httptest.NewServer(handler)
+			t.Cleanup(server.Close)
+			dst := strings.TrimPrefix(server.URL, "http://")
+
+			err := CopyImage(context.Background(), src+"/app:v1", dst+"/app:v1", "", "", "default", "default", false, CopyOptions{DryRun: true, CheckPush: tt.checkPush})
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("CopyImage() dry run error = %v, want error containing %q", err, tt.errContains)
+				}
+			} else if err != nil {
+				t.Fatalf("CopyImage() dry run failed: %v", err)
+			}
+			if ro, ok := handler.(*readOnlyRegistry); ok && ro.attempts != tt.wantAttempts {
+				t.Errorf("Upload attempts = %d, want %d", ro.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+// TestLogPlanMounts tests that blobs planned as mounts are reported with their size,
+// since a registry that refuses the mount gets the whole blob uploaded instead
+func TestLogPlanMounts(t *testing.T) {
+	buf := useProgressLog(t, slog.LevelInfo)
+
+	digest, _ := v1.NewHash("sha256:" + strings.Repeat("a", 64))
+	logPlan(transferPlan{
+		Destination: mustParseRef(t, "registry.example.com/app:v1"),
+		Blobs: []plannedBlob{
+			{Digest: digest, Size: 2048, Action: blobMount},
+			{Digest: digest, Size: 1024, Action: blobUpload},
+		},
+	})
+
+	for _, want := range []string{
+		"mount   " + digest.String() + "  up to 2.0 KiB",
+		"1 to mount (up to 2.0 KiB)",
+		"Would transfer 1.0 KiB to registry.example.com/app:v1, or up to 3.0 KiB if the registry refuses the mounts",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
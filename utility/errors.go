@@ -1,6 +1,8 @@
 package utility
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -17,6 +19,12 @@ func HandleRegistryError(err error, operation string, target string) error {
 	msg := strings.ToLower(err.Error())
 
 	switch {
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("operation cancelled while %s '%s': %w", operation, target, err)
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("timed out while %s '%s'. Increase --timeout if the registry is slow. Original error: %w", operation, target, err)
+
 	case strings.Contains(msg, "unauthorized") ||
 		strings.Contains(msg, "authentication required") ||
 		strings.Contains(msg, "denied"):
@@ -1,12 +1,14 @@
 package utility
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/blang/semver"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
@@ -25,10 +27,10 @@ func normalizeImageName(imageName string) string {
 
 // ListImage lists tags from a container registry, with optional filtering and sorting by semver.
 // secretName is optional — if empty, anonymous/public access is used.
-func ListImage(imageName string, imageFilter string, secretName string, namespace string, limit int) ([]string, error) {
+func ListImage(ctx context.Context, imageName string, imageFilter string, secretName string, namespace string, limit int) ([]string, error) {
 
 	// Create keychain using shared authentication (anonymous if no secret)
-	kc, err := CreateKeychain(namespace, secretName)
+	kc, err := CreateKeychain(ctx, namespace, secretName)
 	if err != nil {
 		return nil, fmt.Errorf("error creating keychain: %w", err)
 	}
@@ -42,59 +44,89 @@ func ListImage(imageName string, imageFilter string, secretName string, namespac
 		return nil, fmt.Errorf("error parsing repository name '%s': %w", repoName, err)
 	}
 
-	// List all tags in the repository
-	var filteredTags []string
-	tags, err := remote.List(repo, remote.WithAuthFromKeychain(kc))
+	tags, err := listTags(ctx, repo, kc, imageFilter)
 	if err != nil {
-		return nil, HandleRegistryError(err, "listing tags for", repoName)
+		return nil, err
 	}
 
-	// Handle empty repository case
-	if len(tags) == 0 {
-		return nil, fmt.Errorf("repository '%s' is empty (no tags found)", repoName)
+	// list prints semver tags in their normalised form ("v1.2" as "1.2.0"); only
+	// commands that use the tags as references need the names as they are
+	sortedTags := sortTags(tags)
+	for i, tag := range sortedTags {
+		if v, err := semver.ParseTolerant(tag); err == nil {
+			sortedTags[i] = v.String()
+		}
+	}
+	if limit > 0 && limit < len(sortedTags) {
+		sortedTags = sortedTags[:limit]
 	}
+	return sortedTags, nil
+}
 
-	// Apply regex filter if provided
-	if imageFilter != "" {
-		regex, err := regexp.Compile(imageFilter)
+// listTags lists the tags of repo, keeping only those matching the regex filter
+// when one is given
+func listTags(ctx context.Context, repo name.Repository, kc authn.Keychain, filter string) ([]string, error) {
+	var regex *regexp.Regexp
+	if filter != "" {
+		var err error
+		regex, err = regexp.Compile(filter)
 		if err != nil {
-			return nil, fmt.Errorf("error compiling regex '%s': %w", imageFilter, err)
+			return nil, fmt.Errorf("error compiling regex '%s': %w", filter, err)
 		}
-		for _, tag := range tags {
-			if regex.MatchString(tag) {
-				filteredTags = append(filteredTags, tag)
-			}
+	}
+
+	tags, err := remote.List(repo, remoteOptions(ctx, kc)...)
+	if err != nil {
+		return nil, HandleRegistryError(err, "listing tags for", repo.String())
+	}
+
+	// Handle empty repository case
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("repository '%s' is empty (no tags found)", repo)
+	}
+
+	if regex == nil {
+		return tags, nil
+	}
+	var filteredTags []string
+	for _, tag := range tags {
+		if regex.MatchString(tag) {
+			filteredTags = append(filteredTags, tag)
 		}
-	} else {
-		filteredTags = tags
+	}
+	return filteredTags, nil
+}
+
+// sortTags orders semver tags newest first, followed by all other tags in their
+// original order. Tags are returned as they are named in the registry, so "v1.2.0"
+// stays "v1.2.0" and can be used as a reference; ListImage normalises them for
+// display.
+func sortTags(tags []string) []string {
+	type semverTag struct {
+		tag     string
+		version semver.Version
 	}
 
 	// Separate semver and non-semver tags
-	var semverTags []semver.Version
+	var semverTags []semverTag
 	var nonSemverTags []string
-	for _, tag := range filteredTags {
+	for _, tag := range tags {
 		v, err := semver.ParseTolerant(tag)
 		if err == nil {
-			semverTags = append(semverTags, v)
+			semverTags = append(semverTags, semverTag{tag: tag, version: v})
 		} else {
 			nonSemverTags = append(nonSemverTags, tag)
 		}
 	}
 
 	// Sort semver tags descending (newest first)
-	sort.Slice(semverTags, func(i, j int) bool {
-		return semverTags[i].GT(semverTags[j])
+	sort.SliceStable(semverTags, func(i, j int) bool {
+		return semverTags[i].version.GT(semverTags[j].version)
 	})
 
-	// Convert sorted semver tags back to string, then append non-semver tags
-	sortedTags := make([]string, 0, len(semverTags)+len(nonSemverTags))
-	for _, v := range semverTags {
-		sortedTags = append(sortedTags, v.String())
+	sortedTags := make([]string, 0, len(tags))
+	for _, t := range semverTags {
+		sortedTags = append(sortedTags, t.tag)
 	}
-	sortedTags = append(sortedTags, nonSemverTags...)
-
-	if limit > 0 && limit < len(sortedTags) {
-		sortedTags = sortedTags[:limit]
-	}
-	return sortedTags, nil
+	return append(sortedTags, nonSemverTags...)
 }
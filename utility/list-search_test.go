@@ -1,6 +1,8 @@
 package utility
 
 import (
+	"context"
+	"strings"
 	"testing"
 )
 
@@ -57,7 +59,7 @@ func TestListImageValidation(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Note: This will fail without k8s cluster and registry access
 			// We're testing parameter validation, not actual functionality
-			_, err := ListImage(tt.imageName, tt.imageFilter, tt.secretName, tt.namespace, tt.limit)
+			_, err := ListImage(context.Background(), tt.imageName, tt.imageFilter, tt.secretName, tt.namespace, tt.limit)
 
 			if tt.wantErr {
 				if err == nil {
@@ -105,7 +107,7 @@ func TestImageFilterRegex(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// We'll test this by calling ListImage with the filter
 			// The function should handle invalid regex gracefully
-			_, err := ListImage("nginx", tt.filter, "test-secret", "default", 1)
+			_, err := ListImage(context.Background(), "nginx", tt.filter, "test-secret", "default", 1)
 
 			if tt.wantErr && err == nil {
 				// In a perfect world, this should error on invalid regex
@@ -119,3 +121,60 @@ func TestImageFilterRegex(t *testing.T) {
 		})
 	}
 }
+
+// TestSortTags tests ordering semver tags newest first while keeping their names
+func TestSortTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{
+			name: "semver descending",
+			tags: []string{"1.0.0", "1.10.0", "1.2.0"},
+			want: []string{"1.10.0", "1.2.0", "1.0.0"},
+		},
+		{
+			name: "v prefix is kept",
+			tags: []string{"v1.0.0", "v2.0.0"},
+			want: []string{"v2.0.0", "v1.0.0"},
+		},
+		{
+			name: "non-semver tags last in original order",
+			tags: []string{"latest", "v1.0.0", "edge", "v1.1.0"},
+			want: []string{"v1.1.0", "v1.0.0", "latest", "edge"},
+		},
+		{
+			name: "empty",
+			tags: nil,
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortTags(tt.tags)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("sortTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestListImageNormalisesSemverTags tests that list keeps printing semver tags in
+// their normalised form, while sortTags keeps the registry names for copy-tags
+func TestListImageNormalisesSemverTags(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1.2")
+	for _, tag := range []string{"1.10.0", "latest"} {
+		pushTestImage(t, reg+"/app:"+tag, img)
+	}
+
+	got, err := ListImage(context.Background(), reg+"/app", "", "", "default", 0)
+	if err != nil {
+		t.Fatalf("ListImage() failed: %v", err)
+	}
+	if want := "1.10.0,1.2.0,latest"; strings.Join(got, ",") != want {
+		t.Errorf("ListImage() = %v, want %s", got, want)
+	}
+}
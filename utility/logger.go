@@ -0,0 +1,156 @@
+package utility
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats accepted by NewLogger
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logger receives all human-readable status output. It writes to stderr so that
+// stdout only carries command results and streamed images.
+var logger = slog.New(newTextHandler(os.Stderr, slog.LevelInfo))
+
+// NewLogger returns a logger that writes messages at level and above to w, as plain
+// lines of text or as one JSON object per line
+func NewLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	switch format {
+	case "", LogFormatText:
+		return slog.New(newTextHandler(w, level)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q (use %s or %s)", format, LogFormatText, LogFormatJSON)
+	}
+}
+
+// SetLogger replaces the logger used for status output
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+// logDebugf logs a detail only shown with --verbose
+func logDebugf(format string, args ...any) {
+	logger.Debug(fmt.Sprintf(format, args...))
+}
+
+// logInfof logs a status message, which --quiet hides
+func logInfof(format string, args ...any) {
+	logger.Info(fmt.Sprintf(format, args...))
+}
+
+// logWarnf logs a problem that does not stop the operation, shown even with --quiet
+func logWarnf(format string, args ...any) {
+	logger.Warn(fmt.Sprintf(format, args...))
+}
+
+// logStatusf logs a status message that is only interesting with --progress: it is
+// shown when showProgress is set and at debug level otherwise
+func logStatusf(showProgress bool, format string, args ...any) {
+	if showProgress {
+		logInfof(format, args...)
+	} else {
+		logDebugf(format, args...)
+	}
+}
+
+// textHandler writes each record as its message followed by any attributes as
+// key=value pairs, without the timestamp and level slog.TextHandler adds
+type textHandler struct {
+	w     io.Writer
+	mu    *sync.Mutex
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func newTextHandler(w io.Writer, level slog.Level) *textHandler {
+	return &textHandler{w: w, mu: &sync.Mutex{}, level: level}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	var err error
+	withProgressCleared(func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		_, err = io.WriteString(h.w, b.String())
+	})
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	// Groups only matter for structured output; plain text keeps keys as they are
+	return h
+}
+
+// redactURL returns u for logging without its user info and with the query string
+// replaced, since pre-signed blob redirects and some token flows carry credentials
+// in query parameters
+func redactURL(u *url.URL) string {
+	clean := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path, RawPath: u.RawPath}
+	if u.RawQuery != "" {
+		clean.RawQuery = "REDACTED"
+	}
+	return clean.String()
+}
+
+// tracingTransport logs every registry request and its outcome at debug level, and
+// reports blob checks and mounts to the progress display
+type tracingTransport struct {
+	inner http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !logger.Enabled(req.Context(), slog.LevelDebug) {
+		resp, err := t.inner.RoundTrip(req)
+		if err == nil {
+			observeProgress(req, resp)
+		}
+		return resp, err
+	}
+
+	start := time.Now()
+	resp, err := t.inner.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	// Only the method and a redacted URL are logged; headers carry credentials
+	if err != nil {
+		logger.Debug("HTTP request failed", "method", req.Method, "url", redactURL(req.URL), "duration", elapsed.String(), "error", err.Error())
+		return nil, err
+	}
+	logger.Debug("HTTP request", "method", req.Method, "url", redactURL(req.URL), "status", resp.StatusCode, "duration", elapsed.String())
+	observeProgress(req, resp)
+	return resp, nil
+}
@@ -0,0 +1,158 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useLogger replaces the status logger for the duration of a test
+func useLogger(t *testing.T, l *slog.Logger) {
+	t.Helper()
+
+	old := logger
+	SetLogger(l)
+	t.Cleanup(func() { SetLogger(old) })
+}
+
+// TestNewLogger tests log formats and level filtering
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		level     slog.Level
+		expectErr bool
+		want      []string
+		notWant   []string
+	}{
+		{
+			name:    "text at info",
+			format:  LogFormatText,
+			level:   slog.LevelInfo,
+			want:    []string{"Pulling image app:v1\n", "✗ failed\n"},
+			notWant: []string{"HTTP request", "level="},
+		},
+		{
+			name:    "quiet only shows warnings",
+			format:  LogFormatText,
+			level:   slog.LevelWarn,
+			want:    []string{"✗ failed\n"},
+			notWant: []string{"Pulling image"},
+		},
+		{
+			name:   "verbose shows debug with attributes",
+			format: LogFormatText,
+			level:  slog.LevelDebug,
+			want:   []string{"HTTP request method=GET status=200\n", "Pulling image app:v1\n"},
+		},
+		{
+			name:   "json",
+			format: LogFormatJSON,
+			level:  slog.LevelInfo,
+			want:   []string{`"level":"INFO","msg":"Pulling image app:v1"`, `"level":"WARN","msg":"✗ failed"`},
+		},
+		{
+			name:      "unsupported format",
+			format:    "xml",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := NewLogger(&buf, tt.format, tt.level)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("NewLogger() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewLogger() failed: %v", err)
+			}
+			useLogger(t, l)
+
+			logDebugf("HTTP request")
+			logger.Debug("HTTP request", "method", "GET", "status", 200)
+			logInfof("Pulling image %s", "app:v1")
+			logWarnf("✗ %s", "failed")
+
+			out := buf.String()
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("Log output missing %q:\n%s", w, out)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(out, w) {
+					t.Errorf("Log output contains %q:\n%s", w, out)
+				}
+			}
+			if tt.format == LogFormatJSON {
+				for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+					if !json.Valid([]byte(line)) {
+						t.Errorf("Log line is not valid JSON: %s", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestRequestTracing tests that registry requests are traced at debug level only
+func TestRequestTracing(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/app:v1")
+
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		t.Run(level.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			l, _ := NewLogger(&buf, LogFormatText, level)
+			useLogger(t, l)
+
+			outputPath := filepath.Join(t.TempDir(), "image.tar")
+			if err := PullImage(context.Background(), reg+"/app:v1", outputPath, "", "default", PullOptions{}); err != nil {
+				t.Fatalf("PullImage() failed: %v", err)
+			}
+
+			traced := strings.Contains(buf.String(), "HTTP request method=GET url=http://"+reg+"/v2/app/manifests/v1 status=200")
+			if traced != (level == slog.LevelDebug) {
+				t.Errorf("Request traced = %v at level %s:\n%s", traced, level, buf.String())
+			}
+		})
+	}
+}
+
+// TestRequestTracingRedactsQuery tests that credentials in the query string, like the
+// signature of a pre-signed blob redirect, never reach the log
+func TestRequestTracingRedactsQuery(t *testing.T) {
+	var buf bytes.Buffer
+	l, _ := NewLogger(&buf, LogFormatText, slog.LevelDebug)
+	useLogger(t, l)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: &tracingTransport{inner: http.DefaultTransport}}
+	resp, err := client.Get(server.URL + "/blobs/sha256:abc?X-Amz-Signature=s3cr3t&token=t0ken")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if !strings.Contains(buf.String(), "url="+server.URL+"/blobs/sha256:abc?REDACTED") {
+		t.Errorf("Request not traced with a redacted query:\n%s", buf.String())
+	}
+	for _, secret := range []string{"s3cr3t", "t0ken"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("Log contains %q:\n%s", secret, buf.String())
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// clusterImage is an image referenced by workloads in a namespace, together with
+// the credentials those workloads use to pull it.
+type clusterImage struct {
+	Reference       string
+	ServiceAccounts []string
+	PullSecrets     []string
+}
+
+// MirrorCluster copies every image used by pods and workloads in a namespace to a
+// destination registry prefix. Each image is pulled with the pull secrets and
+// service account declared in the pod spec that references it. opts applies to every
+// copy as it does for CopyImage.
+func MirrorCluster(ctx context.Context, namespace string, targetPrefix string, destSecret string, destNamespace string, showProgress bool, opts CopyOptions) error {
+	if strings.Trim(targetPrefix, "/") == "" {
+		return fmt.Errorf("destination prefix must not be empty")
+	}
+
+	clientset, err := CreateK8sClient()
+	if err != nil {
+		return err
+	}
+
+	// Create destination keychain
+	destKC, err := CreateKeychain(ctx, destNamespace, destSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create destination keychain: %w", err)
+	}
+
+	images, err := collectClusterImages(ctx, clientset, namespace)
+	if err != nil {
+		return err
+	}
+
+	if len(images) == 0 {
+		return fmt.Errorf("no images found in namespace '%s'", namespace)
+	}
+
+	logInfof("Mirroring %d images from namespace %s to %s...", len(images), namespace, targetPrefix)
+
+	var failures []error
+	for i, image := range images {
+		// Stop between images once the command is cancelled
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("mirroring stopped after %d of %d images: %w", i, len(images), err)
+		}
+
+		destImage, err := mirrorDestination(image.Reference, targetPrefix)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		logInfof("[%d/%d] %s -> %s", i+1, len(images), image.Reference, destImage)
+
+		if err := mirrorClusterImage(ctx, clientset, namespace, image, destImage, destKC, showProgress, opts); err != nil {
+			logWarnf("✗ %v", err)
+			failures = append(failures, err)
+			continue
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to mirror %d of %d images: %w", len(failures), len(images), errors.Join(failures...))
+	}
+
+	if !opts.DryRun {
+		logInfof("✓ Successfully mirrored %d images to %s", len(images), targetPrefix)
+	}
+	return nil
+}
+
+// mirrorClusterImage copies a single cluster image, building a source keychain from
+// the pull secrets and service accounts of the pods that use it.
+func mirrorClusterImage(ctx context.Context, client kubernetes.Interface, namespace string, image clusterImage, destImage string, destKC authn.Keychain, showProgress bool, opts CopyOptions) error {
+	srcRef, err := name.ParseReference(image.Reference)
+	if err != nil {
+		return fmt.Errorf("failed to parse source image reference '%s': %w", image.Reference, err)
+	}
+
+	dstRef, err := name.ParseReference(destImage)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination image reference '%s': %w", destImage, err)
+	}
+
+	// Each service account may carry its own pull secrets, so try all of them
+	keychains := make([]authn.Keychain, 0, len(image.ServiceAccounts))
+	for _, serviceAccount := range image.ServiceAccounts {
+		kc, err := createPodKeychain(ctx, client, namespace, serviceAccount, image.PullSecrets)
+		if err != nil {
+			return err
+		}
+		keychains = append(keychains, kc)
+	}
+
+	return copyReference(ctx, srcRef, dstRef, authn.NewMultiKeychain(keychains...), destKC, showProgress, opts)
+}
+
+// collectClusterImages lists pods and workload controllers in the namespace and
+// returns the unique images they reference, sorted by reference.
+func collectClusterImages(ctx context.Context, client kubernetes.Interface, namespace string) ([]clusterImage, error) {
+	var specs []corev1.PodSpec
+
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in namespace '%s': %w", namespace, err)
+	}
+	for _, pod := range pods.Items {
+		specs = append(specs, pod.Spec)
+	}
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in namespace '%s': %w", namespace, err)
+	}
+	for _, deployment := range deployments.Items {
+		specs = append(specs, deployment.Spec.Template.Spec)
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets in namespace '%s': %w", namespace, err)
+	}
+	for _, statefulSet := range statefulSets.Items {
+		specs = append(specs, statefulSet.Spec.Template.Spec)
+	}
+
+	daemonSets, err := client.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list daemonsets in namespace '%s': %w", namespace, err)
+	}
+	for _, daemonSet := range daemonSets.Items {
+		specs = append(specs, daemonSet.Spec.Template.Spec)
+	}
+
+	jobs, err := client.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs in namespace '%s': %w", namespace, err)
+	}
+	for _, job := range jobs.Items {
+		specs = append(specs, job.Spec.Template.Spec)
+	}
+
+	cronJobs, err := client.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cronjobs in namespace '%s': %w", namespace, err)
+	}
+	for _, cronJob := range cronJobs.Items {
+		specs = append(specs, cronJob.Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	return dedupeClusterImages(specs), nil
+}
+
+// dedupeClusterImages merges the images of all pod specs, combining the pull
+// secrets and service accounts of every spec that references the same image.
+func dedupeClusterImages(specs []corev1.PodSpec) []clusterImage {
+	type credentials struct {
+		serviceAccounts map[string]bool
+		pullSecrets     map[string]bool
+	}
+	found := map[string]*credentials{}
+
+	for _, spec := range specs {
+		serviceAccount := spec.ServiceAccountName
+		if serviceAccount == "" {
+			serviceAccount = "default"
+		}
+
+		var refs []string
+		for _, c := range spec.InitContainers {
+			refs = append(refs, c.Image)
+		}
+		for _, c := range spec.Containers {
+			refs = append(refs, c.Image)
+		}
+		for _, c := range spec.EphemeralContainers {
+			refs = append(refs, c.Image)
+		}
+
+		for _, ref := range refs {
+			if ref == "" {
+				continue
+			}
+			creds, ok := found[ref]
+			if !ok {
+				creds = &credentials{serviceAccounts: map[string]bool{}, pullSecrets: map[string]bool{}}
+				found[ref] = creds
+			}
+			creds.serviceAccounts[serviceAccount] = true
+			for _, secret := range spec.ImagePullSecrets {
+				if secret.Name != "" {
+					creds.pullSecrets[secret.Name] = true
+				}
+			}
+		}
+	}
+
+	images := make([]clusterImage, 0, len(found))
+	for ref, creds := range found {
+		images = append(images, clusterImage{
+			Reference:       ref,
+			ServiceAccounts: sortedKeys(creds.serviceAccounts),
+			PullSecrets:     sortedKeys(creds.pullSecrets),
+		})
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Reference < images[j].Reference
+	})
+
+	return images
+}
+
+// mirrorDestination maps a source image reference onto the destination prefix,
+// keeping its repository path and tag or digest
+// (e.g. docker.io/library/nginx:1.27 -> mirror.io/team/library/nginx:1.27).
+func mirrorDestination(sourceImage string, targetPrefix string) (string, error) {
+	ref, err := name.ParseReference(sourceImage)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse source image reference '%s': %w", sourceImage, err)
+	}
+
+	dest := strings.TrimSuffix(targetPrefix, "/") + "/" + ref.Context().RepositoryStr()
+	switch r := ref.(type) {
+	case name.Digest:
+		return dest + "@" + r.DigestStr(), nil
+	case name.Tag:
+		return dest + ":" + r.TagStr(), nil
+	default:
+		return dest, nil
+	}
+}
+
+// sortedKeys returns the keys of a set in sorted order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,196 @@
+package utility
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestMirrorDestination tests mapping source references onto the destination prefix
+func TestMirrorDestination(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourceImage  string
+		targetPrefix string
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:         "docker hub short name",
+			sourceImage:  "nginx:1.27",
+			targetPrefix: "mirror.io/team",
+			want:         "mirror.io/team/library/nginx:1.27",
+		},
+		{
+			name:         "private registry with trailing slash prefix",
+			sourceImage:  "gcr.io/project/app:v1.0.0",
+			targetPrefix: "mirror.io/team/",
+			want:         "mirror.io/team/project/app:v1.0.0",
+		},
+		{
+			name:         "implicit latest tag",
+			sourceImage:  "myregistry.io/app",
+			targetPrefix: "mirror.io",
+			want:         "mirror.io/app:latest",
+		},
+		{
+			name:         "digest reference",
+			sourceImage:  "myregistry.io/app@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			targetPrefix: "mirror.io",
+			want:         "mirror.io/app@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		{
+			name:         "invalid reference",
+			sourceImage:  ":::invalid:::",
+			targetPrefix: "mirror.io",
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mirrorDestination(tt.sourceImage, tt.targetPrefix)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for test case: %s", tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("mirrorDestination() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCollectClusterImages tests image enumeration and deduplication across workloads
+func TestCollectClusterImages(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "shop"},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "regcred"}},
+				InitContainers:   []corev1.Container{{Name: "init", Image: "busybox:1.36"}},
+				Containers:       []corev1.Container{{Name: "web", Image: "myregistry.io/web:v1"}},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						ServiceAccountName: "web",
+						ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "other-cred"}},
+						Containers:         []corev1.Container{{Name: "web", Image: "myregistry.io/web:v1"}},
+					},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "elsewhere", Namespace: "other"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "ignored:latest"}},
+			},
+		},
+	)
+
+	images, err := collectClusterImages(context.Background(), client, "shop")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []clusterImage{
+		{
+			Reference:       "busybox:1.36",
+			ServiceAccounts: []string{"default"},
+			PullSecrets:     []string{"regcred"},
+		},
+		{
+			Reference:       "myregistry.io/web:v1",
+			ServiceAccounts: []string{"default", "web"},
+			PullSecrets:     []string{"other-cred", "regcred"},
+		},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("collectClusterImages() = %+v, want %+v", images, want)
+	}
+}
+
+// TestMirrorClusterValidation tests basic validation of MirrorCluster parameters
+func TestMirrorClusterValidation(t *testing.T) {
+	tests := []struct {
+		name         string
+		namespace    string
+		targetPrefix string
+		wantErr      bool
+	}{
+		{
+			name:         "valid parameters",
+			namespace:    "default",
+			targetPrefix: "mirror.io/team",
+			wantErr:      false, // Will error in test without k8s
+		},
+		{
+			name:         "empty target prefix",
+			namespace:    "default",
+			targetPrefix: "",
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MirrorCluster(context.Background(), tt.namespace, tt.targetPrefix, "", "default", false, CopyOptions{})
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for test case: %s", tt.name)
+				}
+			} else if err != nil {
+				// Expected in test environment without k8s
+				t.Logf("Expected error without k8s access: %v", err)
+			}
+		})
+	}
+}
+
+// TestMirrorClusterImageCopyOptions tests that mirrored images honour the copy
+// options, such as the layer cache
+func TestMirrorClusterImageCopyOptions(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "shop"}})
+	src := newTestRegistry(t)
+	dst := newTestRegistry(t)
+	img := pushRandomImage(t, src+"/app:v1")
+	image := clusterImage{Reference: src + "/app:v1", ServiceAccounts: []string{"default"}}
+
+	// A dry run writes nothing
+	if err := mirrorClusterImage(context.Background(), client, "shop", image, dst+"/app:v1", authn.DefaultKeychain, false, CopyOptions{DryRun: true}); err != nil {
+		t.Fatalf("mirrorClusterImage() dry run failed: %v", err)
+	}
+	if _, err := remote.Head(mustParseRef(t, dst+"/app:v1")); err == nil {
+		t.Error("Dry run wrote the destination")
+	}
+
+	cacheDir := t.TempDir()
+	if err := mirrorClusterImage(context.Background(), client, "shop", image, dst+"/app:v1", authn.DefaultKeychain, false, CopyOptions{CacheDir: cacheDir}); err != nil {
+		t.Fatalf("mirrorClusterImage() failed: %v", err)
+	}
+	layers, _ := img.Layers()
+	cache := newBlobCache(cacheDir)
+	for _, l := range layers {
+		digest, _ := l.Digest()
+		if !cache.Has(digest) {
+			t.Errorf("Layer %s was not added to the cache", digest)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package utility
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Annotations docker buildx sets on attestation manifests in an index, pointing at
+// the image they describe
+const (
+	dockerReferenceTypeAnnotation   = "vnd.docker.reference.type"
+	dockerReferenceDigestAnnotation = "vnd.docker.reference.digest"
+)
+
+// ParseKeyValues parses KEY=VALUE arguments, as given to --label, --annotation and
+// --env, into a map. Later values for the same key win.
+func ParseKeyValues(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]string, len(values))
+	for _, kv := range values {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid value '%s' (expected KEY=VALUE)", kv)
+		}
+		parsed[key] = value
+	}
+	return parsed, nil
+}
+
+// mutatesImage reports whether opts change the images being copied
+func (o CopyOptions) mutatesImage() bool {
+	return len(o.Labels) > 0 || len(o.Annotations) > 0 || len(o.Env) > 0
+}
+
+// mutateArtifact applies the labels, environment variables and annotations in opts
+// to an image, or to every image of an index. Annotations are also set on the index
+// itself. Layers are never changed, so blobs can still be mounted or reused.
+func mutateArtifact(artifact remote.Taggable, opts CopyOptions) (remote.Taggable, error) {
+	switch a := artifact.(type) {
+	case v1.ImageIndex:
+		return mutateIndex(a, opts)
+	case v1.Image:
+		return mutateImage(a, opts)
+	}
+	return artifact, nil
+}
+
+// mutateImage returns img with a new config carrying the labels and environment
+// variables in opts, and a manifest carrying its annotations
+func mutateImage(img v1.Image, opts CopyOptions) (v1.Image, error) {
+	if len(opts.Labels) > 0 || len(opts.Env) > 0 {
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image config: %w", err)
+		}
+		config = config.DeepCopy()
+
+		if len(opts.Labels) > 0 && config.Config.Labels == nil {
+			config.Config.Labels = make(map[string]string, len(opts.Labels))
+		}
+		for key, value := range opts.Labels {
+			config.Config.Labels[key] = value
+		}
+		config.Config.Env = setEnv(config.Config.Env, opts.Env)
+
+		img, err = mutate.ConfigFile(img, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update image config: %w", err)
+		}
+	}
+
+	if len(opts.Annotations) > 0 {
+		img = mutate.Annotations(img, opts.Annotations).(v1.Image)
+	}
+	return img, nil
+}
+
+// setEnv replaces the variables in env that are set in vars and appends the rest,
+// in sorted order so the resulting config is reproducible
+func setEnv(env []string, vars map[string]string) []string {
+	if len(vars) == 0 {
+		return env
+	}
+
+	result := make([]string, 0, len(env)+len(vars))
+	replaced := make(map[string]bool, len(vars))
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if value, ok := vars[key]; ok {
+			kv = key + "=" + value
+			replaced[key] = true
+		}
+		result = append(result, kv)
+	}
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		if !replaced[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result = append(result, key+"="+vars[key])
+	}
+	return result
+}
+
+// mutateIndex applies opts to every image of idx and sets its annotations on the
+// index itself
+func mutateIndex(idx v1.ImageIndex, opts CopyOptions) (v1.ImageIndex, error) {
+	result, err := mapIndexImages(idx, func(img v1.Image, _ v1.Descriptor) (v1.Image, error) {
+		return mutateImage(img, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Annotations) > 0 {
+		result = mutate.Annotations(result, opts.Annotations).(v1.ImageIndex)
+	}
+	return result, nil
+}
+
+// mapIndexImages rebuilds idx with fn applied to every child image, including
+// those of nested indexes, keeping the order, platforms and descriptor annotations
+// of its manifests. Attestation manifests are kept as they are while the image they
+// describe is unchanged; those of changed images are dropped with a warning, since
+// their signed in-toto subject still names the original digest.
+func mapIndexImages(idx v1.ImageIndex, fn func(v1.Image, v1.Descriptor) (v1.Image, error)) (v1.ImageIndex, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image index manifest: %w", err)
+	}
+
+	digests := make(map[string]string, len(manifest.Manifests))
+	addenda := make([]mutate.IndexAddendum, 0, len(manifest.Manifests))
+	for _, child := range manifest.Manifests {
+		var add mutate.Appendable
+		switch {
+		case child.MediaType.IsIndex():
+			nested, err := idx.ImageIndex(child.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read nested index %s: %w", child.Digest, err)
+			}
+			if add, err = mapIndexImages(nested, fn); err != nil {
+				return nil, err
+			}
+		case child.MediaType.IsImage() && child.Annotations[dockerReferenceTypeAnnotation] == "":
+			img, err := idx.Image(child.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image %s: %w", child.Digest, err)
+			}
+			if add, err = fn(img, child); err != nil {
+				return nil, err
+			}
+		case child.MediaType.IsImage():
+			// Attestation manifests are kept unchanged
+			if add, err = idx.Image(child.Digest); err != nil {
+				return nil, fmt.Errorf("failed to read manifest %s: %w", child.Digest, err)
+			}
+		default:
+			return nil, fmt.Errorf("cannot change index containing manifest %s of type %s", child.Digest, child.MediaType)
+		}
+
+		digest, err := add.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute manifest digest: %w", err)
+		}
+		digests[child.Digest.String()] = digest.String()
+
+		desc := v1.Descriptor{
+			MediaType:   child.MediaType,
+			Platform:    child.Platform,
+			Annotations: child.Annotations,
+		}
+		addenda = append(addenda, mutate.IndexAddendum{Add: add, Descriptor: desc})
+	}
+
+	// An attestation of a changed image would be stale, so it is left out
+	kept := addenda[:0]
+	dropped := 0
+	for _, add := range addenda {
+		subject, ok := add.Descriptor.Annotations[dockerReferenceDigestAnnotation]
+		if ok && digests[subject] != "" && digests[subject] != subject {
+			dropped++
+			continue
+		}
+		kept = append(kept, add)
+	}
+	if dropped > 0 {
+		logWarnf("Dropped %d attestation manifests: they describe images that were changed and would no longer match them", dropped)
+	}
+
+	// Start from the original index to keep its media type and annotations
+	result := mutate.RemoveManifests(idx, func(v1.Descriptor) bool { return true })
+	return mutate.AppendManifests(result, kept...), nil
+}
@@ -0,0 +1,208 @@
+package utility
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// TestParseKeyValues tests parsing KEY=VALUE arguments
+func TestParseKeyValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "none", values: nil, want: nil},
+		{name: "pairs", values: []string{"a=1", "b=2"}, want: map[string]string{"a": "1", "b": "2"}},
+		{name: "value with equals sign", values: []string{"url=https://x.io/?a=b"}, want: map[string]string{"url": "https://x.io/?a=b"}},
+		{name: "empty value", values: []string{"a="}, want: map[string]string{"a": ""}},
+		{name: "later value wins", values: []string{"a=1", "a=2"}, want: map[string]string{"a": "2"}},
+		{name: "missing equals sign", values: []string{"a"}, wantErr: true},
+		{name: "empty key", values: []string{"=1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeyValues(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseKeyValues() = %v, want %v", got, tt.want)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("ParseKeyValues()[%s] = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+// TestSetEnv tests replacing and appending environment variables
+func TestSetEnv(t *testing.T) {
+	env := []string{"PATH=/usr/bin", "HOME=/root"}
+	got := setEnv(env, map[string]string{"HOME": "/home/app", "B": "2", "A": "1"})
+	want := []string{"PATH=/usr/bin", "HOME=/home/app", "A=1", "B=2"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("setEnv() = %v, want %v", got, want)
+	}
+	if env[1] != "HOME=/root" {
+		t.Errorf("setEnv() changed its input: %v", env)
+	}
+}
+
+// TestMutateIndex tests that every image of an index is changed and the attestations
+// of changed images are dropped
+func TestMutateIndex(t *testing.T) {
+	amd64, _ := random.Image(256, 1)
+	arm64, _ := random.Image(256, 1)
+	attestation, _ := random.Image(64, 1)
+	amd64Digest, _ := amd64.Digest()
+
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+		mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				dockerReferenceTypeAnnotation:   "attestation-manifest",
+				dockerReferenceDigestAnnotation: amd64Digest.String(),
+			},
+		}},
+	)
+
+	opts := CopyOptions{
+		Labels:      map[string]string{"mirrored": "true"},
+		Annotations: map[string]string{"org.example.source": "upstream"},
+		Env:         map[string]string{"MIRRORED": "1"},
+	}
+	buf := useProgressLog(t, slog.LevelInfo)
+	mutated, err := mutateArtifact(idx, opts)
+	if err != nil {
+		t.Fatalf("mutateArtifact() failed: %v", err)
+	}
+	result := mutated.(v1.ImageIndex)
+
+	manifest, err := result.IndexManifest()
+	if err != nil {
+		t.Fatalf("Failed to read mutated index: %v", err)
+	}
+	if manifest.Annotations["org.example.source"] != "upstream" {
+		t.Errorf("Index annotations = %v, want the new annotation", manifest.Annotations)
+	}
+	if len(manifest.Manifests) != 2 {
+		t.Fatalf("Mutated index has %d manifests, want the 2 images without the attestation", len(manifest.Manifests))
+	}
+
+	for i, child := range manifest.Manifests[:2] {
+		img, err := result.Image(child.Digest)
+		if err != nil {
+			t.Fatalf("Failed to read image %d: %v", i, err)
+		}
+		config, _ := img.ConfigFile()
+		if config.Config.Labels["mirrored"] != "true" {
+			t.Errorf("Image %d labels = %v, want mirrored=true", i, config.Config.Labels)
+		}
+		if !strings.Contains(strings.Join(config.Config.Env, " "), "MIRRORED=1") {
+			t.Errorf("Image %d env = %v, want MIRRORED=1", i, config.Config.Env)
+		}
+		imgManifest, _ := img.Manifest()
+		if imgManifest.Annotations["org.example.source"] != "upstream" {
+			t.Errorf("Image %d annotations = %v, want the new annotation", i, imgManifest.Annotations)
+		}
+		if child.Platform == nil || child.Platform.OS != "linux" {
+			t.Errorf("Image %d lost its platform: %v", i, child.Platform)
+		}
+	}
+
+	if !strings.Contains(buf.String(), "Dropped 1 attestation manifests") {
+		t.Errorf("Expected a warning about the dropped attestation, got:\n%s", buf.String())
+	}
+}
+
+// TestMapIndexImagesKeepsAttestations tests that attestations of unchanged images are kept
+func TestMapIndexImagesKeepsAttestations(t *testing.T) {
+	img, _ := random.Image(256, 1)
+	attestation, _ := random.Image(64, 1)
+	digest, _ := img.Digest()
+
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				dockerReferenceTypeAnnotation:   "attestation-manifest",
+				dockerReferenceDigestAnnotation: digest.String(),
+			},
+		}},
+	)
+
+	result, err := mapIndexImages(idx, func(img v1.Image, _ v1.Descriptor) (v1.Image, error) { return img, nil })
+	if err != nil {
+		t.Fatalf("mapIndexImages() failed: %v", err)
+	}
+	want, _ := idx.Digest()
+	if got, _ := result.Digest(); got != want {
+		t.Errorf("Index digest = %s, want it unchanged (%s)", got, want)
+	}
+}
+
+// TestCopyImageMutations tests stamping labels, annotations and env during a copy
+func TestCopyImageMutations(t *testing.T) {
+	reg := newTestRegistry(t)
+	src := pushRandomImage(t, reg+"/app:v1")
+
+	opts := CopyOptions{
+		Labels:      map[string]string{"org.example.mirrored-from": reg + "/app:v1"},
+		Annotations: map[string]string{"org.opencontainers.image.source": "https://example.com/app"},
+		Env:         map[string]string{"MIRRORED": "true"},
+	}
+	if err := CopyImage(context.Background(), reg+"/app:v1", reg+"/mirror/app:v1", "", "", "default", "default", false, opts); err != nil {
+		t.Fatalf("CopyImage() failed: %v", err)
+	}
+
+	copied := remoteImage(t, reg+"/mirror/app:v1")
+	config, err := copied.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to read copied config: %v", err)
+	}
+	if config.Config.Labels["org.example.mirrored-from"] != reg+"/app:v1" {
+		t.Errorf("Labels = %v, want the provenance label", config.Config.Labels)
+	}
+	if strings.Join(config.Config.Env, " ") != "MIRRORED=true" {
+		t.Errorf("Env = %v, want [MIRRORED=true]", config.Config.Env)
+	}
+
+	manifest, _ := copied.Manifest()
+	if manifest.Annotations["org.opencontainers.image.source"] != "https://example.com/app" {
+		t.Errorf("Annotations = %v, want the source annotation", manifest.Annotations)
+	}
+	if manifest.MediaType != types.DockerManifestSchema2 {
+		t.Errorf("Media type = %s, want it unchanged", manifest.MediaType)
+	}
+	srcManifest, _ := src.Manifest()
+	for i := range srcManifest.Layers {
+		if manifest.Layers[i].Digest != srcManifest.Layers[i].Digest {
+			t.Errorf("Layer %d changed from %s to %s", i, srcManifest.Layers[i].Digest, manifest.Layers[i].Digest)
+		}
+	}
+}
+
+// TestCopyImageMutationsWithReferrers tests that referrers are refused for a changed image
+func TestCopyImageMutationsWithReferrers(t *testing.T) {
+	opts := CopyOptions{WithReferrers: true, Labels: map[string]string{"a": "b"}}
+	err := CopyImage(context.Background(), "registry.io/app:v1", "registry.io/mirror:v1", "", "", "default", "default", false, opts)
+	if err == nil || !strings.Contains(err.Error(), "referrers") {
+		t.Errorf("CopyImage() error = %v, want error about referrers", err)
+	}
+}
@@ -0,0 +1,109 @@
+package utility
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// parsePlatforms parses platform strings of the form os/arch[/variant]
+func parsePlatforms(specs []string) ([]v1.Platform, error) {
+	platforms := make([]v1.Platform, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		platform, err := v1.ParsePlatform(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform '%s': %w", spec, err)
+		}
+		platforms = append(platforms, *platform)
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platforms specified")
+	}
+	return platforms, nil
+}
+
+// matchesPlatform reports whether a platform satisfies any of the wanted platforms.
+// Unset fields match anything, so linux/arm64 matches linux/arm64/v8.
+func matchesPlatform(platform *v1.Platform, wanted []v1.Platform) bool {
+	if platform == nil {
+		return false
+	}
+	for _, w := range wanted {
+		if platform.Satisfies(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIndex returns a copy of idx that only lists the manifests for the wanted
+// platforms. Manifests without a platform, such as attestations, are dropped.
+func filterIndex(idx v1.ImageIndex, platforms []v1.Platform) (v1.ImageIndex, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image index manifest: %w", err)
+	}
+
+	kept := 0
+	for _, child := range manifest.Manifests {
+		if matchesPlatform(child.Platform, platforms) {
+			kept++
+		}
+	}
+	if kept == 0 {
+		return nil, fmt.Errorf("image index has no manifests for platforms %s", formatPlatforms(platforms))
+	}
+
+	return mutate.RemoveManifests(idx, func(desc v1.Descriptor) bool {
+		return !matchesPlatform(desc.Platform, platforms)
+	}), nil
+}
+
+// selectPlatformImage returns the image in idx for a single platform
+func selectPlatformImage(idx v1.ImageIndex, platform v1.Platform) (v1.Image, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image index manifest: %w", err)
+	}
+
+	for _, child := range manifest.Manifests {
+		if child.MediaType.IsImage() && matchesPlatform(child.Platform, []v1.Platform{platform}) {
+			img, err := idx.Image(child.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image for platform %s: %w", platform, err)
+			}
+			return img, nil
+		}
+	}
+
+	return nil, fmt.Errorf("image index has no manifest for platform %s", platform)
+}
+
+// checkImagePlatform verifies that a single-platform image is one of the wanted platforms
+func checkImagePlatform(img v1.Image, platforms []v1.Platform) error {
+	config, err := img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to read image config: %w", err)
+	}
+
+	platform := config.Platform()
+	if !matchesPlatform(platform, platforms) {
+		return fmt.Errorf("image platform %s does not match %s", platform, formatPlatforms(platforms))
+	}
+	return nil
+}
+
+// formatPlatforms joins platforms for error messages
+func formatPlatforms(platforms []v1.Platform) string {
+	names := make([]string, 0, len(platforms))
+	for _, p := range platforms {
+		names = append(names, p.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,185 @@
+package utility
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// newPlatformIndex builds a multi-arch index with one random image per platform
+func newPlatformIndex(t *testing.T, platforms ...string) (v1.ImageIndex, map[string]v1.Hash) {
+	t.Helper()
+
+	idx := v1.ImageIndex(empty.Index)
+	digests := map[string]v1.Hash{}
+	for _, p := range platforms {
+		platform, err := v1.ParsePlatform(p)
+		if err != nil {
+			t.Fatalf("Failed to parse platform: %v", err)
+		}
+		img, err := random.Image(512, 1)
+		if err != nil {
+			t.Fatalf("Failed to create random image: %v", err)
+		}
+		digests[p], _ = img.Digest()
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: platform},
+		})
+	}
+	return idx, digests
+}
+
+// TestFilterIndex tests selecting manifests by platform
+func TestFilterIndex(t *testing.T) {
+	idx, digests := newPlatformIndex(t, "linux/amd64", "linux/arm64/v8", "linux/s390x")
+
+	tests := []struct {
+		name      string
+		platforms []string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "keep two platforms",
+			platforms: []string{"linux/amd64", "linux/arm64"},
+			want:      []string{"linux/amd64", "linux/arm64/v8"},
+		},
+		{
+			name:      "single platform",
+			platforms: []string{"linux/s390x"},
+			want:      []string{"linux/s390x"},
+		},
+		{
+			name:      "no matching platform",
+			platforms: []string{"windows/amd64"},
+			wantErr:   true,
+		},
+		{
+			name:      "empty platform list",
+			platforms: []string{""},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platforms, err := parsePlatforms(tt.platforms)
+			var filtered v1.ImageIndex
+			if err == nil {
+				filtered, err = filterIndex(idx, platforms)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for test case: %s", tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filterIndex() failed: %v", err)
+			}
+
+			manifest, err := filtered.IndexManifest()
+			if err != nil {
+				t.Fatalf("Failed to read filtered index: %v", err)
+			}
+			if len(manifest.Manifests) != len(tt.want) {
+				t.Fatalf("Got %d manifests, want %d", len(manifest.Manifests), len(tt.want))
+			}
+			for i, p := range tt.want {
+				if manifest.Manifests[i].Digest != digests[p] {
+					t.Errorf("Manifest %d = %s, want %s (%s)", i, manifest.Manifests[i].Digest, digests[p], p)
+				}
+			}
+		})
+	}
+}
+
+// TestCopyImagePlatforms tests --platforms filtering and --platform flattening
+func TestCopyImagePlatforms(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	idx, digests := newPlatformIndex(t, "linux/amd64", "linux/arm64", "linux/ppc64le")
+	srcRef, _ := name.ParseReference(reg + "/multi/app:v1")
+	if err := remote.WriteIndex(srcRef, idx); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		opts        CopyOptions
+		wantIndex   int     // number of manifests when the result is an index
+		wantDigest  v1.Hash // digest when the result is an image
+		errContains string
+	}{
+		{
+			name:      "copy whole index",
+			opts:      CopyOptions{},
+			wantIndex: 3,
+		},
+		{
+			name:      "filter platforms",
+			opts:      CopyOptions{Platforms: []string{"linux/amd64", "linux/arm64"}},
+			wantIndex: 2,
+		},
+		{
+			name:       "flatten to one platform",
+			opts:       CopyOptions{Platform: "linux/arm64"},
+			wantDigest: digests["linux/arm64"],
+		},
+		{
+			name:        "missing platform",
+			opts:        CopyOptions{Platform: "linux/riscv64"},
+			errContains: "no manifest for platform",
+		},
+		{
+			name:        "both options",
+			opts:        CopyOptions{Platform: "linux/amd64", Platforms: []string{"linux/arm64"}},
+			errContains: "cannot be used together",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := reg + "/edge/app:v" + string(rune('a'+i))
+			err := CopyImage(context.Background(), srcRef.String(), dest, "", "", "default", "default", false, tt.opts)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("CopyImage() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CopyImage() failed: %v", err)
+			}
+
+			dstRef, _ := name.ParseReference(dest)
+			desc, err := remote.Get(dstRef)
+			if err != nil {
+				t.Fatalf("Failed to get destination: %v", err)
+			}
+
+			if tt.wantIndex > 0 {
+				got, err := desc.ImageIndex()
+				if err != nil {
+					t.Fatalf("Expected an index at destination: %v", err)
+				}
+				manifest, _ := got.IndexManifest()
+				if len(manifest.Manifests) != tt.wantIndex {
+					t.Errorf("Got %d manifests, want %d", len(manifest.Manifests), tt.wantIndex)
+				}
+				return
+			}
+			if desc.MediaType.IsIndex() || desc.Digest != tt.wantDigest {
+				t.Errorf("Destination = %s (%s), want image %s", desc.Digest, desc.MediaType, tt.wantDigest)
+			}
+		})
+	}
+}
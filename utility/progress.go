@@ -0,0 +1,595 @@
+package utility
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Transfer directions shown by the progress display
+const (
+	progressUpload   = "uploading"
+	progressDownload = "downloading"
+)
+
+// Progress formats accepted by SetProgressFormat
+const (
+	ProgressFormatText = "text"
+	ProgressFormatJSON = "json"
+)
+
+// Event types of ProgressEvent
+const (
+	EventLayerStarted      = "layer_started"
+	EventLayerProgress     = "layer_progress"
+	EventLayerCompleted    = "layer_completed"
+	EventManifestWritten   = "manifest_written"
+	EventTransferCompleted = "transfer_completed"
+)
+
+// ProgressEvent is one line of the JSON progress stream (--progress-format json)
+type ProgressEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	// Direction is "uploading" or "downloading"
+	Direction string `json:"direction"`
+	// Digest is the layer or manifest digest
+	Digest string `json:"digest,omitempty"`
+	// Size is the size of the layer, or of all layers for transfer_completed
+	Size int64 `json:"size,omitempty"`
+	// Complete is the number of bytes transferred so far
+	Complete int64 `json:"complete,omitempty"`
+	// Status is how a layer completed: "done", "exists" or "mounted"
+	Status string `json:"status,omitempty"`
+	// Target is where a manifest was written: an image reference or a file
+	Target string `json:"target,omitempty"`
+}
+
+// progressFormat selects between the human progress display and JSON events
+var progressFormat = ProgressFormatText
+
+// SetProgressFormat selects how transfer progress is reported: "text" for the human
+// display, or "json" for newline-delimited ProgressEvent objects. JSON events are
+// emitted for every transfer, even without --progress or with --quiet.
+func SetProgressFormat(format string) error {
+	switch format {
+	case "", ProgressFormatText:
+		progressFormat = ProgressFormatText
+	case ProgressFormatJSON:
+		progressFormat = ProgressFormatJSON
+	default:
+		return fmt.Errorf("unsupported progress format %q (use %s or %s)", format, ProgressFormatText, ProgressFormatJSON)
+	}
+	return nil
+}
+
+// progressMode is how a tracker reports progress
+type progressMode int
+
+const (
+	// progressLines logs a summary line every progressInterval
+	progressLines progressMode = iota
+	// progressTerminal redraws a per-layer table in place
+	progressTerminal
+	// progressEvents writes ProgressEvent JSON lines
+	progressEvents
+)
+
+var (
+	// progressOutput is where the progress display is drawn, next to the log output
+	progressOutput io.Writer = os.Stderr
+	// progressRedraw is how often the terminal display is redrawn
+	progressRedraw = 200 * time.Millisecond
+	// progressInterval is how often a progress line is logged when the output is not
+	// a terminal
+	progressInterval = 5 * time.Second
+)
+
+// activeProgress is the tracker currently drawing, so log lines can be written
+// around its terminal display
+var (
+	activeMu       sync.Mutex
+	activeProgress *progressTracker
+)
+
+// layerState is the state of one layer in a transfer
+type layerState int
+
+const (
+	layerWaiting layerState = iota
+	layerExists
+	layerMounted
+	layerTransferring
+	layerDone
+)
+
+// layerProgress tracks the transfer of one layer
+type layerProgress struct {
+	digest   v1.Hash
+	size     int64
+	complete int64
+	state    layerState
+	// reported is the byte count of the last layer_progress event
+	reported int64
+}
+
+// finished reports whether the layer needs no more transfer
+func (l *layerProgress) finished() bool {
+	return l.state == layerExists || l.state == layerMounted || l.state == layerDone
+}
+
+// status names a finished layer state in progress events
+func (s layerState) status() string {
+	switch s {
+	case layerExists:
+		return "exists"
+	case layerMounted:
+		return "mounted"
+	case layerDone:
+		return "done"
+	default:
+		return ""
+	}
+}
+
+// progressTracker collects per-layer progress of an upload or download and renders
+// it: as a table that is redrawn in place on a terminal, or as a log line every
+// progressInterval otherwise
+type progressTracker struct {
+	mu          sync.Mutex
+	direction   string
+	layers      []*layerProgress
+	byDigest    map[v1.Hash]*layerProgress
+	transferred int64
+	start       time.Time
+	mode        progressMode
+	drawn       int
+	done        chan struct{}
+	wg          sync.WaitGroup
+	stopOnce    sync.Once
+}
+
+// progressKey is the context key under which the active tracker is stored, so the
+// registry transport can report blobs that already exist or were mounted
+type progressKey struct{}
+
+// trackProgress starts a progress display for the layers of artifact. It returns a
+// context that carries the tracker to registry requests, the artifact with layers
+// that report their transfer, and a function that stops the display. The display is
+// shown when show is set and the log level is info or lower; JSON progress events
+// are always emitted. Progress is best effort: if the layers cannot be listed, the
+// artifact is returned unchanged.
+func trackProgress(ctx context.Context, direction string, artifact remote.Taggable, show bool) (context.Context, remote.Taggable, func()) {
+	if progressFormat != ProgressFormatJSON && (!show || !logger.Enabled(ctx, slog.LevelInfo)) {
+		return ctx, artifact, func() {}
+	}
+	layers, err := artifactDescriptors(artifact, false)
+	if err != nil || len(layers) == 0 {
+		return ctx, artifact, func() {}
+	}
+
+	t := newProgressTracker(direction, layers)
+	t.run()
+	return context.WithValue(ctx, progressKey{}, t), t.wrap(artifact), t.stop
+}
+
+func newProgressTracker(direction string, layers []v1.Descriptor) *progressTracker {
+	t := &progressTracker{
+		direction: direction,
+		byDigest:  map[v1.Hash]*layerProgress{},
+		start:     time.Now(),
+		done:      make(chan struct{}),
+	}
+	// Redrawing in place only works on a terminal, with plain text logs, and when no
+	// debug lines are written in between
+	_, text := logger.Handler().(*textHandler)
+	switch {
+	case progressFormat == ProgressFormatJSON:
+		t.mode = progressEvents
+	case isTerminal(progressOutput) && text && !logger.Enabled(context.Background(), slog.LevelDebug):
+		t.mode = progressTerminal
+	}
+	for _, l := range layers {
+		lp := &layerProgress{digest: l.Digest, size: l.Size}
+		t.layers = append(t.layers, lp)
+		t.byDigest[l.Digest] = lp
+	}
+	return t
+}
+
+// run renders the display until stop is called
+func (t *progressTracker) run() {
+	activeMu.Lock()
+	activeProgress = t
+	activeMu.Unlock()
+
+	interval := progressInterval
+	if t.mode != progressLines {
+		interval = progressRedraw
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.render()
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop renders the final state and stops the display. The last terminal frame is
+// left on screen. Calling stop again has no effect.
+func (t *progressTracker) stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		t.wg.Wait()
+		t.render()
+
+		t.mu.Lock()
+		_, complete, total, _ := t.summary()
+		t.emit(ProgressEvent{Event: EventTransferCompleted, Size: total, Complete: complete})
+		t.mu.Unlock()
+
+		activeMu.Lock()
+		if activeProgress == t {
+			activeProgress = nil
+		}
+		activeMu.Unlock()
+	})
+}
+
+// withProgressCleared erases the terminal progress display, if one is drawn, and
+// calls write to print in its place; the next frame is drawn below the output
+func withProgressCleared(write func()) {
+	activeMu.Lock()
+	t := activeProgress
+	activeMu.Unlock()
+	if t == nil || t.mode != progressTerminal {
+		write()
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drawn > 0 {
+		fmt.Fprintf(progressOutput, "\x1b[%dA\x1b[J", t.drawn)
+		t.drawn = 0
+	}
+	write()
+}
+
+// setState moves a known layer to state
+func (t *progressTracker) setState(digest v1.Hash, state layerState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if l, ok := t.byDigest[digest]; ok && !l.finished() {
+		l.state = state
+		if l.finished() {
+			t.emit(ProgressEvent{Event: EventLayerCompleted, Digest: digest.String(), Size: l.size, Complete: l.complete, Status: state.status()})
+		}
+	}
+}
+
+// begin starts a transfer of a layer. It returns false when the layer has been
+// transferred already (e.g. pull writing to both a tar file and the daemon), so a
+// second pass does not show up as progress.
+func (t *progressTracker) begin(digest v1.Hash) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.byDigest[digest]
+	if !ok || l.finished() {
+		return false
+	}
+	// A retried transfer starts over
+	t.transferred -= l.complete
+	l.complete = 0
+	l.reported = 0
+	l.state = layerTransferring
+	t.emit(ProgressEvent{Event: EventLayerStarted, Digest: digest.String(), Size: l.size})
+	return true
+}
+
+// add records n more bytes of a layer
+func (t *progressTracker) add(digest v1.Hash, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if l, ok := t.byDigest[digest]; ok {
+		l.complete += n
+		t.transferred += n
+	}
+}
+
+// observe picks up blob existence checks and cross-repository mounts from registry
+// responses, which are otherwise invisible to the layer wrappers
+func (t *progressTracker) observe(req *http.Request, resp *http.Response) {
+	switch {
+	case req.Method == http.MethodHead && resp.StatusCode == http.StatusOK:
+		if m := blobPathPattern.FindStringSubmatch(req.URL.Path); m != nil {
+			if h, err := v1.NewHash(m[1]); err == nil {
+				t.setState(h, layerExists)
+			}
+		}
+	case req.Method == http.MethodPost && resp.StatusCode == http.StatusCreated:
+		if h, err := v1.NewHash(req.URL.Query().Get("mount")); err == nil {
+			t.setState(h, layerMounted)
+		}
+	}
+}
+
+// blobPathPattern matches the path of a blob request
+var blobPathPattern = regexp.MustCompile(`/blobs/(sha256:[a-f0-9]{64})$`)
+
+// observeProgress reports a registry response to the tracker in ctx, if any
+func observeProgress(req *http.Request, resp *http.Response) {
+	if t, ok := req.Context().Value(progressKey{}).(*progressTracker); ok {
+		t.observe(req, resp)
+	}
+}
+
+// summary returns the overall progress: finished layers, bytes done, total bytes,
+// and the throughput of bytes actually transferred
+func (t *progressTracker) summary() (finished int, complete, total int64, rate float64) {
+	for _, l := range t.layers {
+		total += l.size
+		if l.finished() {
+			finished++
+			complete += l.size
+		} else {
+			complete += l.complete
+		}
+	}
+	if elapsed := time.Since(t.start).Seconds(); elapsed > 0 {
+		rate = float64(t.transferred) / elapsed
+	}
+	return finished, complete, total, rate
+}
+
+// summaryLine describes the overall progress on one line
+func (t *progressTracker) summaryLine() string {
+	finished, complete, total, rate := t.summary()
+	line := fmt.Sprintf("%d of %d layers, %s / %s, %s/s", finished, len(t.layers), FormatBytes(complete), FormatBytes(total), FormatBytes(int64(rate)))
+	if remaining := total - complete; remaining > 0 && rate > 0 {
+		eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+		line += ", ETA " + eta.Round(time.Second).String()
+	}
+	return line
+}
+
+// emit writes a progress event when the tracker reports JSON events. The caller
+// holds t.mu.
+func (t *progressTracker) emit(event ProgressEvent) {
+	if t.mode != progressEvents {
+		return
+	}
+	event.Time = time.Now().UTC()
+	event.Direction = t.direction
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	progressOutput.Write(append(line, '\n'))
+}
+
+// progressManifestWritten reports the manifest of m written to target by the
+// transfer tracked in ctx, if any
+func progressManifestWritten(ctx context.Context, target string, m interface{ Digest() (v1.Hash, error) }) {
+	t, ok := ctx.Value(progressKey{}).(*progressTracker)
+	if !ok {
+		return
+	}
+	if digest, err := m.Digest(); err == nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.emit(ProgressEvent{Event: EventManifestWritten, Digest: digest.String(), Target: target})
+	}
+}
+
+// render draws the display once
+func (t *progressTracker) render() {
+	switch t.mode {
+	case progressLines:
+		t.mu.Lock()
+		line := t.summaryLine()
+		t.mu.Unlock()
+		logInfof("Progress: %s", line)
+		return
+	case progressEvents:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, l := range t.layers {
+			if l.state == layerTransferring && l.complete != l.reported {
+				l.reported = l.complete
+				t.emit(ProgressEvent{Event: EventLayerProgress, Digest: l.digest.String(), Size: l.size, Complete: l.complete})
+			}
+		}
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var b strings.Builder
+	if t.drawn > 0 {
+		// Move back to the first line of the previous frame
+		fmt.Fprintf(&b, "\x1b[%dA", t.drawn)
+	}
+	for _, l := range t.layers {
+		b.WriteString("\x1b[2K" + t.layerLine(l) + "\n")
+	}
+	b.WriteString("\x1b[2K" + t.summaryLine() + "\n")
+	t.drawn = len(t.layers) + 1
+	io.WriteString(progressOutput, b.String())
+}
+
+// layerLine describes one layer on the terminal display
+func (t *progressTracker) layerLine(l *layerProgress) string {
+	short := l.digest.Hex
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	switch l.state {
+	case layerExists:
+		return fmt.Sprintf("  %s  %-11s  %s", short, "exists", FormatBytes(l.size))
+	case layerMounted:
+		return fmt.Sprintf("  %s  %-11s  %s", short, "mounted", FormatBytes(l.size))
+	case layerTransferring:
+		return fmt.Sprintf("  %s  %-11s  %s / %s", short, t.direction, FormatBytes(l.complete), FormatBytes(l.size))
+	case layerDone:
+		return fmt.Sprintf("  %s  %-11s  %s", short, "done", FormatBytes(l.size))
+	default:
+		return fmt.Sprintf("  %s  %-11s  %s", short, "waiting", FormatBytes(l.size))
+	}
+}
+
+// wrap returns artifact with layers that report their transfer to t
+func (t *progressTracker) wrap(artifact remote.Taggable) remote.Taggable {
+	switch a := artifact.(type) {
+	case v1.ImageIndex:
+		return &progressIndex{idx: a, t: t}
+	case v1.Image:
+		return &progressImage{Image: a, t: t}
+	default:
+		return artifact
+	}
+}
+
+// progressIndex is an index whose images report layer transfers. It cannot embed
+// v1.ImageIndex, whose ImageIndex method would clash with the field name.
+type progressIndex struct {
+	idx v1.ImageIndex
+	t   *progressTracker
+}
+
+func (i *progressIndex) MediaType() (types.MediaType, error) { return i.idx.MediaType() }
+func (i *progressIndex) Digest() (v1.Hash, error)            { return i.idx.Digest() }
+func (i *progressIndex) Size() (int64, error)                { return i.idx.Size() }
+func (i *progressIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return i.idx.IndexManifest()
+}
+func (i *progressIndex) RawManifest() ([]byte, error) { return i.idx.RawManifest() }
+
+func (i *progressIndex) Image(h v1.Hash) (v1.Image, error) {
+	img, err := i.idx.Image(h)
+	if err != nil {
+		return nil, err
+	}
+	return &progressImage{Image: img, t: i.t}, nil
+}
+
+func (i *progressIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	idx, err := i.idx.ImageIndex(h)
+	if err != nil {
+		return nil, err
+	}
+	return &progressIndex{idx: idx, t: i.t}, nil
+}
+
+// indexWithLayers is an index that can return the non-manifest blobs it lists
+type indexWithLayers interface {
+	Layer(v1.Hash) (v1.Layer, error)
+}
+
+// Layer returns a blob listed in the index that is neither an image nor an index,
+// such as an artifact. remote.WriteIndex only copies such children when the index
+// has this method, so it is forwarded from the wrapped index.
+func (i *progressIndex) Layer(h v1.Hash) (v1.Layer, error) {
+	wl, ok := i.idx.(indexWithLayers)
+	if !ok {
+		return nil, fmt.Errorf("index does not provide blob %s", h)
+	}
+	return wl.Layer(h)
+}
+
+// progressImage is an image whose layers report their transfer
+type progressImage struct {
+	v1.Image
+	t *progressTracker
+}
+
+func (i *progressImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]v1.Layer, len(layers))
+	for j, l := range layers {
+		wrapped[j] = newProgressLayer(l, i.t)
+	}
+	return wrapped, nil
+}
+
+// ConfigLayer returns the config blob of the wrapped image, so that a config read
+// from a registry stays mountable
+func (i *progressImage) ConfigLayer() (v1.Layer, error) {
+	return partial.ConfigLayer(i.Image)
+}
+
+func (i *progressImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	l, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return newProgressLayer(l, i.t), nil
+}
+
+// progressLayer reports the bytes read from its compressed stream
+type progressLayer struct {
+	v1.Layer
+	t *progressTracker
+}
+
+// newProgressLayer wraps l to report its transfer to t. remote.Write only mounts a
+// layer from its source repository when it is a *remote.MountableLayer, so such
+// layers stay one, with the progress wrapper inside.
+func newProgressLayer(l v1.Layer, t *progressTracker) v1.Layer {
+	if ml, ok := l.(*remote.MountableLayer); ok {
+		return &remote.MountableLayer{Layer: &progressLayer{Layer: ml.Layer, t: t}, Reference: ml.Reference}
+	}
+	return &progressLayer{Layer: l, t: t}
+}
+
+func (l *progressLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	digest, err := l.Digest()
+	if err != nil || !l.t.begin(digest) {
+		return rc, nil
+	}
+	return &progressReader{ReadCloser: rc, t: l.t, digest: digest}, nil
+}
+
+// progressReader counts bytes as they are read and marks the layer done at EOF
+type progressReader struct {
+	io.ReadCloser
+	t      *progressTracker
+	digest v1.Hash
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.t.add(r.digest, int64(n))
+	if err == io.EOF {
+		r.t.setState(r.digest, layerDone)
+	}
+	return n, err
+}
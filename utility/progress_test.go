@@ -0,0 +1,334 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// useProgressLog sends status and progress output to a buffer for the duration of a test
+func useProgressLog(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	l, _ := NewLogger(&buf, LogFormatText, level)
+	useLogger(t, l)
+
+	oldOutput := progressOutput
+	progressOutput = &buf
+	t.Cleanup(func() { progressOutput = oldOutput })
+	return &buf
+}
+
+// layerStates returns the state of every layer known to the tracker in ctx
+func layerStates(t *testing.T, ctx context.Context) map[v1.Hash]layerState {
+	t.Helper()
+
+	tracker, ok := ctx.Value(progressKey{}).(*progressTracker)
+	if !ok {
+		t.Fatal("No progress tracker in context")
+	}
+	states := map[v1.Hash]layerState{}
+	for _, l := range tracker.layers {
+		states[l.digest] = l.state
+	}
+	return states
+}
+
+// TestProgressLayerStates tests that layers are reported as uploaded, or as existing
+// when the registry already has them
+func TestProgressLayerStates(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	layers, _ := img.Layers()
+
+	tests := []struct {
+		name string
+		dst  string
+		want layerState
+	}{
+		{name: "new registry uploads", dst: newTestRegistry(t) + "/app:v1", want: layerDone},
+		{name: "existing layers are skipped", dst: reg + "/app:v2", want: layerExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := useProgressLog(t, slog.LevelInfo)
+
+			ctx, tracked, stop := trackProgress(context.Background(), progressUpload, img, true)
+			if err := remote.Write(mustParseRef(t, tt.dst), tracked.(v1.Image), remoteOptions(ctx, authn.DefaultKeychain)...); err != nil {
+				t.Fatalf("remote.Write() failed: %v", err)
+			}
+			stop()
+
+			states := layerStates(t, ctx)
+			for _, l := range layers {
+				digest, _ := l.Digest()
+				if states[digest] != tt.want {
+					t.Errorf("Layer %s state = %d, want %d", digest, states[digest], tt.want)
+				}
+			}
+			if !strings.Contains(buf.String(), "Progress: 2 of 2 layers") {
+				t.Errorf("Final progress line missing:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+// TestProgressKeepsMountableLayers tests that wrapping layers for progress keeps
+// them mountable, so remote.Write still mounts them from their source repository
+func TestProgressKeepsMountableLayers(t *testing.T) {
+	reg := &mountRegistry{
+		handler:    registry.New(registry.Logger(log.New(io.Discard, "", 0))),
+		allowMount: true,
+		mounted:    map[string]bool{},
+	}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	pushRandomImage(t, host+"/staging/app:v1")
+	img := remoteImage(t, host+"/staging/app:v1")
+	useProgressLog(t, slog.LevelInfo)
+
+	ctx, tracked, stop := trackProgress(context.Background(), progressUpload, img, true)
+	layers, err := tracked.(v1.Image).Layers()
+	if err != nil {
+		t.Fatalf("Failed to read layers: %v", err)
+	}
+	for _, l := range layers {
+		ml, ok := l.(*remote.MountableLayer)
+		if !ok {
+			t.Fatalf("Wrapped layer is %T, want *remote.MountableLayer", l)
+		}
+		if _, ok := ml.Layer.(*progressLayer); !ok {
+			t.Errorf("Mountable layer wraps %T, want *progressLayer", ml.Layer)
+		}
+	}
+
+	if err := remote.Write(mustParseRef(t, host+"/prod/app:v1"), tracked.(v1.Image), remoteOptions(ctx, authn.DefaultKeychain)...); err != nil {
+		t.Fatalf("remote.Write() failed: %v", err)
+	}
+	stop()
+
+	states := layerStates(t, ctx)
+	for _, l := range layers {
+		digest, _ := l.Digest()
+		if !reg.mounted[digest.String()] || states[digest] != layerMounted {
+			t.Errorf("Layer %s was not mounted (state %d)", digest, states[digest])
+		}
+	}
+}
+
+// TestProgressObserve tests that blob checks and mounts seen by the transport
+// update layer states
+func TestProgressObserve(t *testing.T) {
+	digest := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+		want   layerState
+	}{
+		{name: "blob exists", method: http.MethodHead, url: "/v2/app/blobs/" + digest.String(), status: http.StatusOK, want: layerExists},
+		{name: "blob missing", method: http.MethodHead, url: "/v2/app/blobs/" + digest.String(), status: http.StatusNotFound, want: layerWaiting},
+		{name: "blob mounted", method: http.MethodPost, url: "/v2/app/blobs/uploads/?from=base&mount=" + digest.String(), status: http.StatusCreated, want: layerMounted},
+		{name: "mount refused", method: http.MethodPost, url: "/v2/app/blobs/uploads/?from=base&mount=" + digest.String(), status: http.StatusAccepted, want: layerWaiting},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newProgressTracker(progressUpload, []v1.Descriptor{{Digest: digest, Size: 10}})
+			req := httptest.NewRequest(tt.method, "http://registry.example"+tt.url, nil)
+			tracker.observe(req, &http.Response{StatusCode: tt.status})
+
+			if got := tracker.byDigest[digest].state; got != tt.want {
+				t.Errorf("Layer state = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestProgressQuiet tests that no progress is tracked or shown below info level
+func TestProgressQuiet(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/app:v1")
+	buf := useProgressLog(t, slog.LevelWarn)
+
+	outputPath := filepath.Join(t.TempDir(), "image.tar")
+	if err := PullImage(context.Background(), reg+"/app:v1", outputPath, "", "default", PullOptions{}); err != nil {
+		t.Fatalf("PullImage() failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Quiet pull printed output:\n%s", buf.String())
+	}
+}
+
+// TestPullImageProgress tests that pull reports download progress
+func TestPullImageProgress(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/app:v1")
+	buf := useProgressLog(t, slog.LevelInfo)
+
+	outputPath := filepath.Join(t.TempDir(), "image.tar")
+	if err := PullImage(context.Background(), reg+"/app:v1", outputPath, "", "default", PullOptions{}); err != nil {
+		t.Fatalf("PullImage() failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Progress: 2 of 2 layers") {
+		t.Errorf("Download progress missing:\n%s", buf.String())
+	}
+}
+
+// TestProgressTerminalRender tests the redrawn terminal display
+func TestProgressTerminalRender(t *testing.T) {
+	var buf bytes.Buffer
+	oldOutput := progressOutput
+	progressOutput = &buf
+	t.Cleanup(func() { progressOutput = oldOutput })
+
+	a := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+	b := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)}
+	tracker := newProgressTracker(progressUpload, []v1.Descriptor{{Digest: a, Size: 4096}, {Digest: b, Size: 2048}})
+	tracker.mode = progressTerminal
+
+	tracker.setState(a, layerExists)
+	tracker.begin(b)
+	tracker.add(b, 1024)
+	tracker.render()
+	first := buf.String()
+	for _, want := range []string{"aaaaaaaaaaaa  exists", "bbbbbbbbbbbb  uploading    1.0 KiB / 2.0 KiB", "1 of 2 layers, 5.0 KiB / 6.0 KiB"} {
+		if !strings.Contains(first, want) {
+			t.Errorf("First frame missing %q:\n%q", want, first)
+		}
+	}
+
+	buf.Reset()
+	tracker.render()
+	if !strings.HasPrefix(buf.String(), "\x1b[3A") {
+		t.Errorf("Second frame does not move back over the first: %q", buf.String())
+	}
+}
+
+// TestProgressEvents tests the JSON progress event stream of a push, which is emitted
+// even when status messages are quiet
+func TestProgressEvents(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	sourcePath := filepath.Join(t.TempDir(), "image.tar")
+	if err := tarball.WriteToFile(sourcePath, mustParseRef(t, reg+"/app:v1"), img); err != nil {
+		t.Fatalf("Failed to write test tarball: %v", err)
+	}
+
+	buf := useProgressLog(t, slog.LevelWarn)
+	if err := SetProgressFormat(ProgressFormatJSON); err != nil {
+		t.Fatalf("SetProgressFormat() failed: %v", err)
+	}
+	t.Cleanup(func() { SetProgressFormat(ProgressFormatText) })
+
+	dst := newTestRegistry(t) + "/app:v1"
+	if err := PushImage(context.Background(), dst, sourcePath, "", "default", PushOptions{}); err != nil {
+		t.Fatalf("PushImage() failed: %v", err)
+	}
+
+	var events []ProgressEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e ProgressEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Progress line is not a JSON event: %q", line)
+		}
+		if e.Direction != progressUpload {
+			t.Errorf("Event direction = %q, want %q", e.Direction, progressUpload)
+		}
+		events = append(events, e)
+	}
+
+	started, completed := map[string]bool{}, map[string]bool{}
+	for _, e := range events {
+		switch e.Event {
+		case EventLayerStarted:
+			started[e.Digest] = true
+		case EventLayerCompleted:
+			if !started[e.Digest] || e.Status != "done" || e.Complete != e.Size {
+				t.Errorf("Unexpected layer_completed event: %+v", e)
+			}
+			completed[e.Digest] = true
+		}
+	}
+	layers, _ := img.Layers()
+	for _, l := range layers {
+		digest, _ := l.Digest()
+		if !completed[digest.String()] {
+			t.Errorf("No layer_completed event for %s", digest)
+		}
+	}
+
+	digest, _ := img.Digest()
+	if n := len(events); n < 2 ||
+		events[n-2].Event != EventManifestWritten || events[n-2].Target != dst || events[n-2].Digest != digest.String() ||
+		events[n-1].Event != EventTransferCompleted {
+		t.Errorf("Stream does not end with manifest_written and transfer_completed: %+v", events)
+	}
+}
+
+// TestSetProgressFormat tests progress format validation
+func TestSetProgressFormat(t *testing.T) {
+	t.Cleanup(func() { SetProgressFormat(ProgressFormatText) })
+
+	for _, format := range []string{"", ProgressFormatText, ProgressFormatJSON} {
+		if err := SetProgressFormat(format); err != nil {
+			t.Errorf("SetProgressFormat(%q) failed: %v", format, err)
+		}
+	}
+	if err := SetProgressFormat("xml"); err == nil {
+		t.Error("SetProgressFormat(\"xml\") succeeded, want error")
+	}
+}
+
+// TestProgressCopiesIndexArtifacts tests copying an index that lists a blob which is
+// neither an image nor an index while progress is tracked
+func TestProgressCopiesIndexArtifacts(t *testing.T) {
+	reg := newTestRegistry(t)
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to create random image: %v", err)
+	}
+	artifact := static.NewLayer([]byte(`{"kind":"sbom"}`), "application/vnd.example.sbom+json")
+	artifactDigest, _ := artifact.Digest()
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: img},
+		mutate.IndexAddendum{Add: artifact},
+	)
+	if err := remote.WriteIndex(mustParseRef(t, reg+"/app:v1"), idx); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+	useProgressLog(t, slog.LevelInfo)
+
+	dst := newTestRegistry(t)
+	if err := CopyImage(context.Background(), reg+"/app:v1", dst+"/app:v1", "", "", "default", "default", true, CopyOptions{}); err != nil {
+		t.Fatalf("CopyImage() with progress failed: %v", err)
+	}
+	if _, err := remote.Layer(mustParseRef(t, dst+"/app@"+artifactDigest.String()).(name.Digest)); err != nil {
+		t.Errorf("Artifact blob was not copied: %v", err)
+	}
+}
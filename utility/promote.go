@@ -0,0 +1,164 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// promotionRecordType is the artifact type of the referrer recording a promotion.
+// It is stored as the config media type, which the referrers API reports as the
+// artifact type.
+const promotionRecordType types.MediaType = "application/vnd.io.github.btwseeu78.repo-lister.promotion.v1+json"
+
+// Annotations set on a promotion record
+const (
+	promotedFromAnnotation = "io.github.btwseeu78.repo-lister.promoted-from"
+	promotedToAnnotation   = "io.github.btwseeu78.repo-lister.promoted-to"
+)
+
+// PromoteOptions holds the guardrails applied by PromoteImage.
+type PromoteOptions struct {
+	// AllowOverwrite permits replacing a destination tag that already exists
+	AllowOverwrite bool
+	// RequireDigest rejects sources referenced by tag instead of resolving them
+	RequireDigest bool
+	// Verify requires a valid cosign signature on the source digest before promoting
+	Verify VerifyOptions
+	// Record attaches a referrer to the promoted digest naming the source it was
+	// promoted from. The promoted manifest itself is not changed.
+	Record bool
+	// CacheDir serves layers from, and stores downloaded layers in, this directory
+	CacheDir string
+	// DryRun checks the guardrails and reports what would be transferred without
+	// writing anything
+	DryRun bool
+	// CheckPush makes a dry run confirm push access by starting and cancelling a
+	// blob upload at the destination
+	CheckPush bool
+}
+
+// PromoteImage copies an image from one environment's repository to another's with
+// guardrails: the source is pinned to a digest so a tag moving mid-promotion cannot
+// change what is promoted, an existing destination tag is never replaced unless
+// AllowOverwrite is set, and the promotion can be recorded as a referrer of the
+// promoted digest. The promoted image always keeps the source digest, so promoting
+// the digest a destination tag already points to succeeds without AllowOverwrite.
+func PromoteImage(
+	ctx context.Context,
+	sourceImage string,
+	destImage string,
+	sourceSecret string,
+	destSecret string,
+	sourceNamespace string,
+	destNamespace string,
+	showProgress bool,
+	opts PromoteOptions,
+) error {
+	srcRef, err := name.ParseReference(sourceImage)
+	if err != nil {
+		return fmt.Errorf("failed to parse source image reference '%s': %w", sourceImage, err)
+	}
+
+	dstRef, err := name.ParseReference(destImage)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination image reference '%s': %w", destImage, err)
+	}
+	if _, ok := dstRef.(name.Tag); !ok {
+		return fmt.Errorf("destination '%s' must be a tag, not a digest", destImage)
+	}
+
+	// Create source keychain
+	sourceKC, err := CreateKeychain(ctx, sourceNamespace, sourceSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create source keychain: %w", err)
+	}
+
+	// Create destination keychain
+	destKC, err := CreateKeychain(ctx, destNamespace, destSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create destination keychain: %w", err)
+	}
+
+	// Pin the source to a digest
+	srcDigest, ok := srcRef.(name.Digest)
+	if !ok {
+		if opts.RequireDigest {
+			return fmt.Errorf("source '%s' must be referenced by digest (e.g. %s@sha256:...)", sourceImage, srcRef.Context())
+		}
+		desc, err := remote.Head(srcRef, remoteOptions(ctx, sourceKC)...)
+		if err != nil {
+			return HandleRegistryError(err, "resolving source", sourceImage)
+		}
+		srcDigest = srcRef.Context().Digest(desc.Digest.String())
+		logInfof("Resolved %s to %s", sourceImage, desc.Digest)
+	}
+
+	// Refuse to replace what is already running in the destination environment
+	desc, err := remote.Head(dstRef, remoteOptions(ctx, destKC)...)
+	var terr *transport.Error
+	switch {
+	case err == nil && desc.Digest.String() == srcDigest.DigestStr():
+		logInfof("%s already points to %s", destImage, desc.Digest)
+	case err == nil && !opts.AllowOverwrite:
+		return fmt.Errorf("destination tag '%s' already exists (%s); use --allow-overwrite to replace it", destImage, desc.Digest)
+	case err == nil:
+		logWarnf("Overwriting %s, which currently points to %s", destImage, desc.Digest)
+	case errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound:
+		// The destination tag does not exist yet
+	default:
+		return HandleRegistryError(err, "checking destination", destImage)
+	}
+
+	copyOpts := CopyOptions{
+		Verify:    opts.Verify,
+		CacheDir:  opts.CacheDir,
+		DryRun:    opts.DryRun,
+		CheckPush: opts.CheckPush,
+	}
+	err = CopyImage(ctx, srcDigest.String(), destImage, sourceSecret, destSecret, sourceNamespace, destNamespace, showProgress, copyOpts)
+	if err != nil || !opts.Record || opts.DryRun {
+		return err
+	}
+
+	return recordPromotion(ctx, srcDigest, dstRef, destKC)
+}
+
+// recordPromotion attaches a promotion record to the image dst points to. The record
+// is an empty artifact whose subject is the promoted digest, so it is listed by the
+// referrers API (or its fallback tag) without changing the promoted manifest.
+func recordPromotion(ctx context.Context, src name.Digest, dst name.Reference, kc authn.Keychain) error {
+	desc, err := remote.Head(dst, remoteOptions(ctx, kc)...)
+	if err != nil {
+		return HandleRegistryError(err, "resolving promoted image", dst.String())
+	}
+
+	record := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	record = mutate.ConfigMediaType(record, promotionRecordType)
+	record = mutate.Annotations(record, map[string]string{
+		promotedFromAnnotation: src.String(),
+		promotedToAnnotation:   dst.String(),
+	}).(v1.Image)
+	record = mutate.Subject(record, *desc).(v1.Image)
+
+	digest, err := record.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to compute promotion record digest: %w", err)
+	}
+	recordRef := dst.Context().Digest(digest.String())
+	if err := remote.Write(recordRef, record, remoteOptions(ctx, kc)...); err != nil {
+		return HandleRegistryError(err, "writing promotion record", recordRef.String())
+	}
+	logInfof("Recorded promotion from %s as %s", src, recordRef)
+	return nil
+}
@@ -0,0 +1,126 @@
+package utility
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// TestPromoteImage tests the promotion guardrails against a test registry
+func TestPromoteImage(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/staging/app:v1")
+	digest, _ := img.Digest()
+	pushRandomImage(t, reg+"/prod/app:existing")
+
+	tests := []struct {
+		name        string
+		source      string
+		dest        string
+		opts        PromoteOptions
+		errContains string
+	}{
+		{
+			name:   "tag resolved to digest",
+			source: reg + "/staging/app:v1",
+			dest:   reg + "/prod/app:v1",
+			opts:   PromoteOptions{Record: true},
+		},
+		{
+			name:   "by digest",
+			source: reg + "/staging/app@" + digest.String(),
+			dest:   reg + "/prod/app:v1-digest",
+			opts:   PromoteOptions{RequireDigest: true},
+		},
+		{
+			name:        "tag with require digest",
+			source:      reg + "/staging/app:v1",
+			dest:        reg + "/prod/app:v1-required",
+			opts:        PromoteOptions{RequireDigest: true},
+			errContains: "must be referenced by digest",
+		},
+		{
+			name:   "same digest again",
+			source: reg + "/staging/app@" + digest.String(),
+			dest:   reg + "/prod/app:v1",
+			opts:   PromoteOptions{Record: true},
+		},
+		{
+			name:        "existing destination",
+			source:      reg + "/staging/app:v1",
+			dest:        reg + "/prod/app:existing",
+			errContains: "already exists",
+		},
+		{
+			name:   "existing destination with overwrite",
+			source: reg + "/staging/app:v1",
+			dest:   reg + "/prod/app:existing",
+			opts:   PromoteOptions{AllowOverwrite: true},
+		},
+		{
+			name:        "destination by digest",
+			source:      reg + "/staging/app:v1",
+			dest:        reg + "/prod/app@" + digest.String(),
+			errContains: "must be a tag",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := PromoteImage(context.Background(), tt.source, tt.dest, "", "", "default", "default", false, tt.opts)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("PromoteImage() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PromoteImage() failed: %v", err)
+			}
+
+			promoted := remoteImage(t, tt.dest)
+			promotedDigest, _ := promoted.Digest()
+			if promotedDigest != digest {
+				t.Errorf("Promoted digest = %s, want unchanged %s", promotedDigest, digest)
+			}
+
+			dstRef := mustParseRef(t, tt.dest)
+			index, err := remote.Referrers(dstRef.Context().Digest(digest.String()), remote.WithFilter("artifactType", string(promotionRecordType)))
+			if err != nil {
+				t.Fatalf("Failed to list referrers: %v", err)
+			}
+			manifest, _ := index.IndexManifest()
+			if !tt.opts.Record {
+				return
+			}
+			if len(manifest.Manifests) != 1 {
+				t.Fatalf("Found %d promotion records, want 1", len(manifest.Manifests))
+			}
+			record, err := remoteImage(t, dstRef.Context().Digest(manifest.Manifests[0].Digest.String()).String()).Manifest()
+			if err != nil {
+				t.Fatalf("Failed to read promotion record: %v", err)
+			}
+			if got, want := record.Annotations[promotedFromAnnotation], reg+"/staging/app@"+digest.String(); got != want {
+				t.Errorf("%s = %q, want %q", promotedFromAnnotation, got, want)
+			}
+		})
+	}
+}
+
+// TestPromoteImageDryRun tests that a dry-run promotion still applies the guardrails
+func TestPromoteImageDryRun(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/staging/app:v1")
+	pushRandomImage(t, reg+"/prod/app:v1")
+
+	err := PromoteImage(context.Background(), reg+"/staging/app:v1", reg+"/prod/app:v1", "", "", "default", "default", false, PromoteOptions{DryRun: true})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("PromoteImage() error = %v, want the overwrite guardrail to apply", err)
+	}
+
+	if err := PromoteImage(context.Background(), reg+"/staging/app:v1", reg+"/prod/app:v2", "", "", "default", "default", false, PromoteOptions{DryRun: true}); err != nil {
+		t.Fatalf("PromoteImage() dry run failed: %v", err)
+	}
+}
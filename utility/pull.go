@@ -1,17 +1,48 @@
 package utility
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
 
-// PullImage pulls an image from a registry and saves it to a local tar file
-func PullImage(imageRef string, outputPath string, secretName string, namespace string) error {
+// PullOptions holds optional behaviour for PullImage.
+type PullOptions struct {
+	// Verify requires a valid cosign signature on the image digest before pulling
+	Verify VerifyOptions
+	// CacheDir keeps downloaded layers by digest so later pulls reuse them. When
+	// empty, layers are kept in a directory next to the output until the pull
+	// succeeds, so re-running an interrupted pull only downloads missing layers.
+	CacheDir string
+	// ToDaemon loads the image into the local Docker daemon under its tag
+	ToDaemon bool
+	// Compress compresses the tar file with gzip or zstd while it is written
+	Compress string
+}
+
+// PullImage pulls an image from a registry and saves it to a local tar file, loads it
+// into the local Docker daemon, or both. An outputPath of "-" streams the tar file
+// to stdout.
+func PullImage(ctx context.Context, imageRef string, outputPath string, secretName string, namespace string, opts PullOptions) error {
+	if outputPath == "" && !opts.ToDaemon {
+		return fmt.Errorf("an output path or loading into the daemon is required")
+	}
+	if err := validateCompression(opts.Compress); err != nil {
+		return err
+	}
+
 	// Create keychain
-	kc, err := CreateKeychain(namespace, secretName)
+	kc, err := CreateKeychain(ctx, namespace, secretName)
 	if err != nil {
 		return fmt.Errorf("failed to create keychain: %w", err)
 	}
@@ -22,22 +53,176 @@ func PullImage(imageRef string, outputPath string, secretName string, namespace
 		return fmt.Errorf("failed to parse image reference '%s': %w", imageRef, err)
 	}
 
-	fmt.Printf("Pulling image %s...\n", imageRef)
+	logInfof("Pulling image %s...", imageRef)
 
-	// Fetch the image from registry
-	img, err := remote.Image(ref, remote.WithAuthFromKeychain(kc))
+	// Fetch the image from registry. The daemon runs images for its own platform, so
+	// pick that one from multi-arch indexes.
+	options := remoteOptions(ctx, kc)
+	if opts.ToDaemon {
+		options = append(options, remote.WithPlatform(daemonPlatform()))
+	}
+	desc, err := remote.Get(ref, options...)
 	if err != nil {
 		return HandleRegistryError(err, "pulling image", imageRef)
 	}
 
-	fmt.Printf("Saving image to %s...\n", outputPath)
+	if opts.Verify.Enabled() {
+		logInfof("Verifying signature of %s@%s...", ref.Context(), desc.Digest)
+		if err := VerifyImageSignature(ctx, ref.Context().Digest(desc.Digest.String()), kc, opts.Verify); err != nil {
+			return err
+		}
+	}
 
-	// Write image to tar file
-	err = tarball.WriteToFile(outputPath, ref, img)
+	img, err := desc.Image()
 	if err != nil {
-		return fmt.Errorf("failed to write image to tar file: %w", err)
+		return HandleRegistryError(err, "pulling image", imageRef)
+	}
+
+	cacheDir := opts.CacheDir
+	if cacheDir == "" && outputPath != "" && outputPath != stdioPath {
+		cacheDir = partialBlobDir(outputPath)
 	}
+	if cacheDir != "" {
+		blobs := newBlobCache(cacheDir)
+		if cached, total := countCachedLayers(img, blobs); cached > 0 {
+			logInfof("Reusing %d of %d layers from %s", cached, total, cacheDir)
+		}
+		img = cachedArtifact(img, cacheDir).(v1.Image)
+	}
+
+	ctx, tracked, stopProgress := trackProgress(ctx, progressDownload, img, true)
+	defer stopProgress()
+	img = tracked.(v1.Image)
+
+	if outputPath == stdioPath {
+		logInfof("Writing image to stdout...")
 
-	fmt.Printf("✓ Successfully pulled image to %s\n", outputPath)
+		if err := writeTarballStream(stdout, ref, img, opts.Compress); err != nil {
+			return HandleRegistryError(err, "writing image to", "stdout")
+		}
+		progressManifestWritten(ctx, "stdout", img)
+	} else if outputPath != "" {
+		logInfof("Saving image to %s...", outputPath)
+
+		report, err := writeTarballAtomic(outputPath, ref, img, opts.Compress)
+		if err != nil {
+			return HandleRegistryError(err, "writing image to", outputPath)
+		}
+		if err := WriteChecksumFile(outputPath, report.Checksum); err != nil {
+			return err
+		}
+		progressManifestWritten(ctx, outputPath, img)
+	}
+
+	if opts.ToDaemon {
+		tag := daemonTag(ref, desc.Digest)
+		logInfof("Loading image into the local daemon as %s...", tag)
+
+		if err := writeDaemonImage(ctx, tag, img); err != nil {
+			return err
+		}
+		progressManifestWritten(ctx, "daemon:"+tag.Name(), img)
+	}
+
+	stopProgress()
+
+	// The layers are saved now; only a user-chosen cache outlives the pull
+	if opts.CacheDir == "" && cacheDir != "" {
+		os.RemoveAll(cacheDir)
+	}
+
+	if outputPath == stdioPath {
+		logInfof("✓ Successfully pulled image to stdout")
+	} else if outputPath != "" {
+		logInfof("✓ Successfully pulled image to %s", outputPath)
+	}
+	if opts.ToDaemon {
+		logInfof("✓ Successfully loaded image into the local daemon as %s", daemonTag(ref, desc.Digest))
+	}
 	return nil
 }
+
+// writeTarballAtomic writes img as a tarball, compressed with compression, to a
+// temporary file next to outputPath, verifies it, and renames it into place, so
+// outputPath never holds a truncated or corrupt tar.
+func writeTarballAtomic(outputPath string, ref name.Reference, img v1.Image, compression string) (TarballReport, error) {
+	tmp, err := createPartialFile(outputPath)
+	if err != nil {
+		return TarballReport{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w, err := compressWriter(tmp, compression)
+	if err != nil {
+		tmp.Close()
+		return TarballReport{}, err
+	}
+	if err := tarball.Write(ref, img, w); err != nil {
+		tmp.Close()
+		return TarballReport{}, err
+	}
+	if err := w.Close(); err != nil {
+		tmp.Close()
+		return TarballReport{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return TarballReport{}, err
+	}
+
+	report, err := VerifyTarball(tmp.Name())
+	if err != nil {
+		return report, fmt.Errorf("integrity check failed: %w", err)
+	}
+	return report, os.Rename(tmp.Name(), outputPath)
+}
+
+// createPartialFile creates an empty temporary file next to outPath to be renamed
+// over it once complete. Unlike os.CreateTemp, which always uses mode 0600, the file
+// gets the mode of the output it replaces, or 0666 less the umask for a new output,
+// so outputs stay as readable as a directly created file would be.
+func createPartialFile(outPath string) (*os.File, error) {
+	perm := os.FileMode(0666)
+	info, err := os.Stat(outPath)
+	if err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	prefix := filepath.Join(filepath.Dir(outPath), "."+filepath.Base(outPath)+".")
+	for {
+		f, err := os.OpenFile(prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+".partial", os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			// An explicit chmod is not reduced by the umask, so a replaced file keeps its mode
+			if err := f.Chmod(perm); err != nil {
+				f.Close()
+				os.Remove(f.Name())
+				return nil, err
+			}
+		}
+		return f, nil
+	}
+}
+
+// partialBlobDir returns the directory that holds the layers of an unfinished pull to outputPath
+func partialBlobDir(outputPath string) string {
+	return filepath.Join(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".blobs")
+}
+
+// countCachedLayers returns how many of img's layers are already in blobs
+func countCachedLayers(img v1.Image, blobs *blobCache) (cached, total int) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, 0
+	}
+	for _, layer := range manifest.Layers {
+		if blobs.Has(layer.Digest) {
+			cached++
+		}
+	}
+	return cached, len(manifest.Layers)
+}
@@ -1,9 +1,19 @@
 package utility
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
 
 // TestPullImageValidation tests basic validation of PullImage parameters
@@ -54,7 +64,7 @@ func TestPullImageValidation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := PullImage(tt.imageRef, tt.outputPath, tt.secretName, tt.namespace)
+			err := PullImage(context.Background(), tt.imageRef, tt.outputPath, tt.secretName, tt.namespace, PullOptions{})
 
 			if tt.wantErr {
 				if err == nil {
@@ -105,7 +115,7 @@ func TestPullImageOutputPath(t *testing.T) {
 				}
 			}
 
-			err := PullImage("nginx:latest", tt.outputPath, "test-secret", "default")
+			err := PullImage(context.Background(), "nginx:latest", tt.outputPath, "test-secret", "default", PullOptions{})
 
 			if tt.wantErr && err == nil {
 				t.Errorf("Expected error for test case: %s", tt.name)
@@ -120,3 +130,165 @@ func TestPullImageOutputPath(t *testing.T) {
 		})
 	}
 }
+
+// TestPullImageCancelled tests that a cancelled pull leaves no output file behind
+func TestPullImageCancelled(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/app:v1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	outputPath := filepath.Join(t.TempDir(), "image.tar")
+	err := PullImage(ctx, reg+"/app:v1", outputPath, "", "default", PullOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("PullImage() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Output file %s exists after cancelled pull", outputPath)
+	}
+}
+
+// failingBlobRegistry fails downloads of one blob until allowed, simulating a
+// connection dropped in the middle of a pull
+type failingBlobRegistry struct {
+	handler   http.Handler
+	failBlob  string
+	failing   bool
+	blobFetch map[string]int
+}
+
+func (f *failingBlobRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/blobs/") {
+		digest := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		f.blobFetch[digest]++
+		if f.failing && digest == f.failBlob {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	f.handler.ServeHTTP(w, r)
+}
+
+// TestPullImageResume tests that an interrupted pull leaves no output and that
+// re-running it only downloads the layers that are missing
+func TestPullImageResume(t *testing.T) {
+	reg := &failingBlobRegistry{
+		handler:   registry.New(registry.Logger(log.New(io.Discard, "", 0))),
+		blobFetch: map[string]int{},
+	}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img := pushRandomImage(t, host+"/app:v1")
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Failed to read layers: %v", err)
+	}
+	first, _ := layers[0].Digest()
+	last, _ := layers[len(layers)-1].Digest()
+
+	outputPath := filepath.Join(t.TempDir(), "image.tar")
+
+	// The last layer fails, so the pull is interrupted after the first one
+	reg.failBlob, reg.failing = last.String(), true
+	if err := PullImage(context.Background(), host+"/app:v1", outputPath, "", "default", PullOptions{}); err == nil {
+		t.Fatal("PullImage() succeeded while a layer download was failing")
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Output file %s exists after interrupted pull", outputPath)
+	}
+
+	reg.failing = false
+	if err := PullImage(context.Background(), host+"/app:v1", outputPath, "", "default", PullOptions{}); err != nil {
+		t.Fatalf("Resumed PullImage() failed: %v", err)
+	}
+
+	if got := reg.blobFetch[first.String()]; got != 1 {
+		t.Errorf("First layer downloaded %d times, want 1", got)
+	}
+	if _, err := tarball.ImageFromPath(outputPath, nil); err != nil {
+		t.Errorf("Output is not a valid image tarball: %v", err)
+	}
+	if _, err := os.Stat(checksumFile(outputPath)); err != nil {
+		t.Errorf("Checksum file not written after pull: %v", err)
+	}
+	if _, err := os.Stat(partialBlobDir(outputPath)); !os.IsNotExist(err) {
+		t.Errorf("Partial layer directory left behind after successful pull")
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(outputPath), ".*.partial"))
+	if len(leftovers) != 0 {
+		t.Errorf("Temporary files left behind: %v", leftovers)
+	}
+}
+
+// TestPullImageCacheDir tests that layers in a cache directory are shared between pulls
+func TestPullImageCacheDir(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	cacheDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	for _, out := range []string{"first.tar", "second.tar"} {
+		if err := PullImage(context.Background(), reg+"/app:v1", filepath.Join(outputDir, out), "", "default", PullOptions{CacheDir: cacheDir}); err != nil {
+			t.Fatalf("PullImage() to %s failed: %v", out, err)
+		}
+	}
+
+	layers, _ := img.Layers()
+	blobs := newBlobCache(cacheDir)
+	for _, l := range layers {
+		digest, _ := l.Digest()
+		if !blobs.Has(digest) {
+			t.Errorf("Layer %s missing from cache directory", digest)
+		}
+	}
+}
+
+// TestPullImageFileMode tests that a pulled tarball gets the mode of a directly
+// created file, or keeps the mode of the file it replaces
+func TestPullImageFileMode(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushRandomImage(t, reg+"/app:v1")
+
+	dir := t.TempDir()
+	probe, err := os.Create(filepath.Join(dir, "probe"))
+	if err != nil {
+		t.Fatalf("Failed to create probe file: %v", err)
+	}
+	probe.Close()
+	probeInfo, _ := os.Stat(probe.Name())
+
+	tests := []struct {
+		name     string
+		existing os.FileMode
+		want     os.FileMode
+	}{
+		{name: "new output", want: probeInfo.Mode().Perm()},
+		{name: "replaced output", existing: 0640, want: 0640},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "image.tar")
+			if tt.existing != 0 {
+				if err := os.WriteFile(outputPath, nil, tt.existing); err != nil {
+					t.Fatalf("Failed to create existing output: %v", err)
+				}
+				os.Chmod(outputPath, tt.existing)
+			}
+
+			if err := PullImage(context.Background(), reg+"/app:v1", outputPath, "", "default", PullOptions{}); err != nil {
+				t.Fatalf("PullImage() failed: %v", err)
+			}
+			info, err := os.Stat(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to stat output: %v", err)
+			}
+			if info.Mode().Perm() != tt.want {
+				t.Errorf("Output mode = %v, want %v", info.Mode().Perm(), tt.want)
+			}
+		})
+	}
+}
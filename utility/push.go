@@ -1,17 +1,50 @@
 package utility
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
 
-// PushImage pushes an image from a local tar file to a registry
-func PushImage(imageRef string, sourcePath string, secretName string, namespace string) error {
+// PushOptions holds optional behaviour for PushImage.
+type PushOptions struct {
+	// FromDaemon reads this image from the local Docker or Podman daemon instead of a tar file
+	FromDaemon string
+	// SpoolDir is where a tar file read from stdin is stored while it is pushed;
+	// os.TempDir when empty
+	SpoolDir string
+	// DryRun reports which blobs would be uploaded without writing anything
+	DryRun bool
+	// CheckPush makes a dry run confirm push access by starting and cancelling a
+	// blob upload at the destination
+	CheckPush bool
+	// NoClobber refuses to move an existing tag to a different digest
+	NoClobber bool
+	// NoClobberPattern limits NoClobber to tags matching this regex, and turns it on
+	// when set on its own
+	NoClobberPattern string
+}
+
+// PushImage pushes an image from a local tar file, or from the local daemon, to a registry.
+// A sourcePath of "-" reads the tar file from stdin.
+func PushImage(ctx context.Context, imageRef string, sourcePath string, secretName string, namespace string, opts PushOptions) error {
+	if (sourcePath == "") == (opts.FromDaemon == "") {
+		return fmt.Errorf("exactly one of a source tar file or a daemon image must be given")
+	}
+
+	clobber, err := newClobberPolicy(opts.NoClobber, opts.NoClobberPattern)
+	if err != nil {
+		return err
+	}
+
 	// Create keychain
-	kc, err := CreateKeychain(namespace, secretName)
+	kc, err := CreateKeychain(ctx, namespace, secretName)
 	if err != nil {
 		return fmt.Errorf("failed to create keychain: %w", err)
 	}
@@ -22,22 +55,69 @@ func PushImage(imageRef string, sourcePath string, secretName string, namespace
 		return fmt.Errorf("failed to parse image reference '%s': %w", imageRef, err)
 	}
 
-	fmt.Printf("Loading image from %s...\n", sourcePath)
+	var img v1.Image
+	if opts.FromDaemon != "" {
+		logInfof("Loading image %s from the local daemon...", opts.FromDaemon)
 
-	// Load image from tar file
-	img, err := tarball.ImageFromPath(sourcePath, nil)
-	if err != nil {
-		return fmt.Errorf("failed to load image from tar file: %w", err)
+		img, err = loadDaemonImage(ctx, opts.FromDaemon)
+		if err != nil {
+			return err
+		}
+	} else {
+		if sourcePath == stdioPath {
+			logInfof("Reading image from stdin...")
+
+			// tarball.Image reads the archive once per layer, so keep a copy of the stream
+			sourcePath, err = spoolStdin(ctx, opts.SpoolDir)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(sourcePath)
+		} else {
+			logInfof("Loading image from %s...", sourcePath)
+		}
+
+		// Load image from tar file, decompressing gzip or zstd archives as they are read
+		img, err = tarball.Image(func() (io.ReadCloser, error) {
+			return openArchive(sourcePath)
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to load image from tar file: %w", err)
+		}
 	}
 
-	fmt.Printf("Pushing image to %s...\n", imageRef)
+	if clobber.protects(ref) {
+		digest, err := img.Digest()
+		if err != nil {
+			return fmt.Errorf("failed to compute image digest: %w", err)
+		}
+		if err := clobber.check(ctx, ref, digest, kc); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		plan, err := planTransfer(ctx, img, ref, kc, nil, opts.CheckPush)
+		if err != nil {
+			return err
+		}
+		logPlan(plan)
+		return nil
+	}
+
+	logInfof("Pushing image to %s...", imageRef)
 
 	// Push image to registry
-	err = remote.Write(ref, img, remote.WithAuthFromKeychain(kc))
+	ctx, tracked, stopProgress := trackProgress(ctx, progressUpload, img, true)
+	err = remote.Write(ref, tracked.(v1.Image), remoteOptions(ctx, kc)...)
+	if err == nil {
+		progressManifestWritten(ctx, imageRef, img)
+	}
+	stopProgress()
 	if err != nil {
 		return HandleRegistryError(err, "pushing image to", imageRef)
 	}
 
-	fmt.Printf("✓ Successfully pushed image to %s\n", imageRef)
+	logInfof("✓ Successfully pushed image to %s", imageRef)
 	return nil
 }
@@ -1,6 +1,7 @@
 package utility
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -84,7 +85,7 @@ func TestPushImageValidation(t *testing.T) {
 				defer func() { _ = os.Remove(tt.sourcePath) }()
 			}
 
-			err := PushImage(tt.imageRef, tt.sourcePath, tt.secretName, tt.namespace)
+			err := PushImage(context.Background(), tt.imageRef, tt.sourcePath, tt.secretName, tt.namespace, PushOptions{})
 
 			if tt.wantErr {
 				if err == nil {
@@ -144,7 +145,7 @@ func TestPushImageSourceFile(t *testing.T) {
 				defer func() { _ = os.Remove(sourcePath) }()
 			}
 
-			err := PushImage("nginx:test", sourcePath, "test-secret", "default")
+			err := PushImage(context.Background(), "nginx:test", sourcePath, "test-secret", "default", PushOptions{})
 
 			if tt.expectErr && err == nil {
 				t.Errorf("Expected error for test case: %s", tt.name)
@@ -0,0 +1,337 @@
+package utility
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Standard OCI annotations naming the base image an image was built on
+const (
+	baseNameAnnotation   = "org.opencontainers.image.base.name"
+	baseDigestAnnotation = "org.opencontainers.image.base.digest"
+)
+
+// RebaseOptions holds optional behaviour for RebaseImage.
+type RebaseOptions struct {
+	// Destination is where the rebased image is pushed; the image itself by default
+	Destination string
+	// DryRun checks that the image is based on the old base without pushing anything
+	DryRun bool
+}
+
+// RebaseImage replaces the layers of oldBase at the bottom of an image with the
+// layers of newBase and pushes the result. The image must start with exactly the
+// layers of oldBase. Layers above the base, the image's runtime config and its
+// history are kept; the platform comes from the new base. For a multi-arch image,
+// every platform is rebased onto the matching platform of the bases.
+func RebaseImage(
+	ctx context.Context,
+	imageRef string,
+	oldBase string,
+	newBase string,
+	secretName string,
+	namespace string,
+	baseSecret string,
+	baseNamespace string,
+	opts RebaseOptions,
+) error {
+	destImage := opts.Destination
+	if destImage == "" {
+		destImage = imageRef
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference '%s': %w", imageRef, err)
+	}
+	oldRef, err := name.ParseReference(oldBase)
+	if err != nil {
+		return fmt.Errorf("failed to parse old base reference '%s': %w", oldBase, err)
+	}
+	newRef, err := name.ParseReference(newBase)
+	if err != nil {
+		return fmt.Errorf("failed to parse new base reference '%s': %w", newBase, err)
+	}
+	dstRef, err := name.ParseReference(destImage)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination reference '%s': %w", destImage, err)
+	}
+
+	// Create the image keychain, and one keychain for both bases since they
+	// share a secret
+	kc, err := CreateKeychain(ctx, namespace, secretName)
+	if err != nil {
+		return fmt.Errorf("failed to create keychain: %w", err)
+	}
+	baseKC, err := CreateKeychain(ctx, baseNamespace, baseSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create base keychain: %w", err)
+	}
+
+	logInfof("Fetching %s, old base %s and new base %s...", imageRef, oldBase, newBase)
+	desc, err := remote.Get(ref, remoteOptions(ctx, kc)...)
+	if err != nil {
+		return HandleRegistryError(err, "fetching image", imageRef)
+	}
+	oldDesc, err := remote.Get(oldRef, remoteOptions(ctx, baseKC)...)
+	if err != nil {
+		return HandleRegistryError(err, "fetching old base", oldBase)
+	}
+	newDesc, err := remote.Get(newRef, remoteOptions(ctx, baseKC)...)
+	if err != nil {
+		return HandleRegistryError(err, "fetching new base", newBase)
+	}
+
+	rebase := func(img v1.Image, _ v1.Descriptor) (v1.Image, error) {
+		return rebasePlatformImage(img, oldDesc, newDesc, newRef)
+	}
+
+	var rebased remote.Taggable
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return fmt.Errorf("failed to process image index: %w", err)
+		}
+		if rebased, err = mapIndexImages(idx, rebase); err != nil {
+			return err
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return fmt.Errorf("failed to process image: %w", err)
+		}
+		if rebased, err = rebase(img, desc.Descriptor); err != nil {
+			return err
+		}
+	}
+
+	digest, err := rebased.(interface{ Digest() (v1.Hash, error) }).Digest()
+	if err != nil {
+		return fmt.Errorf("failed to compute rebased digest: %w", err)
+	}
+
+	if opts.DryRun {
+		logInfof("✓ %s is based on %s and would be rebased to %s (nothing is written)", imageRef, oldBase, digest)
+		return nil
+	}
+
+	logInfof("Pushing rebased image to %s...", destImage)
+	if err := writeArtifact(ctx, dstRef, rebased, kc); err != nil {
+		return err
+	}
+
+	logInfof("✓ Successfully rebased %s onto %s as %s (%s)", imageRef, newBase, destImage, digest)
+	return nil
+}
+
+// writeArtifact pushes an image or image index to ref, showing progress
+func writeArtifact(ctx context.Context, ref name.Reference, artifact remote.Taggable, kc authn.Keychain) error {
+	ctx, tracked, stopProgress := trackProgress(ctx, progressUpload, artifact, true)
+
+	var err error
+	switch a := tracked.(type) {
+	case v1.ImageIndex:
+		err = remote.WriteIndex(ref, a, remoteOptions(ctx, kc)...)
+	case v1.Image:
+		err = remote.Write(ref, a, remoteOptions(ctx, kc)...)
+	}
+	if err == nil {
+		progressManifestWritten(ctx, ref.String(), artifact.(interface{ Digest() (v1.Hash, error) }))
+	}
+	stopProgress()
+	if err != nil {
+		return HandleRegistryError(err, "pushing image to", ref.String())
+	}
+	return nil
+}
+
+// rebasePlatformImage rebases img onto the images of the old and new base that
+// match its platform
+func rebasePlatformImage(img v1.Image, oldDesc, newDesc *remote.Descriptor, newRef name.Reference) (v1.Image, error) {
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+	platform := config.Platform()
+
+	oldBase, err := baseImageFor(oldDesc, platform)
+	if err != nil {
+		return nil, fmt.Errorf("old base: %w", err)
+	}
+	newBase, err := baseImageFor(newDesc, platform)
+	if err != nil {
+		return nil, fmt.Errorf("new base: %w", err)
+	}
+
+	rebased, err := rebaseImage(img, oldBase, newBase, newRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebase %s image: %w", platform, err)
+	}
+	return rebased, nil
+}
+
+// baseImageFor returns the image of a base for platform, selecting it from an index
+// when the base is multi-arch. A variant is only required when the base lists one.
+func baseImageFor(desc *remote.Descriptor, platform *v1.Platform) (v1.Image, error) {
+	if !desc.MediaType.IsIndex() {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, fmt.Errorf("failed to process image: %w", err)
+		}
+		return img, nil
+	}
+
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to process image index: %w", err)
+	}
+	if platform == nil {
+		return nil, fmt.Errorf("image has no platform to select from the multi-arch base")
+	}
+	img, err := selectPlatformImage(idx, *platform)
+	if err != nil && platform.Variant != "" {
+		img, err = selectPlatformImage(idx, v1.Platform{OS: platform.OS, Architecture: platform.Architecture})
+	}
+	return img, err
+}
+
+// rebaseImage returns orig with the layers of oldBase replaced by those of newBase.
+// The new image keeps the runtime config, manifest annotations and upper layers of
+// orig, takes its platform, base history and manifest and config media types from
+// newBase, and records newBase in the standard base image annotations.
+//
+// mutate.Rebase is not used because, as of go-containerregistry v0.20, it always
+// produces a Docker manifest (even for OCI images), drops the manifest annotations
+// and platform variant, and repeats the first layer of a base whose history does
+// not describe its layers instead of adding each layer.
+func rebaseImage(orig, oldBase, newBase v1.Image, newRef name.Reference) (v1.Image, error) {
+	origLayers, err := orig.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image layers: %w", err)
+	}
+	oldLayers, err := oldBase.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read old base layers: %w", err)
+	}
+	newLayers, err := newBase.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read new base layers: %w", err)
+	}
+
+	// The old base must be exactly the bottom of the image
+	if len(oldLayers) > len(origLayers) {
+		return nil, fmt.Errorf("image is not based on the old base: it has %d layers, the old base has %d", len(origLayers), len(oldLayers))
+	}
+	for i, layer := range oldLayers {
+		want, err := layer.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read old base layer %d: %w", i, err)
+		}
+		got, err := origLayers[i].Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image layer %d: %w", i, err)
+		}
+		if got != want {
+			return nil, fmt.Errorf("image is not based on the old base: layer %d is %s, the old base has %s", i, got, want)
+		}
+	}
+
+	origConfig, err := orig.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+	oldConfig, err := oldBase.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read old base config: %w", err)
+	}
+	newConfig, err := newBase.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read new base config: %w", err)
+	}
+	origManifest, err := orig.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image manifest: %w", err)
+	}
+	newManifest, err := newBase.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read new base manifest: %w", err)
+	}
+	newDigest, err := newBase.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute new base digest: %w", err)
+	}
+
+	// Start from the image's config without layers, on the new base's platform
+	config := origConfig.DeepCopy()
+	config.Architecture = newConfig.Architecture
+	config.OS = newConfig.OS
+	config.OSVersion = newConfig.OSVersion
+	config.Variant = newConfig.Variant
+	config.RootFS.DiffIDs = nil
+	config.History = nil
+
+	img := mutate.MediaType(empty.Image, newManifest.MediaType)
+	img = mutate.ConfigMediaType(img, newManifest.Config.MediaType)
+	img, err = mutate.ConfigFile(img, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rebased config: %w", err)
+	}
+
+	// History entries above the old base belong to the image's own layers
+	var upperHistory []v1.History
+	if len(origConfig.History) >= len(oldConfig.History) {
+		upperHistory = origConfig.History[len(oldConfig.History):]
+	}
+	addenda := layerAddenda(newLayers, newConfig.History)
+	addenda = append(addenda, layerAddenda(origLayers[len(oldLayers):], upperHistory)...)
+	img, err = mutate.Append(img, addenda...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble rebased layers: %w", err)
+	}
+
+	annotations := make(map[string]string, len(origManifest.Annotations)+2)
+	for key, value := range origManifest.Annotations {
+		annotations[key] = value
+	}
+	annotations[baseNameAnnotation] = newRef.String()
+	annotations[baseDigestAnnotation] = newDigest.String()
+	return mutate.Annotations(img, annotations).(v1.Image), nil
+}
+
+// layerAddenda pairs layers with their history entries, keeping entries for
+// instructions that created no layer. If the history does not describe the layers,
+// as with images built without it, the layers are added on their own.
+func layerAddenda(layers []v1.Layer, history []v1.History) []mutate.Addendum {
+	withLayer := 0
+	for _, h := range history {
+		if !h.EmptyLayer {
+			withLayer++
+		}
+	}
+
+	addenda := make([]mutate.Addendum, 0, len(history)+len(layers))
+	if withLayer != len(layers) {
+		for _, layer := range layers {
+			addenda = append(addenda, mutate.Addendum{Layer: layer})
+		}
+		return addenda
+	}
+
+	next := 0
+	for _, h := range history {
+		if h.EmptyLayer {
+			addenda = append(addenda, mutate.Addendum{History: h})
+			continue
+		}
+		addenda = append(addenda, mutate.Addendum{Layer: layers[next], History: h})
+		next++
+	}
+	return addenda
+}
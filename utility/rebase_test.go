@@ -0,0 +1,292 @@
+package utility
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// platformBase returns a random base image for a platform
+func platformBase(t *testing.T, os, arch string) v1.Image {
+	t.Helper()
+
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatalf("Failed to create base image: %v", err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to read base config: %v", err)
+	}
+	config = config.DeepCopy()
+	config.OS, config.Architecture = os, arch
+	img, err = mutate.ConfigFile(img, config)
+	if err != nil {
+		t.Fatalf("Failed to set base platform: %v", err)
+	}
+	return img
+}
+
+// appImage builds an image on base with one layer, an ENV history entry and a
+// custom runtime config
+func appImage(t *testing.T, base v1.Image) v1.Image {
+	t.Helper()
+
+	layer, _ := random.Layer(128, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	img, err := mutate.Append(base,
+		mutate.Addendum{History: v1.History{CreatedBy: "ENV APP=1", EmptyLayer: true}},
+		mutate.Addendum{Layer: layer, History: v1.History{CreatedBy: "COPY app /app"}},
+	)
+	if err != nil {
+		t.Fatalf("Failed to build app image: %v", err)
+	}
+	config, _ := img.ConfigFile()
+	cfg := *config.Config.DeepCopy()
+	cfg.Env = []string{"APP=1"}
+	cfg.Entrypoint = []string{"/app"}
+	img, err = mutate.Config(img, cfg)
+	if err != nil {
+		t.Fatalf("Failed to set app config: %v", err)
+	}
+	return img
+}
+
+// layerDigests returns the digests of an image's layers
+func layerDigests(t *testing.T, img v1.Image) []string {
+	t.Helper()
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Failed to read layers: %v", err)
+	}
+	digests := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		digest, _ := layer.Digest()
+		digests = append(digests, digest.String())
+	}
+	return digests
+}
+
+// TestRebaseImage tests rebasing a single-platform image through a test registry
+func TestRebaseImage(t *testing.T) {
+	reg := newTestRegistry(t)
+	oldBase := platformBase(t, "linux", "amd64")
+	newBase := platformBase(t, "linux", "amd64")
+	app := appImage(t, oldBase)
+	pushTestImage(t, reg+"/base:old", oldBase)
+	pushTestImage(t, reg+"/base:new", newBase)
+	pushTestImage(t, reg+"/app:v1", app)
+
+	if err := RebaseImage(context.Background(), reg+"/app:v1", reg+"/base:old", reg+"/base:new", "", "default", "", "default", RebaseOptions{Destination: reg + "/app:v1-rebased"}); err != nil {
+		t.Fatalf("RebaseImage() failed: %v", err)
+	}
+
+	rebased := remoteImage(t, reg+"/app:v1-rebased")
+	got := layerDigests(t, rebased)
+	want := append(layerDigests(t, newBase), layerDigests(t, app)[2])
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Rebased layers = %v, want %v", got, want)
+	}
+
+	config, err := rebased.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to read rebased config: %v", err)
+	}
+	if strings.Join(config.Config.Env, " ") != "APP=1" || strings.Join(config.Config.Entrypoint, " ") != "/app" {
+		t.Errorf("Runtime config = %+v, want the app's env and entrypoint", config.Config)
+	}
+	var history []string
+	for _, h := range config.History {
+		history = append(history, h.CreatedBy)
+	}
+	if joined := strings.Join(history, ","); !strings.HasSuffix(joined, "ENV APP=1,COPY app /app") {
+		t.Errorf("History = %v, want it to end with the app's entries", history)
+	}
+
+	manifest, _ := rebased.Manifest()
+	newDigest, _ := newBase.Digest()
+	if manifest.Annotations[baseDigestAnnotation] != newDigest.String() || manifest.Annotations[baseNameAnnotation] != reg+"/base:new" {
+		t.Errorf("Annotations = %v, want the new base recorded", manifest.Annotations)
+	}
+
+	// The original tag is untouched when a destination is given
+	if digest, _ := remoteImage(t, reg+"/app:v1").Digest(); digest == mustDigest(t, rebased) {
+		t.Errorf("Original image was replaced")
+	}
+}
+
+// mustDigest returns the digest of img
+func mustDigest(t *testing.T, img v1.Image) v1.Hash {
+	t.Helper()
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Failed to compute digest: %v", err)
+	}
+	return digest
+}
+
+// TestRebaseImageNotBased tests refusing images built on a different base
+func TestRebaseImageNotBased(t *testing.T) {
+	reg := newTestRegistry(t)
+	pushTestImage(t, reg+"/base:old", platformBase(t, "linux", "amd64"))
+	pushTestImage(t, reg+"/base:new", platformBase(t, "linux", "amd64"))
+	pushTestImage(t, reg+"/app:v1", appImage(t, platformBase(t, "linux", "amd64")))
+
+	err := RebaseImage(context.Background(), reg+"/app:v1", reg+"/base:old", reg+"/base:new", "", "default", "", "default", RebaseOptions{})
+	if err == nil || !strings.Contains(err.Error(), "not based on the old base") {
+		t.Errorf("RebaseImage() error = %v, want error about the old base", err)
+	}
+}
+
+// TestRebaseImageDryRun tests that a dry run checks the base without pushing
+func TestRebaseImageDryRun(t *testing.T) {
+	reg := newTestRegistry(t)
+	oldBase := platformBase(t, "linux", "amd64")
+	app := appImage(t, oldBase)
+	pushTestImage(t, reg+"/base:old", oldBase)
+	pushTestImage(t, reg+"/base:new", platformBase(t, "linux", "amd64"))
+	pushTestImage(t, reg+"/app:v1", app)
+
+	if err := RebaseImage(context.Background(), reg+"/app:v1", reg+"/base:old", reg+"/base:new", "", "default", "", "default", RebaseOptions{DryRun: true}); err != nil {
+		t.Fatalf("RebaseImage() dry run failed: %v", err)
+	}
+	if digest := mustDigest(t, remoteImage(t, reg+"/app:v1")); digest != mustDigest(t, app) {
+		t.Errorf("Dry run replaced the image with %s", digest)
+	}
+}
+
+// TestRebaseImageIndex tests rebasing every platform of a multi-arch image
+func TestRebaseImageIndex(t *testing.T) {
+	reg := newTestRegistry(t)
+	platforms := []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}
+
+	oldIdx, newIdx, appIdx := v1.ImageIndex(empty.Index), v1.ImageIndex(empty.Index), v1.ImageIndex(empty.Index)
+	newBases := map[string]v1.Image{}
+	for _, p := range platforms {
+		platform := p
+		oldBase := platformBase(t, p.OS, p.Architecture)
+		newBase := platformBase(t, p.OS, p.Architecture)
+		newBases[p.Architecture] = newBase
+		oldIdx = mutate.AppendManifests(oldIdx, mutate.IndexAddendum{Add: oldBase, Descriptor: v1.Descriptor{Platform: &platform}})
+		newIdx = mutate.AppendManifests(newIdx, mutate.IndexAddendum{Add: newBase, Descriptor: v1.Descriptor{Platform: &platform}})
+		appIdx = mutate.AppendManifests(appIdx, mutate.IndexAddendum{Add: appImage(t, oldBase), Descriptor: v1.Descriptor{Platform: &platform}})
+	}
+	for ref, idx := range map[string]v1.ImageIndex{"/base:old": oldIdx, "/base:new": newIdx, "/app:v1": appIdx} {
+		r, _ := name.ParseReference(reg + ref)
+		if err := remote.WriteIndex(r, idx); err != nil {
+			t.Fatalf("Failed to push %s: %v", ref, err)
+		}
+	}
+
+	if err := RebaseImage(context.Background(), reg+"/app:v1", reg+"/base:old", reg+"/base:new", "", "default", "", "default", RebaseOptions{}); err != nil {
+		t.Fatalf("RebaseImage() failed: %v", err)
+	}
+
+	r, _ := name.ParseReference(reg + "/app:v1")
+	idx, err := remote.Index(r)
+	if err != nil {
+		t.Fatalf("Failed to fetch rebased index: %v", err)
+	}
+	manifest, _ := idx.IndexManifest()
+	if len(manifest.Manifests) != 2 {
+		t.Fatalf("Rebased index has %d manifests, want 2", len(manifest.Manifests))
+	}
+	for _, child := range manifest.Manifests {
+		img, err := idx.Image(child.Digest)
+		if err != nil {
+			t.Fatalf("Failed to read %s image: %v", child.Platform, err)
+		}
+		got := layerDigests(t, img)
+		want := layerDigests(t, newBases[child.Platform.Architecture])
+		if strings.Join(got[:len(want)], ",") != strings.Join(want, ",") {
+			t.Errorf("%s image layers = %v, want them to start with %v", child.Platform, got, want)
+		}
+	}
+}
+
+// TestRebaseImageKeepsFormat tests what rebaseImage does differently from
+// mutate.Rebase: it keeps OCI media types, manifest annotations and the platform
+// variant, and adds every layer of a base that has no history
+func TestRebaseImageKeepsFormat(t *testing.T) {
+	oldBase := platformBase(t, "linux", "arm64")
+	orig := mutate.MediaType(appImage(t, oldBase), types.OCIManifestSchema1)
+	orig = mutate.Annotations(orig, map[string]string{"org.opencontainers.image.source": "https://example.com/app"}).(v1.Image)
+
+	newBase := platformBase(t, "linux", "arm64")
+	config, _ := newBase.ConfigFile()
+	config = config.DeepCopy()
+	config.Variant = "v8"
+	config.History = nil
+	newBase, err := mutate.ConfigFile(newBase, config)
+	if err != nil {
+		t.Fatalf("Failed to set new base config: %v", err)
+	}
+	newBase = mutate.ConfigMediaType(mutate.MediaType(newBase, types.OCIManifestSchema1), types.OCIConfigJSON)
+
+	newRef, _ := name.ParseReference("registry.example.com/base:new")
+	rebased, err := rebaseImage(orig, oldBase, newBase, newRef)
+	if err != nil {
+		t.Fatalf("rebaseImage() failed: %v", err)
+	}
+
+	got := layerDigests(t, rebased)
+	want := append(layerDigests(t, newBase), layerDigests(t, orig)[2])
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Rebased layers = %v, want %v", got, want)
+	}
+
+	manifest, err := rebased.Manifest()
+	if err != nil {
+		t.Fatalf("Failed to read rebased manifest: %v", err)
+	}
+	if manifest.MediaType != types.OCIManifestSchema1 || manifest.Config.MediaType != types.OCIConfigJSON {
+		t.Errorf("Media types = %s, %s; want the new base's OCI types", manifest.MediaType, manifest.Config.MediaType)
+	}
+	if manifest.Annotations["org.opencontainers.image.source"] != "https://example.com/app" || manifest.Annotations[baseNameAnnotation] != newRef.String() {
+		t.Errorf("Annotations = %v, want the image's annotations and the new base", manifest.Annotations)
+	}
+	if rebasedConfig, _ := rebased.ConfigFile(); rebasedConfig.Variant != "v8" {
+		t.Errorf("Variant = %q, want the new base's v8", rebasedConfig.Variant)
+	}
+}
+
+// TestLayerAddenda tests pairing layers with history entries
+func TestLayerAddenda(t *testing.T) {
+	l1, _ := random.Layer(64, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	l2, _ := random.Layer(64, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+
+	tests := []struct {
+		name        string
+		history     []v1.History
+		wantAddenda int
+		wantHistory bool
+	}{
+		{name: "matching history", history: []v1.History{{CreatedBy: "a"}, {EmptyLayer: true}, {CreatedBy: "b"}}, wantAddenda: 3, wantHistory: true},
+		{name: "no history", history: nil, wantAddenda: 2},
+		{name: "mismatched history", history: []v1.History{{CreatedBy: "a"}}, wantAddenda: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addenda := layerAddenda([]v1.Layer{l1, l2}, tt.history)
+			if len(addenda) != tt.wantAddenda {
+				t.Fatalf("layerAddenda() returned %d addenda, want %d", len(addenda), tt.wantAddenda)
+			}
+			if hasHistory := addenda[0].History.CreatedBy != ""; hasHistory != tt.wantHistory {
+				t.Errorf("First addendum history = %+v, want history: %v", addenda[0].History, tt.wantHistory)
+			}
+			if addenda[len(addenda)-1].Layer != l2 {
+				t.Errorf("Last addendum is not the last layer")
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package utility
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// cosignTagSuffixes are the tag suffixes cosign uses to attach signatures,
+// attestations and SBOMs to an image digest (sha256-<hex>.sig and so on).
+var cosignTagSuffixes = []string{".sig", ".att", ".sbom"}
+
+// copyReferrers copies the artifacts attached to a copied image from the source to
+// the destination repository. Artifacts are discovered through the OCI referrers API
+// (or its fallback tag) and the cosign tag scheme, for the written image or index and
+// for every child manifest of an index. It returns the number of artifacts copied.
+func copyReferrers(ctx context.Context, srcRepo, dstRepo name.Repository, artifact remote.Taggable, sourceKC, destKC authn.Keychain) (int, error) {
+	subjects, err := artifactManifests(artifact)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for _, subject := range subjects {
+		refs, err := findReferrers(ctx, srcRepo, subject, sourceKC)
+		if err != nil {
+			return copied, err
+		}
+
+		for _, src := range refs {
+			var dst name.Reference
+			switch r := src.(type) {
+			case name.Tag:
+				dst = dstRepo.Tag(r.TagStr())
+			default:
+				dst = dstRepo.Digest(r.Identifier())
+			}
+
+			if err := copyReference(ctx, src, dst, sourceKC, destKC, false, CopyOptions{}); err != nil {
+				return copied, fmt.Errorf("failed to copy referrer %s: %w", src, err)
+			}
+			copied++
+		}
+	}
+
+	return copied, nil
+}
+
+// warnSourceIndexReferrers warns about referrers of the source index when platform
+// selection wrote a different manifest in its place. Those referrers name the
+// source index digest, which the destination does not have, so they are not
+// copied; referrers of the platform manifests that were kept still are.
+func warnSourceIndexReferrers(ctx context.Context, srcRepo name.Repository, source v1.Hash, artifact remote.Taggable, sourceKC authn.Keychain) error {
+	written, err := partial.Digest(artifact)
+	if err != nil {
+		return fmt.Errorf("failed to compute digest: %w", err)
+	}
+	if written == source {
+		return nil
+	}
+
+	refs, err := findReferrers(ctx, srcRepo, source, sourceKC)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		logWarnf("%d referrers of the source index %s were not copied: platform selection wrote %s, which they do not refer to", len(refs), source, written)
+	}
+	return nil
+}
+
+// artifactManifests returns the digest of an image or index and of every manifest
+// an index lists
+func artifactManifests(artifact remote.Taggable) ([]v1.Hash, error) {
+	digest, err := partial.Digest(artifact)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute digest: %w", err)
+	}
+	digests := []v1.Hash{digest}
+
+	if idx, ok := artifact.(v1.ImageIndex); ok {
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image index manifest: %w", err)
+		}
+		for _, child := range manifest.Manifests {
+			digests = append(digests, child.Digest)
+		}
+	}
+	return digests, nil
+}
+
+// findReferrers returns references to every artifact in repo that refers to subject.
+// Referrers API results are returned by digest and cosign artifacts by tag.
+func findReferrers(ctx context.Context, repo name.Repository, subject v1.Hash, kc authn.Keychain) ([]name.Reference, error) {
+	var refs []name.Reference
+
+	// OCI referrers API, falling back to the sha256-<hex> referrers tag
+	digestRef := repo.Digest(subject.String())
+	idx, err := remote.Referrers(digestRef, remoteOptions(ctx, kc)...)
+	if err != nil {
+		return nil, HandleRegistryError(err, "listing referrers of", digestRef.String())
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read referrers of '%s': %w", digestRef, err)
+	}
+	for _, d := range manifest.Manifests {
+		refs = append(refs, repo.Digest(d.Digest.String()))
+	}
+
+	// cosign tag scheme
+	for _, suffix := range cosignTagSuffixes {
+		tag := repo.Tag(strings.Replace(subject.String(), ":", "-", 1) + suffix)
+		if _, err := remote.Head(tag, remoteOptions(ctx, kc)...); err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, HandleRegistryError(err, "checking for cosign artifact", tag.String())
+		}
+		refs = append(refs, tag)
+	}
+
+	return refs, nil
+}
+
+// isNotFound reports whether err is a registry 404 response.
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,146 @@
+package utility
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// TestCopyImageWithReferrers tests that cosign tags and OCI referrers follow the image
+func TestCopyImageWithReferrers(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	img := pushRandomImage(t, reg+"/staging/app:v1")
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get image digest: %v", err)
+	}
+	sigTag := strings.Replace(digest.String(), ":", "-", 1) + ".sig"
+	pushRandomImage(t, reg+"/staging/app:"+sigTag)
+
+	// Attach an SBOM-like artifact through the referrers API
+	desc, err := partial.Descriptor(img)
+	if err != nil {
+		t.Fatalf("Failed to get image descriptor: %v", err)
+	}
+	artifact, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatalf("Failed to create artifact: %v", err)
+	}
+	artifact = mutate.Subject(mutate.MediaType(artifact, types.OCIManifestSchema1), *desc).(v1.Image)
+	artifactDigest, err := artifact.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get artifact digest: %v", err)
+	}
+	pushTestImage(t, reg+"/staging/app@"+artifactDigest.String(), artifact)
+
+	tests := []struct {
+		name          string
+		destImage     string
+		withReferrers bool
+	}{
+		{
+			name:          "without referrers",
+			destImage:     reg + "/plain/app:v1",
+			withReferrers: false,
+		},
+		{
+			name:          "with referrers",
+			destImage:     reg + "/prod/app:v1",
+			withReferrers: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CopyImage(context.Background(), reg+"/staging/app:v1", tt.destImage, "", "", "default", "default", false, CopyOptions{
+				WithReferrers: tt.withReferrers,
+			})
+			if err != nil {
+				t.Fatalf("CopyImage() failed: %v", err)
+			}
+
+			dstRepo := strings.TrimSuffix(tt.destImage, ":v1")
+			sigRef, _ := name.ParseReference(dstRepo + ":" + sigTag)
+			_, err = remote.Head(sigRef)
+			if tt.withReferrers && err != nil {
+				t.Errorf("Expected cosign signature to be copied: %v", err)
+			}
+			if !tt.withReferrers && err == nil {
+				t.Errorf("Did not expect cosign signature to be copied")
+			}
+
+			subject, _ := name.NewDigest(dstRepo + "@" + digest.String())
+			idx, err := remote.Referrers(subject)
+			if err != nil {
+				t.Fatalf("Failed to list destination referrers: %v", err)
+			}
+			manifest, err := idx.IndexManifest()
+			if err != nil {
+				t.Fatalf("Failed to read destination referrers: %v", err)
+			}
+			want := 0
+			if tt.withReferrers {
+				want = 1
+			}
+			if len(manifest.Manifests) != want {
+				t.Errorf("Got %d referrers at destination, want %d", len(manifest.Manifests), want)
+			}
+		})
+	}
+}
+
+// TestCopyImagePlatformsWithReferrers tests that filtering an index copies the
+// referrers of the kept platform manifests and warns about those of the index
+func TestCopyImagePlatformsWithReferrers(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	idx, digests := newPlatformIndex(t, "linux/amd64", "linux/arm64")
+	srcRef, _ := name.ParseReference(reg + "/multi/app:v1")
+	if err := remote.WriteIndex(srcRef, idx); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+	indexDigest, _ := idx.Digest()
+
+	sigTag := func(h v1.Hash) string { return strings.Replace(h.String(), ":", "-", 1) + ".sig" }
+	for _, h := range []v1.Hash{digests["linux/amd64"], digests["linux/arm64"], indexDigest} {
+		pushRandomImage(t, reg+"/multi/app:"+sigTag(h))
+	}
+
+	tests := []struct {
+		name string
+		opts CopyOptions
+	}{
+		{name: "filter platforms", opts: CopyOptions{Platforms: []string{"linux/amd64"}, WithReferrers: true}},
+		{name: "flatten to one platform", opts: CopyOptions{Platform: "linux/amd64", WithReferrers: true}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := useProgressLog(t, slog.LevelInfo)
+			dstRepo := reg + "/edge" + string(rune('a'+i)) + "/app"
+			if err := CopyImage(context.Background(), srcRef.String(), dstRepo+":v1", "", "", "default", "default", false, tt.opts); err != nil {
+				t.Fatalf("CopyImage() failed: %v", err)
+			}
+
+			for platform, want := range map[string]bool{"linux/amd64": true, "linux/arm64": false} {
+				ref, _ := name.ParseReference(dstRepo + ":" + sigTag(digests[platform]))
+				if _, err := remote.Head(ref); (err == nil) != want {
+					t.Errorf("Signature of %s copied = %v, want %v", platform, err == nil, want)
+				}
+			}
+			if !strings.Contains(buf.String(), "1 referrers of the source index "+indexDigest.String()+" were not copied") {
+				t.Errorf("Expected a warning about the index referrers, got:\n%s", buf.String())
+			}
+		})
+	}
+}
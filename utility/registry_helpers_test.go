@@ -0,0 +1,120 @@
+package utility
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// newTestRegistry starts an in-memory registry and returns its host:port
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(registry.New(
+		registry.Logger(log.New(io.Discard, "", 0)),
+		registry.WithReferrersSupport(true),
+	))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// pushRandomImage pushes a random image to ref and returns it
+func pushRandomImage(t *testing.T, ref string) v1.Image {
+	t.Helper()
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("Failed to create random image: %v", err)
+	}
+	pushTestImage(t, ref, img)
+
+	return img
+}
+
+// pushTestImage pushes img to ref
+func pushTestImage(t *testing.T, ref string, img v1.Image) {
+	t.Helper()
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference '%s': %v", ref, err)
+	}
+	if err := remote.Write(r, img); err != nil {
+		t.Fatalf("Failed to push test image to '%s': %v", ref, err)
+	}
+}
+
+// remoteImage fetches the image at ref from a test registry
+func remoteImage(t *testing.T, ref string) v1.Image {
+	t.Helper()
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference '%s': %v", ref, err)
+	}
+	img, err := remote.Image(r)
+	if err != nil {
+		t.Fatalf("Failed to fetch '%s': %v", ref, err)
+	}
+	return img
+}
+
+// mountRegistry wraps the in-memory registry, which shares blobs between
+// repositories, so that blobs only appear in /prod/ repositories once mounted.
+// openUploads counts upload sessions in /prod/ that were started but neither
+// completed nor cancelled.
+type mountRegistry struct {
+	handler     http.Handler
+	allowMount  bool
+	mu          sync.Mutex
+	mounted     map[string]bool
+	uploaded    map[string]bool
+	openUploads int
+}
+
+func (m *mountRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	isProd := strings.HasPrefix(r.URL.Path, "/v2/prod/")
+	switch {
+	case isProd && r.Method == http.MethodHead && strings.Contains(r.URL.Path, "/blobs/"):
+		digest := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if !m.mounted[digest] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	case isProd && r.Method == http.MethodPost && r.URL.Query().Get("mount") != "":
+		if m.allowMount && r.URL.Query().Get("from") == "staging/app" {
+			m.mounted[r.URL.Query().Get("mount")] = true
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		// Refused: start a regular upload session like real registries do
+		r.URL.RawQuery = ""
+		m.openUploads++
+	case isProd && r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/blobs/uploads/"):
+		m.openUploads++
+	case isProd && r.Method == http.MethodPut && r.URL.Query().Get("digest") != "":
+		m.openUploads--
+		if m.uploaded != nil {
+			m.uploaded[r.URL.Query().Get("digest")] = true
+		}
+	case isProd && r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/blobs/uploads/"):
+		m.openUploads--
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	m.handler.ServeHTTP(w, r)
+}
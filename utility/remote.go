@@ -0,0 +1,24 @@
+package utility
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// registryTransport is the HTTP transport for every registry request. It traces
+// requests at debug level (--verbose).
+var registryTransport http.RoundTripper = &tracingTransport{inner: remote.DefaultTransport}
+
+// remoteOptions returns the options shared by every registry request: the caller's
+// context, so requests stop when the command is cancelled, the keychain used for
+// authentication, and the tracing transport.
+func remoteOptions(ctx context.Context, kc authn.Keychain, extra ...remote.Option) []remote.Option {
+	return append([]remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(kc),
+		remote.WithTransport(registryTransport),
+	}, extra...)
+}
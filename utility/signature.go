@@ -0,0 +1,339 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	protobundle "github.com/sigstore/protobuf-specs/gen/pb-go/bundle/v1"
+	protocommon "github.com/sigstore/protobuf-specs/gen/pb-go/common/v1"
+	protorekor "github.com/sigstore/protobuf-specs/gen/pb-go/rekor/v1"
+	"github.com/sigstore/sigstore-go/pkg/bundle"
+	"github.com/sigstore/sigstore-go/pkg/root"
+	"github.com/sigstore/sigstore-go/pkg/verify"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// Annotations cosign stores on each layer of a signature image
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// sigstoreBundleMediaType is the bundle version that carries a transparency log
+// inclusion promise, which is what cosign attaches to legacy signature images
+const sigstoreBundleMediaType = "application/vnd.dev.sigstore.bundle+json;version=0.1"
+
+// VerifyOptions configures cosign signature verification of a source image.
+// Set KeyPath for key-based signatures, or KeylessIdentity and TrustedRootPath
+// for keyless (Fulcio certificate) signatures.
+type VerifyOptions struct {
+	// KeyPath is a PEM encoded public key
+	KeyPath string
+	// KeylessIdentity is the email or URI the signing certificate must be issued to
+	KeylessIdentity string
+	// KeylessIssuer optionally restricts the OIDC issuer recorded in the certificate
+	KeylessIssuer string
+	// TrustedRootPath is a Sigstore trusted_root.json with the certificate
+	// authorities and transparency logs to trust
+	TrustedRootPath string
+}
+
+// Enabled reports whether any signature verification was requested
+func (o VerifyOptions) Enabled() bool {
+	return o.KeyPath != "" || o.KeylessIdentity != ""
+}
+
+// validate checks that exactly one verification mode is configured
+func (o VerifyOptions) validate() error {
+	switch {
+	case o.KeyPath != "" && o.KeylessIdentity != "":
+		return fmt.Errorf("--verify-key and --verify-keyless-identity cannot be used together")
+	case o.KeylessIdentity != "" && o.TrustedRootPath == "":
+		return fmt.Errorf("keyless verification requires a trusted root file (--verify-trusted-root)")
+	case o.KeylessIdentity == "" && (o.KeylessIssuer != "" || o.TrustedRootPath != ""):
+		return fmt.Errorf("--verify-keyless-issuer and --verify-trusted-root require --verify-keyless-identity")
+	}
+	return nil
+}
+
+// simpleSigningPayload is the part of cosign's signed payload that binds a
+// signature to an image digest
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// rekorBundle is the transparency log inclusion promise cosign attaches to keyless signatures
+type rekorBundle struct {
+	SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+	Payload              struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogIndex       int64  `json:"logIndex"`
+		LogID          string `json:"logID"`
+	} `json:"Payload"`
+}
+
+// VerifyImageSignature checks that the image at digestRef carries a cosign signature
+// accepted by opts. It returns an error unless at least one signature verifies.
+func VerifyImageSignature(ctx context.Context, digestRef name.Digest, kc authn.Keychain, opts VerifyOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	var verifier func(layer v1.Descriptor, payload, sig []byte) error
+	if opts.KeyPath != "" {
+		keyVerifier, err := loadKeyVerifier(opts.KeyPath)
+		if err != nil {
+			return err
+		}
+		verifier = func(_ v1.Descriptor, payload, sig []byte) error {
+			if err := keyVerifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(payload)); err != nil {
+				return fmt.Errorf("invalid signature: %w", err)
+			}
+			return nil
+		}
+	} else {
+		keyless, err := newKeylessVerifier(opts)
+		if err != nil {
+			return err
+		}
+		verifier = keyless.verify
+	}
+
+	sigTag := digestRef.Context().Tag(strings.Replace(digestRef.DigestStr(), ":", "-", 1) + ".sig")
+	sigImg, err := remote.Image(sigTag, remoteOptions(ctx, kc)...)
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("no cosign signatures found for '%s'", digestRef)
+		}
+		return HandleRegistryError(err, "fetching signatures for", digestRef.String())
+	}
+
+	manifest, err := sigImg.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to read signature manifest: %w", err)
+	}
+
+	var failures []error
+	for _, layer := range manifest.Layers {
+		sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(sig) == 0 {
+			failures = append(failures, fmt.Errorf("layer %s has no valid signature annotation", layer.Digest))
+			continue
+		}
+
+		payload, err := readBlob(sigImg, layer.Digest)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		if err := verifier(layer, payload, sig); err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		if err := checkSignedDigest(payload, digestRef.DigestStr()); err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		return nil
+	}
+
+	if len(failures) == 0 {
+		return fmt.Errorf("no cosign signatures found for '%s'", digestRef)
+	}
+	return fmt.Errorf("signature verification failed for '%s': %w", digestRef, errors.Join(failures...))
+}
+
+// keylessVerifier checks signatures made with Fulcio-issued certificates using
+// sigstore-go. A signature is only accepted with a transparency log entry from a
+// log in the trusted root, and the certificate is checked at the time that entry
+// was logged, once the log's signature over the entry has been verified.
+type keylessVerifier struct {
+	verifier *verify.Verifier
+	identity verify.CertificateIdentity
+}
+
+// newKeylessVerifier loads the trusted root and expected identity for opts
+func newKeylessVerifier(opts VerifyOptions) (*keylessVerifier, error) {
+	trustedRoot, err := root.NewTrustedRootFromPath(opts.TrustedRootPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted root '%s': %w", opts.TrustedRootPath, err)
+	}
+	if len(trustedRoot.RekorLogs()) == 0 {
+		return nil, fmt.Errorf("trusted root '%s' has no transparency logs, which keyless verification requires", opts.TrustedRootPath)
+	}
+
+	verifier, err := verify.NewVerifier(trustedRoot, verify.WithTransparencyLog(1), verify.WithIntegratedTimestamps(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keyless verifier: %w", err)
+	}
+
+	// Any issuer is accepted unless one is given
+	issuerRegex := ""
+	if opts.KeylessIssuer == "" {
+		issuerRegex = ".*"
+	}
+	identity, err := verify.NewShortCertificateIdentity(opts.KeylessIssuer, issuerRegex, opts.KeylessIdentity, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyless identity: %w", err)
+	}
+
+	return &keylessVerifier{verifier: verifier, identity: identity}, nil
+}
+
+// verify checks one cosign signature layer
+func (v *keylessVerifier) verify(layer v1.Descriptor, payload, sig []byte) error {
+	b, err := cosignBundle(layer.Annotations, payload, sig)
+	if err != nil {
+		return err
+	}
+
+	policy := verify.NewPolicy(verify.WithArtifact(bytes.NewReader(payload)), verify.WithCertificateIdentity(v.identity))
+	if _, err := v.verifier.Verify(b, policy); err != nil {
+		return fmt.Errorf("keyless signature verification failed: %w", err)
+	}
+	return nil
+}
+
+// cosignBundle converts the annotations of a cosign signature layer (signing
+// certificate, chain and transparency log bundle) into a Sigstore bundle
+func cosignBundle(annotations map[string]string, payload, sig []byte) (*bundle.Bundle, error) {
+	certPEM := annotations[cosignCertificateAnnotation]
+	if certPEM == "" {
+		return nil, fmt.Errorf("signature has no signing certificate (was it signed with a key?)")
+	}
+	certs, err := cryptoutils.UnmarshalCertificatesFromPEM([]byte(certPEM + "\n" + annotations[cosignChainAnnotation]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing certificate: %w", err)
+	}
+	chain := make([]*protocommon.X509Certificate, 0, len(certs))
+	for _, cert := range certs {
+		chain = append(chain, &protocommon.X509Certificate{RawBytes: cert.Raw})
+	}
+
+	raw := annotations[cosignBundleAnnotation]
+	if raw == "" {
+		return nil, fmt.Errorf("signature has no transparency log bundle")
+	}
+	var rb rekorBundle
+	if err := json.Unmarshal([]byte(raw), &rb); err != nil {
+		return nil, fmt.Errorf("failed to parse transparency log bundle: %w", err)
+	}
+	body, err := base64.StdEncoding.DecodeString(rb.Payload.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transparency log entry: %w", err)
+	}
+	var kind struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := json.Unmarshal(body, &kind); err != nil {
+		return nil, fmt.Errorf("failed to parse transparency log entry: %w", err)
+	}
+	logID, err := hex.DecodeString(rb.Payload.LogID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transparency log ID '%s': %w", rb.Payload.LogID, err)
+	}
+
+	digest := sha256.Sum256(payload)
+	b, err := bundle.NewBundle(&protobundle.Bundle{
+		MediaType: sigstoreBundleMediaType,
+		VerificationMaterial: &protobundle.VerificationMaterial{
+			Content: &protobundle.VerificationMaterial_X509CertificateChain{
+				X509CertificateChain: &protocommon.X509CertificateChain{Certificates: chain},
+			},
+			TlogEntries: []*protorekor.TransparencyLogEntry{{
+				LogIndex:          rb.Payload.LogIndex,
+				LogId:             &protocommon.LogId{KeyId: logID},
+				KindVersion:       &protorekor.KindVersion{Kind: kind.Kind, Version: kind.APIVersion},
+				IntegratedTime:    rb.Payload.IntegratedTime,
+				InclusionPromise:  &protorekor.InclusionPromise{SignedEntryTimestamp: rb.SignedEntryTimestamp},
+				CanonicalizedBody: body,
+			}},
+		},
+		Content: &protobundle.Bundle_MessageSignature{
+			MessageSignature: &protocommon.MessageSignature{
+				MessageDigest: &protocommon.HashOutput{Algorithm: protocommon.HashAlgorithm_SHA2_256, Digest: digest[:]},
+				Signature:     sig,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid transparency log bundle: %w", err)
+	}
+	return b, nil
+}
+
+// checkSignedDigest verifies that a simple signing payload refers to the expected digest
+func checkSignedDigest(payload []byte, digest string) error {
+	var p simpleSigningPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("failed to parse signature payload: %w", err)
+	}
+	if p.Critical.Type != "cosign container image signature" {
+		return fmt.Errorf("unexpected signature payload type '%s'", p.Critical.Type)
+	}
+	if signed := p.Critical.Image.DockerManifestDigest; signed != digest {
+		return fmt.Errorf("signature is for digest '%s', not '%s'", signed, digest)
+	}
+	return nil
+}
+
+// loadKeyVerifier reads a PEM encoded public key and returns a verifier for
+// signatures made with it the way cosign does
+func loadKeyVerifier(path string) (signature.Verifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	pub, err := cryptoutils.UnmarshalPEMToPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key '%s': %w", path, err)
+	}
+	verifier, err := signature.LoadVerifier(pub, crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported public key '%s': %w", path, err)
+	}
+	return verifier, nil
+}
+
+// readBlob reads the content of a layer blob in img
+func readBlob(img v1.Image, digest v1.Hash) ([]byte, error) {
+	layer, err := img.LayerByDigest(digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find layer %s: %w", digest, err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer %s: %w", digest, err)
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
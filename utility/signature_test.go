@@ -0,0 +1,471 @@
+package utility
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+)
+
+// testSigner produces cosign signature layer annotations for a payload
+type testSigner func(t *testing.T, payload []byte) map[string]string
+
+// pushSignature pushes a cosign signature image for digest into repo
+func pushSignature(t *testing.T, repo string, digest v1.Hash, signedDigest v1.Hash, sign testSigner) {
+	t.Helper()
+
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"` + repo + `"},"image":{"docker-manifest-digest":"` +
+		signedDigest.String() + `"},"type":"cosign container image signature"},"optional":null}`)
+
+	sigImg, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: sign(t, payload),
+	})
+	if err != nil {
+		t.Fatalf("Failed to build signature image: %v", err)
+	}
+
+	pushTestImage(t, repo+":"+strings.Replace(digest.String(), ":", "-", 1)+".sig", sigImg)
+}
+
+// newTestKey generates an ECDSA key and writes its public half to a PEM file
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return key, path
+}
+
+// signPayload signs payload with key the way cosign does
+func signPayload(t *testing.T, key *ecdsa.PrivateKey, payload []byte) []byte {
+	t.Helper()
+
+	digest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign payload: %v", err)
+	}
+	return sig
+}
+
+// keySigner signs with a private key
+func keySigner(key *ecdsa.PrivateKey) testSigner {
+	return func(t *testing.T, payload []byte) map[string]string {
+		return map[string]string{
+			cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signPayload(t, key, payload)),
+		}
+	}
+}
+
+// fulcioIssuerOID is the Fulcio certificate extension holding the OIDC issuer
+var fulcioIssuerOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+
+// testCA is a self-signed certificate authority issuing keyless signing certificates
+type testCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+// newTestCA creates a certificate authority
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-fulcio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+	return &testCA{key: key, cert: cert}
+}
+
+// issue creates a short-lived signing certificate for key, email and issuer,
+// valid around at
+func (ca *testCA) issue(t *testing.T, key *ecdsa.PrivateKey, email, issuer string, at time.Time) []byte {
+	t.Helper()
+
+	issuerValue, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatalf("Failed to encode issuer: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       at.Add(-time.Minute),
+		NotAfter:        at.Add(10 * time.Minute),
+		EmailAddresses:  []string{email},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{{Id: fulcioIssuerOID, Value: issuerValue}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create signing certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// keylessSigner describes how a test keyless signature is made
+type keylessSigner struct {
+	ca     *testCA
+	email  string
+	issuer string
+	// logKey signs the transparency log entry; no bundle is attached when nil
+	logKey *ecdsa.PrivateKey
+	// loggedEmail records a certificate for the same key but another email in
+	// the log entry than the one attached to the signature
+	loggedEmail string
+}
+
+// signer returns a keyless signer issuing a certificate for email from issuer,
+// with a transparency log bundle signed by logKey
+func (ca *testCA) signer(email, issuer string, logKey *ecdsa.PrivateKey) testSigner {
+	return keylessSigner{ca: ca, email: email, issuer: issuer, logKey: logKey}.sign
+}
+
+// sign produces cosign keyless signature annotations for payload
+func (s keylessSigner) sign(t *testing.T, payload []byte) map[string]string {
+	// The short-lived certificate is only valid at the logged time, not now, so
+	// the log entry's timestamp must be used once it has been verified
+	integrated := time.Now().Add(-30 * time.Minute)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	certPEM := s.ca.issue(t, key, s.email, s.issuer, integrated)
+
+	sig := signPayload(t, key, payload)
+	annotations := map[string]string{
+		cosignSignatureAnnotation:   base64.StdEncoding.EncodeToString(sig),
+		cosignCertificateAnnotation: string(certPEM),
+	}
+	if s.logKey == nil {
+		return annotations
+	}
+
+	loggedCert := certPEM
+	if s.loggedEmail != "" {
+		loggedCert = s.ca.issue(t, key, s.loggedEmail, s.issuer, integrated)
+	}
+	payloadHash := sha256.Sum256(payload)
+	body, _ := json.Marshal(map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]any{
+			"data": map[string]any{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(payloadHash[:])}},
+			"signature": map[string]any{
+				"content":   base64.StdEncoding.EncodeToString(sig),
+				"publicKey": map[string]string{"content": base64.StdEncoding.EncodeToString(loggedCert)},
+			},
+		},
+	})
+	logDER, _ := x509.MarshalPKIXPublicKey(&s.logKey.PublicKey)
+	logID := sha256.Sum256(logDER)
+
+	var bundle rekorBundle
+	bundle.Payload.Body = base64.StdEncoding.EncodeToString(body)
+	bundle.Payload.IntegratedTime = integrated.Unix()
+	bundle.Payload.LogIndex = 42
+	bundle.Payload.LogID = hex.EncodeToString(logID[:])
+	canonical, _ := json.Marshal(map[string]any{
+		"body":           bundle.Payload.Body,
+		"integratedTime": bundle.Payload.IntegratedTime,
+		"logID":          bundle.Payload.LogID,
+		"logIndex":       bundle.Payload.LogIndex,
+	})
+	bundle.SignedEntryTimestamp = signPayload(t, s.logKey, canonical)
+
+	raw, _ := json.Marshal(bundle)
+	annotations[cosignBundleAnnotation] = string(raw)
+	return annotations
+}
+
+// writeTrustedRootJSON writes a Sigstore trusted_root.json trusting the CA and,
+// when logKey is set, the transparency log. A non-zero caEnd ends the CA's
+// validity period.
+func (ca *testCA) writeTrustedRootJSON(t *testing.T, logKey *ecdsa.PrivateKey, caEnd time.Time) string {
+	t.Helper()
+
+	start := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	validFor := map[string]string{"start": start}
+	if !caEnd.IsZero() {
+		validFor["end"] = caEnd.Format(time.RFC3339)
+	}
+	doc := map[string]any{
+		"mediaType": "application/vnd.dev.sigstore.trustedroot+json;version=0.1",
+		"certificateAuthorities": []any{map[string]any{
+			"subject":   map[string]string{"organization": "test", "commonName": "test-fulcio"},
+			"uri":       "https://fulcio.example.com",
+			"certChain": map[string]any{"certificates": []any{map[string]any{"rawBytes": ca.cert.Raw}}},
+			"validFor":  validFor,
+		}},
+	}
+	if logKey != nil {
+		logDER, err := x509.MarshalPKIXPublicKey(&logKey.PublicKey)
+		if err != nil {
+			t.Fatalf("Failed to marshal log key: %v", err)
+		}
+		logID := sha256.Sum256(logDER)
+		doc["tlogs"] = []any{map[string]any{
+			"baseUrl":       "https://rekor.example.com",
+			"hashAlgorithm": "SHA2_256",
+			"publicKey": map[string]any{
+				"rawBytes":   logDER,
+				"keyDetails": "PKIX_ECDSA_P256_SHA_256",
+				"validFor":   map[string]string{"start": start},
+			},
+			"logId": map[string]any{"keyId": logID[:]},
+		}}
+	}
+
+	data, _ := json.Marshal(doc)
+	path := filepath.Join(t.TempDir(), "trusted_root.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write trusted root: %v", err)
+	}
+	return path
+}
+
+// TestVerifyImageSignature tests key-based and keyless cosign verification
+func TestVerifyImageSignature(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	key, keyPath := newTestKey(t)
+	_, otherKeyPath := newTestKey(t)
+	ca := newTestCA(t)
+	logKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherLogKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	trustedRoot := ca.writeTrustedRootJSON(t, logKey, time.Time{})
+	expiredCARoot := ca.writeTrustedRootJSON(t, logKey, time.Now().Add(-time.Hour))
+	noLogRoot := ca.writeTrustedRootJSON(t, nil, time.Time{})
+	pemRoot := filepath.Join(t.TempDir(), "root.pem")
+	if err := os.WriteFile(pemRoot, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600); err != nil {
+		t.Fatalf("Failed to write PEM root: %v", err)
+	}
+
+	const identity = "release@example.com"
+	const issuer = "https://accounts.example.com"
+	keyless := VerifyOptions{KeylessIdentity: identity, KeylessIssuer: issuer, TrustedRootPath: trustedRoot}
+
+	tests := []struct {
+		name    string
+		sign    testSigner
+		signFor string // sign a different digest when set
+		opts    VerifyOptions
+		wantErr string
+	}{
+		{
+			name: "valid key signature",
+			sign: keySigner(key),
+			opts: VerifyOptions{KeyPath: keyPath},
+		},
+		{
+			name:    "signed with another key",
+			sign:    keySigner(key),
+			opts:    VerifyOptions{KeyPath: otherKeyPath},
+			wantErr: "invalid signature",
+		},
+		{
+			name:    "signature for another digest",
+			sign:    keySigner(key),
+			signFor: "other",
+			opts:    VerifyOptions{KeyPath: keyPath},
+			wantErr: "signature is for digest",
+		},
+		{
+			name:    "unsigned image",
+			opts:    VerifyOptions{KeyPath: keyPath},
+			wantErr: "no cosign signatures found",
+		},
+		{
+			name: "valid keyless signature",
+			sign: ca.signer(identity, issuer, logKey),
+			opts: keyless,
+		},
+		{
+			name: "keyless signature from any issuer",
+			sign: ca.signer(identity, "https://other.example.com", logKey),
+			opts: VerifyOptions{KeylessIdentity: identity, TrustedRootPath: trustedRoot},
+		},
+		{
+			name:    "keyless signature for another identity",
+			sign:    ca.signer("someone@example.com", issuer, logKey),
+			opts:    keyless,
+			wantErr: `expected SAN value "release@example.com"`,
+		},
+		{
+			name:    "keyless signature from another issuer",
+			sign:    ca.signer(identity, "https://evil.example.com", logKey),
+			opts:    keyless,
+			wantErr: `expected issuer value "https://accounts.example.com"`,
+		},
+		{
+			name:    "keyless signature from untrusted CA",
+			sign:    newTestCA(t).signer(identity, issuer, logKey),
+			opts:    keyless,
+			wantErr: "leaf certificate verification failed",
+		},
+		{
+			name:    "keyless signature without bundle",
+			sign:    ca.signer(identity, issuer, nil),
+			opts:    keyless,
+			wantErr: "no transparency log bundle",
+		},
+		{
+			// An unverifiable bundle must not supply the time the expired
+			// certificate is checked at
+			name:    "keyless signature logged by untrusted log",
+			sign:    ca.signer(identity, issuer, otherLogKey),
+			opts:    keyless,
+			wantErr: "not enough verified log entries",
+		},
+		{
+			name:    "log entry for another certificate",
+			sign:    keylessSigner{ca: ca, email: identity, issuer: issuer, logKey: logKey, loggedEmail: "someone@example.com"}.sign,
+			opts:    keyless,
+			wantErr: "transparency log certificate does not match",
+		},
+		{
+			name:    "CA validity period ended",
+			sign:    ca.signer(identity, issuer, logKey),
+			opts:    VerifyOptions{KeylessIdentity: identity, TrustedRootPath: expiredCARoot},
+			wantErr: "leaf certificate verification failed",
+		},
+		{
+			name:    "trusted root without transparency logs",
+			sign:    ca.signer(identity, issuer, logKey),
+			opts:    VerifyOptions{KeylessIdentity: identity, TrustedRootPath: noLogRoot},
+			wantErr: "has no transparency logs",
+		},
+		{
+			name:    "PEM bundle as trusted root",
+			sign:    ca.signer(identity, issuer, logKey),
+			opts:    VerifyOptions{KeylessIdentity: identity, TrustedRootPath: pemRoot},
+			wantErr: "failed to load trusted root",
+		},
+		{
+			name:    "key and keyless together",
+			opts:    VerifyOptions{KeyPath: keyPath, KeylessIdentity: identity, TrustedRootPath: trustedRoot},
+			wantErr: "cannot be used together",
+		},
+		{
+			name:    "keyless without trusted root",
+			opts:    VerifyOptions{KeylessIdentity: identity},
+			wantErr: "requires a trusted root",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := reg + "/signed/app" + string(rune('a'+i))
+			img := pushRandomImage(t, repo+":v1")
+			digest, err := img.Digest()
+			if err != nil {
+				t.Fatalf("Failed to get digest: %v", err)
+			}
+
+			if tt.sign != nil {
+				signed := digest
+				if tt.signFor != "" {
+					signed = v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}
+				}
+				pushSignature(t, repo, digest, signed, tt.sign)
+			}
+
+			ref, err := name.NewDigest(repo + "@" + digest.String())
+			if err != nil {
+				t.Fatalf("Failed to parse digest reference: %v", err)
+			}
+
+			err = VerifyImageSignature(context.Background(), ref, authn.DefaultKeychain, tt.opts)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("VerifyImageSignature() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyImageSignature() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCopyAndPullVerifySignature tests that copy and pull refuse unsigned images
+func TestCopyAndPullVerifySignature(t *testing.T) {
+	reg := newTestRegistry(t)
+	key, keyPath := newTestKey(t)
+
+	signed := pushRandomImage(t, reg+"/staging/signed:v1")
+	digest, _ := signed.Digest()
+	pushSignature(t, reg+"/staging/signed", digest, digest, keySigner(key))
+	pushRandomImage(t, reg+"/staging/unsigned:v1")
+
+	verify := VerifyOptions{KeyPath: keyPath}
+	outputDir := t.TempDir()
+
+	if err := CopyImage(context.Background(), reg+"/staging/signed:v1", reg+"/prod/signed:v1", "", "", "default", "default", false, CopyOptions{Verify: verify}); err != nil {
+		t.Errorf("Expected signed image to be copied: %v", err)
+	}
+	if err := CopyImage(context.Background(), reg+"/staging/unsigned:v1", reg+"/prod/unsigned:v1", "", "", "default", "default", false, CopyOptions{Verify: verify}); err == nil {
+		t.Errorf("Expected unsigned image copy to be refused")
+	}
+
+	if err := PullImage(context.Background(), reg+"/staging/signed:v1", filepath.Join(outputDir, "signed.tar"), "", "default", PullOptions{Verify: verify}); err != nil {
+		t.Errorf("Expected signed image to be pulled: %v", err)
+	}
+	unsignedPath := filepath.Join(outputDir, "unsigned.tar")
+	if err := PullImage(context.Background(), reg+"/staging/unsigned:v1", unsignedPath, "", "default", PullOptions{Verify: verify}); err == nil {
+		t.Errorf("Expected unsigned image pull to be refused")
+	}
+	if _, err := os.Stat(unsignedPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file for refused pull")
+	}
+}
@@ -0,0 +1,94 @@
+package utility
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// stdioPath is the path that makes pull write its tar file to stdout and push read
+// it from stdin
+const stdioPath = "-"
+
+// stdin and stdout are the streams used for the "-" path; tests override them
+var (
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+)
+
+// isTerminal reports whether w is an interactive terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// writeTarballStream writes img as a tarball, compressed with compression, to w.
+// Unlike writeTarballAtomic the result cannot be verified or renamed afterwards.
+func writeTarballStream(w io.Writer, ref name.Reference, img v1.Image, compression string) error {
+	if isTerminal(w) {
+		return fmt.Errorf("refusing to write an image tar file to a terminal; redirect stdout or use a file path")
+	}
+	cw, err := compressWriter(w, compression)
+	if err != nil {
+		return err
+	}
+	if err := tarball.Write(ref, img, cw); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// spoolStdin copies stdin to a temporary file in dir, or in os.TempDir (which honours
+// TMPDIR) when dir is empty, so it can be read more than once, as tarball.Image
+// does, and returns its path. The whole stream is stored, so dir needs room for the
+// complete, possibly compressed, tar file. The file is removed as soon as ctx is
+// cancelled, even while stdin is still being read; otherwise the caller removes it.
+func spoolStdin(ctx context.Context, dir string) (string, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", fmt.Errorf("failed to create spool directory: %w", err)
+		}
+	}
+	tmp, err := os.CreateTemp(dir, "repo-lister-stdin-*.tar")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for stdin: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { os.Remove(tmp.Name()) })
+	defer stop()
+
+	size, err := io.Copy(tmp, &ctxReader{ctx: ctx, r: stdin})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to read image tar from stdin: %w", err)
+	}
+	logDebugf("Spooled %s from stdin to %s", FormatBytes(size), tmp.Name())
+	return tmp.Name(), nil
+}
+
+// ctxReader stops reading once ctx is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
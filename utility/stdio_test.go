@@ -0,0 +1,114 @@
+package utility
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useStdio replaces stdin and stdout for the duration of a test
+func useStdio(t *testing.T, in io.Reader, out io.Writer) {
+	t.Helper()
+
+	oldIn, oldOut := stdin, stdout
+	stdin, stdout = in, out
+	t.Cleanup(func() { stdin, stdout = oldIn, oldOut })
+}
+
+// TestPullPushStdio tests piping an image from pull on stdout to push on stdin
+func TestPullPushStdio(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress string
+	}{
+		{name: "uncompressed", compress: CompressionNone},
+		{name: "zstd", compress: CompressionZstd},
+	}
+
+	reg := newTestRegistry(t)
+	img := pushRandomImage(t, reg+"/app:v1")
+	want, _ := img.Digest()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			useStdio(t, nil, &stream)
+			if err := PullImage(context.Background(), reg+"/app:v1", "-", "", "default", PullOptions{Compress: tt.compress}); err != nil {
+				t.Fatalf("PullImage() to stdout failed: %v", err)
+			}
+			if stream.Len() == 0 {
+				t.Fatal("PullImage() wrote nothing to stdout")
+			}
+			if bytes.Contains(stream.Bytes(), []byte("Pulling image")) {
+				t.Error("Status messages were written to stdout")
+			}
+
+			useStdio(t, &stream, io.Discard)
+			dst := reg + "/piped-" + tt.name + ":v1"
+			if err := PushImage(context.Background(), dst, "-", "", "default", PushOptions{}); err != nil {
+				t.Fatalf("PushImage() from stdin failed: %v", err)
+			}
+			if got, _ := remoteImage(t, dst).Digest(); got != want {
+				t.Errorf("Pushed digest = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestPushImageEmptyStdin tests that an empty stdin is reported as an invalid tar
+func TestPushImageEmptyStdin(t *testing.T) {
+	reg := newTestRegistry(t)
+	useStdio(t, &bytes.Buffer{}, io.Discard)
+
+	if err := PushImage(context.Background(), reg+"/app:v1", "-", "", "default", PushOptions{}); err == nil {
+		t.Fatal("PushImage() from empty stdin succeeded, want error")
+	}
+}
+
+// TestSpoolStdin tests where stdin is spooled and that the spool file is removed
+// when the push is interrupted
+func TestSpoolStdin(t *testing.T) {
+	t.Run("spool directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "cache")
+		useStdio(t, strings.NewReader("image data"), io.Discard)
+
+		path, err := spoolStdin(context.Background(), dir)
+		if err != nil {
+			t.Fatalf("spoolStdin() failed: %v", err)
+		}
+		if filepath.Dir(path) != dir {
+			t.Errorf("Spool file %s is not in %s", path, dir)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "image data" {
+			t.Errorf("Spool file holds %q, want %q", data, "image data")
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		dir := t.TempDir()
+		r, w := io.Pipe()
+		useStdio(t, r, io.Discard)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			_, err := spoolStdin(ctx, dir)
+			done <- err
+		}()
+
+		w.Write([]byte("first chunk"))
+		cancel()
+		w.Close()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("spoolStdin() error = %v, want context.Canceled", err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Spool directory still holds %d files after the interruption", len(entries))
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package utility
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// TarballReport summarises a verified image tarball
+type TarballReport struct {
+	Images   int
+	Layers   int
+	Checksum string
+}
+
+// tarballLayer is a layer file referenced by manifest.json together with the
+// digests it must have
+type tarballLayer struct {
+	diffID v1.Hash
+	// digest is the compressed digest encoded in the file name, if any
+	digest *v1.Hash
+}
+
+// VerifyTarball checks that an image tarball written by pull or docker save, plain or
+// gzip/zstd compressed, is intact: every config file matches the digest in its name,
+// and every layer file decompresses to the diff ID recorded in its image config (and
+// matches the digest in its file name, when it has one). It also returns the SHA-256
+// checksum of the whole file as stored.
+func VerifyTarball(tarPath string) (TarballReport, error) {
+	var report TarballReport
+
+	// First pass: manifest.json and the image configs, which are small
+	manifest, configs, err := readTarballMetadata(tarPath)
+	if err != nil {
+		return report, err
+	}
+	if len(manifest) == 0 {
+		return report, fmt.Errorf("'%s' has an empty manifest.json", tarPath)
+	}
+
+	layers := map[string]tarballLayer{}
+	for _, entry := range manifest {
+		data, ok := configs[entry.Config]
+		if !ok {
+			return report, fmt.Errorf("config %s listed in manifest.json is missing", entry.Config)
+		}
+		if want, ok := digestFromFileName(entry.Config); ok {
+			if got := sha256Hex(data); got != want.Hex {
+				return report, fmt.Errorf("config %s is corrupt: sha256 is %s", entry.Config, got)
+			}
+		}
+
+		config, err := v1.ParseConfigFile(bytes.NewReader(data))
+		if err != nil {
+			return report, fmt.Errorf("failed to parse config %s: %w", entry.Config, err)
+		}
+		if len(config.RootFS.DiffIDs) != len(entry.Layers) {
+			return report, fmt.Errorf("config %s lists %d layers but manifest.json lists %d", entry.Config, len(config.RootFS.DiffIDs), len(entry.Layers))
+		}
+		for i, file := range entry.Layers {
+			layer := tarballLayer{diffID: config.RootFS.DiffIDs[i]}
+			if d, ok := digestFromFileName(file); ok {
+				layer.digest = &d
+			}
+			layers[file] = layer
+		}
+	}
+
+	// Second pass: hash every layer, and the file as a whole
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return report, fmt.Errorf("failed to open '%s': %w", tarPath, err)
+	}
+	defer f.Close()
+
+	// The checksum covers the file as stored, so hash before decompressing
+	fileHash := sha256.New()
+	raw := io.TeeReader(f, fileHash)
+	r, err := decompressStream(bufio.NewReader(raw))
+	if err != nil {
+		return report, fmt.Errorf("failed to decompress '%s': %w", tarPath, err)
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	verified := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("'%s' is not a valid tar archive: %w", tarPath, err)
+		}
+		layer, ok := layers[path.Clean(hdr.Name)]
+		if !ok || verified[path.Clean(hdr.Name)] {
+			continue
+		}
+		if err := verifyTarballLayer(hdr.Name, tr, layer); err != nil {
+			return report, err
+		}
+		verified[path.Clean(hdr.Name)] = true
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return report, fmt.Errorf("failed to read '%s': %w", tarPath, err)
+	}
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return report, fmt.Errorf("failed to read '%s': %w", tarPath, err)
+	}
+
+	for file := range layers {
+		if !verified[file] {
+			return report, fmt.Errorf("layer %s listed in manifest.json is missing", file)
+		}
+	}
+
+	report.Images = len(manifest)
+	report.Layers = len(layers)
+	report.Checksum = hex.EncodeToString(fileHash.Sum(nil))
+	return report, nil
+}
+
+// verifyTarballLayer hashes one layer file in its stored and uncompressed forms
+func verifyTarballLayer(file string, r io.Reader, layer tarballLayer) error {
+	compressedHash := sha256.New()
+	br := bufio.NewReader(io.TeeReader(r, compressedHash))
+
+	uncompressed, err := decompressStream(br)
+	if err != nil {
+		return fmt.Errorf("layer %s is corrupt: %w", file, err)
+	}
+	diffIDHash := sha256.New()
+	if _, err := io.Copy(diffIDHash, uncompressed); err != nil {
+		return fmt.Errorf("layer %s is corrupt or truncated: %w", file, err)
+	}
+	uncompressed.Close()
+	// Drain anything the decompressor did not consume so the stored digest is complete
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return fmt.Errorf("failed to read layer %s: %w", file, err)
+	}
+
+	if got := hex.EncodeToString(diffIDHash.Sum(nil)); got != layer.diffID.Hex {
+		return fmt.Errorf("layer %s is corrupt: diff ID is sha256:%s, config expects %s", file, got, layer.diffID)
+	}
+	if layer.digest != nil {
+		if got := hex.EncodeToString(compressedHash.Sum(nil)); got != layer.digest.Hex {
+			return fmt.Errorf("layer %s is corrupt: digest is sha256:%s, file name expects %s", file, got, layer.digest)
+		}
+	}
+	return nil
+}
+
+// readTarballMetadata returns the parsed manifest.json of an image tarball and the
+// contents of every config file it references
+func readTarballMetadata(tarPath string) (tarball.Manifest, map[string][]byte, error) {
+	f, err := openArchive(tarPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open '%s': %w", tarPath, err)
+	}
+	defer f.Close()
+
+	// Configs are only known once manifest.json is read, which may come last, so keep
+	// every small JSON file until then
+	var manifest tarball.Manifest
+	files := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("'%s' is not a valid tar archive: %w", tarPath, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > 4<<20 {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if name != "manifest.json" && !looksLikeConfig(name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		files[name] = data
+	}
+
+	data, ok := files["manifest.json"]
+	if !ok {
+		return nil, nil, fmt.Errorf("'%s' has no manifest.json, is it an image tarball?", tarPath)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+	for i := range manifest {
+		manifest[i].Config = path.Clean(manifest[i].Config)
+		for j := range manifest[i].Layers {
+			manifest[i].Layers[j] = path.Clean(manifest[i].Layers[j])
+		}
+	}
+	return manifest, files, nil
+}
+
+// looksLikeConfig reports whether a tar entry may be an image config: pull writes
+// configs as "sha256:<hex>", docker save as "<hex>.json" or "blobs/sha256/<hex>"
+func looksLikeConfig(name string) bool {
+	return strings.HasSuffix(name, ".json") ||
+		strings.HasPrefix(path.Base(name), "sha256:") ||
+		strings.HasPrefix(name, "blobs/sha256/")
+}
+
+// digestFromFileName extracts a sha256 digest from tarball entry names such as
+// "sha256:<hex>", "<hex>.tar.gz", "<hex>.json" or "blobs/sha256/<hex>". Legacy
+// "<id>/layer.tar" entries are named by layer ID rather than digest and yield none.
+func digestFromFileName(name string) (v1.Hash, bool) {
+	base := path.Base(name)
+	for _, candidate := range []string{base, "sha256:" + strings.SplitN(base, ".", 2)[0]} {
+		if h, err := v1.NewHash(candidate); err == nil {
+			return h, true
+		}
+	}
+	return v1.Hash{}, false
+}
+
+// checksumFile returns the path of the sidecar checksum file for tarPath
+func checksumFile(tarPath string) string {
+	return tarPath + ".sha256"
+}
+
+// WriteChecksumFile writes a sidecar <tarPath>.sha256 file in sha256sum format, so
+// the tarball can also be checked with "sha256sum -c"
+func WriteChecksumFile(tarPath string, checksum string) error {
+	line := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(tarPath))
+	if err := os.WriteFile(checksumFile(tarPath), []byte(line), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return nil
+}
+
+// readChecksumFile returns the checksum recorded in the sidecar file of tarPath, or
+// an empty string if there is none
+func readChecksumFile(tarPath string) (string, error) {
+	data, err := os.ReadFile(checksumFile(tarPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read checksum file: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file %s is empty", checksumFile(tarPath))
+	}
+	return fields[0], nil
+}
+
+// VerifyImageTarball verifies a tarball and its sidecar checksum file. A missing
+// checksum file is created; a mismatching one is an error.
+func VerifyImageTarball(tarPath string) error {
+	logInfof("Verifying %s...", tarPath)
+
+	report, err := VerifyTarball(tarPath)
+	if err != nil {
+		return err
+	}
+
+	recorded, err := readChecksumFile(tarPath)
+	if err != nil {
+		return err
+	}
+	switch {
+	case recorded == "":
+		if err := WriteChecksumFile(tarPath, report.Checksum); err != nil {
+			return err
+		}
+		logInfof("Wrote checksum to %s", checksumFile(tarPath))
+	case recorded != report.Checksum:
+		return fmt.Errorf("'%s' does not match %s: sha256 is %s, expected %s", tarPath, checksumFile(tarPath), report.Checksum, recorded)
+	default:
+		logInfof("Checksum matches %s", checksumFile(tarPath))
+	}
+
+	logInfof("✓ %s is intact (%d images, %d layers, sha256:%s)", tarPath, report.Images, report.Layers, report.Checksum)
+	return nil
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
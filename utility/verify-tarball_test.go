@@ -0,0 +1,210 @@
+package utility
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// writeTestTarball writes a random image tarball and returns its path
+func writeTestTarball(t *testing.T) string {
+	t.Helper()
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("Failed to create random image: %v", err)
+	}
+	ref, _ := name.ParseReference("example.com/app:v1")
+	path := filepath.Join(t.TempDir(), "image.tar")
+	if err := tarball.WriteToFile(path, ref, img); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+	return path
+}
+
+// rewriteTar copies the tar at src to a new file, passing each entry through edit,
+// which returns the new contents or false to drop the entry
+func rewriteTar(t *testing.T, src string, edit func(name string, data []byte) ([]byte, bool)) string {
+	t.Helper()
+
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("Failed to open tarball: %v", err)
+	}
+	defer in.Close()
+
+	dst := filepath.Join(t.TempDir(), "rewritten.tar")
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("Failed to create tarball: %v", err)
+	}
+	defer out.Close()
+
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tarball: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		data, keep := edit(hdr.Name, data)
+		if !keep {
+			continue
+		}
+		hdr.Size = int64(len(data))
+		_ = tw.WriteHeader(hdr)
+		_, _ = tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to finish tarball: %v", err)
+	}
+	return dst
+}
+
+// TestVerifyTarball tests detection of intact and damaged tarballs
+func TestVerifyTarball(t *testing.T) {
+	src := writeTestTarball(t)
+	manifest, _, err := readTarballMetadata(src)
+	if err != nil {
+		t.Fatalf("Failed to read tarball manifest: %v", err)
+	}
+	firstLayer := manifest[0].Layers[0]
+
+	tests := []struct {
+		name    string
+		edit    func(name string, data []byte) ([]byte, bool)
+		wantErr string
+	}{
+		{
+			name: "intact",
+			edit: func(name string, data []byte) ([]byte, bool) { return data, true },
+		},
+		{
+			name: "corrupted layer",
+			edit: func(name string, data []byte) ([]byte, bool) {
+				if name == firstLayer {
+					data = append([]byte{}, data...)
+					data[len(data)/2] ^= 0xff
+				}
+				return data, true
+			},
+			wantErr: "corrupt",
+		},
+		{
+			name: "missing layer",
+			edit: func(name string, data []byte) ([]byte, bool) {
+				return data, name != firstLayer
+			},
+			wantErr: "missing",
+		},
+		{
+			name: "corrupted config",
+			edit: func(name string, data []byte) ([]byte, bool) {
+				if strings.HasPrefix(name, "sha256:") {
+					data = append(append([]byte{}, data...), ' ')
+				}
+				return data, true
+			},
+			wantErr: "config",
+		},
+		{
+			name: "no manifest",
+			edit: func(name string, data []byte) ([]byte, bool) {
+				return data, name != "manifest.json"
+			},
+			wantErr: "manifest.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := rewriteTar(t, src, tt.edit)
+			report, err := VerifyTarball(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyTarball() failed: %v", err)
+				}
+				if report.Images != 1 || report.Layers != 2 || len(report.Checksum) != 64 {
+					t.Errorf("VerifyTarball() report = %+v, want 1 image, 2 layers and a checksum", report)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyTarball() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestVerifyTarballTruncated tests that a cut-off download is detected
+func TestVerifyTarballTruncated(t *testing.T) {
+	path := writeTestTarball(t)
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()/2); err != nil {
+		t.Fatalf("Failed to truncate tarball: %v", err)
+	}
+	if _, err := VerifyTarball(path); err == nil {
+		t.Errorf("VerifyTarball() accepted a truncated tarball")
+	}
+}
+
+// TestVerifyImageTarballChecksum tests creating and checking the sidecar checksum
+func TestVerifyImageTarballChecksum(t *testing.T) {
+	path := writeTestTarball(t)
+
+	if err := VerifyImageTarball(path); err != nil {
+		t.Fatalf("VerifyImageTarball() failed: %v", err)
+	}
+	data, err := os.ReadFile(checksumFile(path))
+	if err != nil {
+		t.Fatalf("Checksum file not written: %v", err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(string(data)), "  image.tar") {
+		t.Errorf("Checksum file = %q, want sha256sum format", data)
+	}
+
+	// A second run checks against the sidecar
+	if err := VerifyImageTarball(path); err != nil {
+		t.Fatalf("VerifyImageTarball() with checksum file failed: %v", err)
+	}
+
+	if err := WriteChecksumFile(path, strings.Repeat("0", 64)); err != nil {
+		t.Fatalf("WriteChecksumFile() failed: %v", err)
+	}
+	if err := VerifyImageTarball(path); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("VerifyImageTarball() error = %v, want checksum mismatch", err)
+	}
+}
+
+// TestDigestFromFileName tests recognising digests in tarball entry names
+func TestDigestFromFileName(t *testing.T) {
+	hex := strings.Repeat("ab", 32)
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "sha256:" + hex, want: true},
+		{name: hex + ".tar.gz", want: true},
+		{name: hex + ".json", want: true},
+		{name: "blobs/sha256/" + hex, want: true},
+		{name: hex + "/layer.tar", want: false},
+		{name: "manifest.json", want: false},
+	}
+
+	for _, tt := range tests {
+		if _, got := digestFromFileName(tt.name); got != tt.want {
+			t.Errorf("digestFromFileName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}